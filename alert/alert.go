@@ -0,0 +1,250 @@
+// Package alert evaluates alert rules against fetched prices, so a refreshing table tells when to look at it
+package alert
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/shopspring/decimal"
+)
+
+// Used if not set in a rule
+const (
+    defaultHysteresis = 0.5 // Percent
+    defaultCooldown   = 5 * time.Minute
+)
+
+// Alert is a rule fired on the price of a symbol
+type Alert struct {
+    Source  string
+    Symbol  string
+    Price   decimal.Decimal // Zero if queries of the symbol are failing
+    Message string
+    At      time.Time
+}
+
+// A rule of its condition parsed
+type rule struct {
+    *config.AlertRule
+    window time.Duration
+}
+
+// Engine keeps states of rules across refreshes, evaluating them every time results are rendered.
+// A rule fires once when its condition is met, and is re-armed only after the price moves back beyond the hysteresis,
+// and no sooner than the cooldown since it fired.
+type Engine struct {
+    // Clock tells what time it is now, replace it to test cooldowns
+    Clock func() time.Time
+
+    rules  []rule
+    states map[stateKey]*state
+}
+
+// States are kept per rule and symbol, a rule without an exchange matches symbols of all exchanges
+type stateKey struct {
+    rule int
+    slot string // Exchange and symbol
+}
+
+type state struct {
+    fired     bool
+    message   string // Of the last firing
+    firedAt   time.Time
+    above     int               // Side of the moving average, 1 above, -1 below and 0 unknown
+    samples   []decimal.Decimal // Latest prices for the moving average, oldest first
+    sampledAt time.Time         // Of the latest sample, a price is sampled once even if rendered many times
+}
+
+// NewEngine validates rules, and returns nil if there is none
+func NewEngine(rules []*config.AlertRule) (*Engine, error) {
+    if len(rules) == 0 {
+        return nil, nil
+    }
+    e := &Engine{Clock: time.Now, states: make(map[stateKey]*state)}
+    for i, r := range rules {
+        parsed, err := parseRule(r)
+        if err != nil {
+            return nil, fmt.Errorf("alert rule #%d of %s: %w", i+1, r.Symbol, err)
+        }
+        e.rules = append(e.rules, parsed)
+    }
+    return e, nil
+}
+
+func parseRule(r *config.AlertRule) (rule, error) {
+    parsed := rule{AlertRule: r}
+    if r.Symbol == "" && r.Failures == 0 {
+        return parsed, errors.New("symbol is required")
+    }
+    conditions := 0
+    if !r.Above.IsZero() {
+        conditions++
+    }
+    if !r.Below.IsZero() {
+        conditions++
+    }
+    if r.Window != "" || r.Move != 0 {
+        window, err := config.ParseWindow(r.Window)
+        if err != nil {
+            return parsed, err
+        }
+        if r.Move <= 0 {
+            return parsed, errors.New("move should be a positive percentage")
+        }
+        parsed.window = window
+        conditions++
+    }
+    if r.CrossAverage < 0 {
+        return parsed, errors.New("cross_average should be a positive number of refreshes")
+    }
+    if r.CrossAverage > 0 {
+        conditions++
+    }
+    if r.Failures < 0 {
+        return parsed, errors.New("failures should be a positive number of queries")
+    }
+    if r.Failures > 0 {
+        conditions++
+    }
+    if conditions != 1 {
+        return parsed, errors.New("expecting exactly one of above, below, window and move, cross_average, or failures")
+    }
+    return parsed, nil
+}
+
+func (r rule) matches(result *exchange.SymbolResult) bool {
+    return (r.Symbol == "" || strings.EqualFold(r.Symbol, result.Symbol)) && (r.Exchange == "" || strings.EqualFold(r.Exchange, result.Source))
+}
+
+func (r rule) hysteresis() float64 {
+    if r.Hysteresis > 0 {
+        return r.Hysteresis
+    }
+    return defaultHysteresis
+}
+
+func (r rule) cooldown() time.Duration {
+    if r.Cooldown > 0 {
+        return r.Cooldown
+    }
+    return defaultCooldown
+}
+
+// Scale a price by percent
+func scale(price decimal.Decimal, percent float64) decimal.Decimal {
+    return price.Mul(decimal.NewFromFloat(1 + percent/100))
+}
+
+// Evaluate rules against results, set messages of fired rules in Alerts of results and return the newly fired ones.
+// Only failure rules are evaluated against failed and stale results, their prices don't move.
+func (e *Engine) Evaluate(results []*exchange.SymbolResult) []Alert {
+    now := e.Clock()
+    var fired []Alert
+    for _, result := range results {
+        result.Alerts = nil
+        failed := result.Err != nil || result.Stale
+        for i, r := range e.rules {
+            if !r.matches(result) || (failed && r.Failures == 0) {
+                continue
+            }
+            key := stateKey{rule: i, slot: strings.ToUpper(result.Source) + "." + strings.ToUpper(result.Symbol)}
+            st := e.states[key]
+            if st == nil {
+                st = &state{}
+                e.states[key] = st
+            }
+            if message, ok := e.evaluate(r, st, result, now); ok {
+                a := Alert{Source: result.Source, Symbol: result.Symbol, Message: message, At: now}
+                if result.Price != nil {
+                    a.Price = result.Price.Price
+                }
+                fired = append(fired, a)
+            }
+            if st.fired {
+                result.Alerts = append(result.Alerts, st.message)
+            }
+        }
+    }
+    return fired
+}
+
+// Evaluate a rule against a result, return the message if it fires
+func (e *Engine) evaluate(r rule, st *state, result *exchange.SymbolResult, now time.Time) (string, bool) {
+    var (
+        met, rearm bool
+        message    string
+    )
+    sp, h := result.Price, r.hysteresis()
+    switch {
+    case r.Failures > 0:
+        met, rearm = result.Failures >= r.Failures, result.Failures == 0
+        message = fmt.Sprintf("%s failed to get %s %d times in a row", result.Source, result.Symbol, result.Failures)
+    case !r.Above.IsZero():
+        met, rearm = sp.Price.GreaterThan(r.Above), sp.Price.LessThan(scale(r.Above, -h))
+        message = fmt.Sprintf("%s rose above %s, at %s", sp.Symbol, r.Above, sp.Price)
+    case !r.Below.IsZero():
+        met, rearm = sp.Price.LessThan(r.Below), sp.Price.GreaterThan(scale(r.Below, h))
+        message = fmt.Sprintf("%s fell below %s, at %s", sp.Symbol, r.Below, sp.Price)
+    case r.window > 0:
+        change := sp.PercentChange(r.window)
+        if !change.Known {
+            return "", false
+        }
+        move := change.Percent
+        if move < 0 {
+            move = -move
+        }
+        met, rearm = move >= r.Move, move < r.Move-h
+        message = fmt.Sprintf("%s moved %s%% in %s, at %s", sp.Symbol, change, r.Window, sp.Price)
+    default:
+        // Crossing is an event rather than a level, it's fired until the next sample
+        if sp.UpdateAt.Equal(st.sampledAt) && !st.sampledAt.IsZero() {
+            return "", false
+        }
+        crossed, side := st.cross(r, sp.Price)
+        st.sampledAt = sp.UpdateAt
+        met, rearm = crossed, !crossed
+        message = fmt.Sprintf("%s crossed %s its average of %d refreshes, at %s", sp.Symbol, side, r.CrossAverage, sp.Price)
+    }
+
+    if st.fired {
+        if rearm {
+            st.fired = false
+        }
+        return "", false
+    }
+    if !met || (!st.firedAt.IsZero() && now.Sub(st.firedAt) < r.cooldown()) {
+        return "", false
+    }
+    st.fired, st.message, st.firedAt = true, message, now
+    return message, true
+}
+
+// Sample a price, and tell whether it crossed the moving average of previous samples, and to which side
+func (st *state) cross(r rule, price decimal.Decimal) (bool, string) {
+    crossed, side := false, ""
+    if len(st.samples) == r.CrossAverage {
+        average := decimal.Avg(st.samples[0], st.samples[1:]...)
+        h := r.hysteresis()
+        above := st.above
+        if price.GreaterThan(scale(average, h)) {
+            above = 1
+        } else if price.LessThan(scale(average, -h)) {
+            above = -1
+        }
+        crossed = st.above != 0 && above != st.above
+        if above > 0 {
+            side = "above"
+        } else {
+            side = "below"
+        }
+        st.above = above
+        st.samples = st.samples[1:]
+    }
+    st.samples = append(st.samples, price)
+    return crossed, side
+}
@@ -0,0 +1,170 @@
+package alert
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/shopspring/decimal"
+)
+
+var testTime = time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC)
+
+// A test engine with a clock advanced by tick
+type testEngine struct {
+    *Engine
+    now time.Time
+}
+
+func newTestEngine(t *testing.T, rules ...*config.AlertRule) *testEngine {
+    e, err := NewEngine(rules)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    te := &testEngine{Engine: e, now: testTime}
+    e.Clock = func() time.Time { return te.now }
+    return te
+}
+
+// Advance the clock, evaluate the price of BTCUSDT on Binance and return messages of fired rules
+func (te *testEngine) tick(price string, change1h float64) ([]Alert, *exchange.SymbolResult) {
+    te.now = te.now.Add(time.Minute)
+    sp := &exchange.SymbolPrice{Symbol: "BTCUSDT", Source: "Binance", Price: decimal.RequireFromString(price),
+        UpdateAt: te.now, PercentChange1h: exchange.KnownChange(change1h)}
+    result := &exchange.SymbolResult{Symbol: sp.Symbol, Source: sp.Source, Price: sp}
+    return te.Evaluate([]*exchange.SymbolResult{result}), result
+}
+
+func TestNewEngine(t *testing.T) {
+    if e, err := NewEngine(nil); e != nil || err != nil {
+        t.Fatalf("Expecting no engine without rules")
+    }
+    for _, r := range []*config.AlertRule{
+        {Above: decimal.NewFromInt(1)},
+        {Symbol: "BTCUSDT"},
+        {Symbol: "BTCUSDT", Above: decimal.NewFromInt(2), Below: decimal.NewFromInt(1)},
+        {Symbol: "BTCUSDT", Window: "1y", Move: 5},
+        {Symbol: "BTCUSDT", Window: "1h"},
+        {Symbol: "BTCUSDT", CrossAverage: -1},
+    } {
+        if _, err := NewEngine([]*config.AlertRule{r}); err == nil {
+            t.Fatalf("Expecting error of invalid rule %+v", r)
+        }
+    }
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+    t.Run("above with hysteresis", func(t *testing.T) {
+        e := newTestEngine(t, &config.AlertRule{Symbol: "btcusdt", Above: decimal.NewFromInt(50000), Hysteresis: 1, Cooldown: time.Minute})
+        for i, tc := range []struct {
+            price  string
+            fired  bool
+            active bool
+        }{
+            {"49000", false, false},
+            {"50001", true, true},
+            {"50002", false, true},
+            {"49800", false, true}, // Within the hysteresis
+            {"50100", false, true},
+            {"49400", false, false}, // Re-armed
+            {"50100", true, true},
+        } {
+            fired, result := e.tick(tc.price, 0)
+            if (len(fired) == 1) != tc.fired || (len(result.Alerts) == 1) != tc.active {
+                t.Fatalf("Unexpected alerts at #%d of %s, fired %v, active %v", i, tc.price, fired, result.Alerts)
+            }
+        }
+    })
+
+    t.Run("cooldown", func(t *testing.T) {
+        e := newTestEngine(t, &config.AlertRule{Exchange: "Binance", Symbol: "BTCUSDT", Below: decimal.NewFromInt(40000), Cooldown: 3 * time.Minute})
+        if fired, _ := e.tick("39000", 0); len(fired) != 1 || fired[0].Message != "BTCUSDT fell below 40000, at 39000" {
+            t.Fatalf("Expecting an alert, got %v", fired)
+        }
+        e.tick("41000", 0)
+        if fired, _ := e.tick("39000", 0); len(fired) != 0 {
+            t.Fatalf("Expecting no alert in cooldown, got %v", fired)
+        }
+        if fired, _ := e.tick("39000", 0); len(fired) != 1 {
+            t.Fatalf("Expecting an alert after cooldown, got %v", fired)
+        }
+    })
+
+    t.Run("percent move", func(t *testing.T) {
+        e := newTestEngine(t, &config.AlertRule{Symbol: "BTCUSDT", Window: "60m", Move: 5, Cooldown: time.Second})
+        if fired, _ := e.tick("1", -4.9); len(fired) != 0 {
+            t.Fatalf("Expecting no alert, got %v", fired)
+        }
+        if fired, _ := e.tick("1", -5.1); len(fired) != 1 || fired[0].Message != "BTCUSDT moved -5.10% in 60m, at 1" {
+            t.Fatalf("Expecting an alert, got %v", fired)
+        }
+        e.tick("1", 4)
+        if fired, _ := e.tick("1", 6); len(fired) != 1 {
+            t.Fatalf("Expecting an alert in the other direction, got %v", fired)
+        }
+    })
+
+    t.Run("cross average", func(t *testing.T) {
+        e := newTestEngine(t, &config.AlertRule{Symbol: "BTCUSDT", CrossAverage: 3, Cooldown: time.Second})
+        for i, tc := range []struct {
+            price string
+            fired bool
+        }{
+            {"100", false},
+            {"100", false},
+            {"100", false},
+            {"90", false}, // Below the average, but no side before
+            {"120", true}, // Above 96.67
+            {"125", false},
+            {"111.5", false}, // Around 111.67 within the hysteresis
+            {"100", true},
+        } {
+            fired, result := e.tick(tc.price, 0)
+            if (len(fired) == 1) != tc.fired {
+                t.Fatalf("Unexpected alerts at #%d of %s, fired %v", i, tc.price, fired)
+            }
+            if tc.fired {
+                // Rendering the same price again changes nothing
+                again := e.Evaluate([]*exchange.SymbolResult{result})
+                if len(again) != 0 || len(result.Alerts) != 1 {
+                    t.Fatalf("Expecting the same alert kept, got %v and %v", again, result.Alerts)
+                }
+            }
+        }
+    })
+
+    t.Run("failures in a row", func(t *testing.T) {
+        e := newTestEngine(t, &config.AlertRule{Exchange: "binance", Failures: 3, Cooldown: time.Second})
+        for i, tc := range []struct {
+            failures int
+            fired    bool
+        }{{1, false}, {3, true}, {4, false}, {0, false}, {3, true}} {
+            result := &exchange.SymbolResult{Symbol: "ETHBTC", Source: "Binance", Err: errors.New("timeout"), Failures: tc.failures}
+            if tc.failures == 0 {
+                result = &exchange.SymbolResult{Symbol: "ETHBTC", Source: "Binance", Price: &exchange.SymbolPrice{Price: decimal.NewFromInt(1)}}
+            }
+            e.now = e.now.Add(time.Minute)
+            fired := e.Evaluate([]*exchange.SymbolResult{result})
+            if (len(fired) == 1) != tc.fired {
+                t.Fatalf("Unexpected alerts at #%d, fired %v", i, fired)
+            }
+            if tc.fired && fired[0].Message != "Binance failed to get ETHBTC 3 times in a row" {
+                t.Fatalf("Unexpected message %q", fired[0].Message)
+            }
+        }
+    })
+
+    t.Run("failed and other symbols", func(t *testing.T) {
+        e := newTestEngine(t, &config.AlertRule{Exchange: "Huobi", Symbol: "BTCUSDT", Above: decimal.NewFromInt(1)})
+        if fired, _ := e.tick("2", 0); len(fired) != 0 {
+            t.Fatalf("Expecting no alert of another exchange, got %v", fired)
+        }
+        stale := &exchange.SymbolResult{Symbol: "BTCUSDT", Source: "Huobi", Stale: true,
+            Price: &exchange.SymbolPrice{Symbol: "BTCUSDT", Source: "Huobi", Price: decimal.NewFromInt(2)}}
+        if fired := e.Evaluate([]*exchange.SymbolResult{stale}); len(fired) != 0 {
+            t.Fatalf("Expecting no alert of stale prices, got %v", fired)
+        }
+    })
+}
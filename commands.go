@@ -0,0 +1,142 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "strings"
+
+    "github.com/polyrabbit/my-token/alert"
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/polyrabbit/my-token/http"
+    "github.com/polyrabbit/my-token/notify"
+    "github.com/polyrabbit/my-token/writer"
+    "github.com/sirupsen/logrus"
+    "github.com/spf13/pflag"
+)
+
+var exchangesCommand = &command{
+    name:    "exchanges",
+    summary: "List supported exchanges",
+    run: func(ctx context.Context, fs *pflag.FlagSet) error {
+        cfg := &config.Config{}
+        httpClient, err := http.New(cfg)
+        if err != nil {
+            return err
+        }
+        for _, name := range exchange.NewRegistry(cfg, httpClient).GetAllNames() {
+            fmt.Println(name)
+        }
+        return nil
+    },
+}
+
+var configCommand = &command{
+    name:    "config",
+    args:    "init [path] | validate | show",
+    summary: "Generate, validate or show the config file",
+    help: "Actions:\n" +
+        "  init      Generate an example config file to the path, \"my_token.yml\" by default, or stdout if it's \"-\"\n" +
+        "  validate  Check the config file, including exchanges, alert rules and notifiers\n" +
+        "  show      Print settings in effect, merged from the config file, options and defaults, secrets are masked",
+    flags: config.AddConfigFlags,
+    run:   runConfig,
+}
+
+func runConfig(ctx context.Context, fs *pflag.FlagSet) error {
+    switch action := fs.Arg(0); action {
+    case "init":
+        path := fs.Arg(1)
+        if path == "" {
+            path = "my_token.yml"
+        }
+        return writeExampleConfig(path)
+    case "validate", "show":
+        cfg, err := config.Load(fs)
+        if err != nil {
+            return err
+        }
+        if cfg.File == "" {
+            return fmt.Errorf("no config file found, generate one with '%s config init'", program)
+        }
+        if action == "show" {
+            return cfg.WriteYAML(os.Stdout)
+        }
+        if err := validate(cfg); err != nil {
+            return fmt.Errorf("%s: %w", cfg.File, err)
+        }
+        fmt.Printf("%s is valid\n", cfg.File)
+        return nil
+    case "":
+        return fmt.Errorf("specify an action, one of init, validate or show, see '%s help config'", program)
+    default:
+        return fmt.Errorf("unknown action %q, expecting one of init, validate or show", action)
+    }
+}
+
+func writeExampleConfig(path string) error {
+    if err := config.WriteExample(path); err != nil {
+        return err
+    }
+    if path != "-" {
+        logrus.Infof("Write example config file to %s", path)
+    }
+    return nil
+}
+
+// Check what watch would fail on, without sending any request
+func validate(cfg *config.Config) error {
+    httpClient, err := http.New(cfg)
+    if err != nil {
+        return err
+    }
+    supported := make(map[string]bool)
+    for _, name := range exchange.NewRegistry(cfg, httpClient).GetAllNames() {
+        supported[strings.ToUpper(name)] = true
+    }
+    for _, query := range cfg.Queries {
+        if !supported[strings.ToUpper(query.Name)] {
+            return fmt.Errorf("unknown exchange %s, list supported ones with '%s exchanges'", query.Name, program)
+        }
+    }
+    if _, err := writer.New(cfg); err != nil {
+        return err
+    }
+    if _, err := alert.NewEngine(cfg.Alerts); err != nil {
+        return err
+    }
+    _, err = notify.New(cfg.Notifiers, httpClient.Plain())
+    return err
+}
+
+var versionCommand = &command{
+    name:    "version",
+    summary: "Show version number",
+    run: func(ctx context.Context, fs *pflag.FlagSet) error {
+        fmt.Println(config.VersionString())
+        return nil
+    },
+}
+
+var helpCommand = &command{
+    name:    "help",
+    args:    "[command]",
+    summary: "Show usage of a command",
+    run: func(ctx context.Context, fs *pflag.FlagSet) error {
+        if fs.NArg() == 0 {
+            printUsage()
+            return nil
+        }
+        cmd := lookupCommand(fs.Arg(0))
+        if cmd == nil {
+            return fmt.Errorf("unknown command %q, see '%s help'", fs.Arg(0), program)
+        }
+        cmdFlags := config.NewFlagSet(cmd.name)
+        if cmd.flags != nil {
+            cmd.flags(cmdFlags)
+        }
+        printCommandUsage(cmd, cmdFlags)
+        return nil
+    },
+}
@@ -2,14 +2,21 @@ package config
 
 import (
     _ "embed"
+    "errors"
     "fmt"
+    "io"
     "os"
+    "reflect"
+    "sort"
     "strings"
+    "time"
 
-    "github.com/mattn/go-colorable"
+    "github.com/mitchellh/mapstructure"
+    "github.com/shopspring/decimal"
     "github.com/sirupsen/logrus"
     "github.com/spf13/pflag"
     "github.com/spf13/viper"
+    "gopkg.in/yaml.v2"
 )
 
 // Will be set by go-build
@@ -21,143 +28,375 @@ var (
     exampleConfig string
 )
 
-func Parse() *Config {
-    // Set log format
-    formatter := &logrus.TextFormatter{
-        FullTimestamp:   true,
-        TimestampFormat: "15:04:05",
-    }
-    logrus.SetFormatter(formatter)
-    logrus.SetOutput(colorable.NewColorableStderr()) // For Windows
-
-    showVersion := pflag.BoolP("Version", "v", false, "Show Version number")
-    showHelp := pflag.BoolP("help", "h", false, "Show usage message")
-    pflag.CommandLine.MarkHidden("help")
-    pflag.BoolP("debug", "d", false, "Enable debug mode")
-    pflag.BoolP("list-exchanges", "l", false, "List supported exchanges")
-    pflag.IntP("refresh", "r", 0, "Auto refresh on every specified seconds, "+
-        "note every exchange has a rate limit, \ntoo frequent refresh may cause your IP banned by their servers")
+// Defaults of settings, the same whether or not a command has flags of them
+const (
+    defaultOutput        = OutputTable
+    defaultTimeout       = 20
+    defaultRetries       = 2
+    defaultRetryDelay    = 500 * time.Millisecond
+    defaultRetryMaxDelay = 10 * time.Second
+    defaultRetryJitter   = 0.2
+    defaultOHLCInterval  = "1h"
+    defaultOHLCLast      = 24
+)
+
+// Settings of flags, keyed by flag names, flags not here are options of commands only, eg. config-file
+var flagKeys = map[string]string{
+    "debug":           "debug",
+    "refresh":         "refresh",
+    "deadline":        "deadline",
+    "stale-after":     "stale_after",
+    "stream":          "stream",
+    "show":            "show",
+    "output":          "output",
+    "proxy":           "proxy",
+    "timeout":         "timeout",
+    "retries":         "retry.count",
+    "retry-delay":     "retry.base_delay",
+    "retry-max-delay": "retry.max_delay",
+    "retry-jitter":    "retry.jitter",
+    "record":          "record",
+    "replay":          "replay",
+    "history-file":    "history.path",
+    "interval":        "ohlc.interval",
+    "last":            "ohlc.last",
+}
+
+// NewFlagSet creates an empty set of flags for a command, parsing errors are returned instead of printed,
+// commands print their own usages
+func NewFlagSet(command string) *pflag.FlagSet {
+    fs := pflag.NewFlagSet(command, pflag.ContinueOnError)
+    fs.SortFlags = false
+    fs.Usage = func() {}
+    return fs
+}
 
-    var configFile string
-    pflag.StringVarP(&configFile, "config-file", "c", "", `Config file path, use "--example-config-file <path>" `+
+// AddConfigFlags adds flags of every command loading the config file
+func AddConfigFlags(fs *pflag.FlagSet) {
+    fs.StringP("config-file", "c", "", `Config file path, use "mt config init <path>" `+
         "to generate an example config file,\n"+
         "by default my-token uses \"my_token.yml\" in current directory or $HOME as config file")
-    var exampleConfigFile string
-    pflag.StringVar(&exampleConfigFile, "example-config-file", "",
-        "Generate example config file to the specified file path, by default it outputs to stdout")
-    pflag.Lookup("example-config-file").NoOptDefVal = "-"
+    fs.BoolP("debug", "d", false, "Enable debug mode")
+}
 
-    pflag.StringSliceP("show", "s", supportedColumns(), "Only show comma-separated columns")
-    pflag.StringP("proxy", "p", "", "Proxy used when sending HTTP request \n(eg. "+
+// AddHTTPFlags adds flags of commands sending requests to exchanges
+func AddHTTPFlags(fs *pflag.FlagSet) {
+    fs.StringP("proxy", "p", "", "Proxy used when sending HTTP request \n(eg. "+
         "\"http://localhost:7777\", \"https://localhost:7777\", \"socks5://localhost:1080\")")
-    pflag.IntP("timeout", "t", 20, "HTTP request timeout in seconds")
-    pflag.CommandLine.SortFlags = false
-    pflag.Usage = showUsageAndExit
-    pflag.Parse()
+    fs.IntP("timeout", "t", defaultTimeout, "HTTP request timeout in seconds")
+    fs.Int("retries", defaultRetries, "Retry times on timeouts, connection resets, HTTP 429 and 5xx responses")
+    fs.Duration("retry-delay", defaultRetryDelay, "Delay before the first retry, doubled on every retry")
+    fs.Duration("retry-max-delay", defaultRetryMaxDelay, "Max delay between retries")
+    fs.Float64("retry-jitter", defaultRetryJitter, "Randomly spread retry delays by this factor, so clients don't retry in lockstep")
+    fs.String("record", "", "Record all HTTP requests and responses to the specified cassette file, "+
+        "\nattach it to a bug report so others can reproduce what you saw")
+    fs.String("replay", "", "Replay HTTP responses from the specified cassette file instead of sending requests")
+}
+
+// AddWatchFlags adds flags of refreshing prices
+func AddWatchFlags(fs *pflag.FlagSet) {
+    fs.IntP("refresh", "r", 0, "Auto refresh on every specified seconds, "+
+        "note every exchange has a rate limit, \ntoo frequent refresh may cause your IP banned by their servers")
+    fs.Duration("deadline", 0, "Give up on exchanges not responding within this duration in each refresh and show them as stale,\n"+
+        "by default it equals the refresh interval, and there is no deadline without auto refresh")
+    fs.Duration("stale-after", 0, "Keep showing the last known price when an exchange fails, mark it stale after this duration,\n"+
+        "by default it's twice the refresh interval")
+    fs.Bool("stream", false, "Stream prices tick-by-tick from exchanges publishing ticker streams, others are still polled,\n"+
+        "polling goes on as a fallback on every refresh interval, which is 60 seconds by default when streaming")
+}
 
-    if *showHelp {
-        showUsageAndExit()
+// AddColumnFlags adds flags of choosing columns
+func AddColumnFlags(fs *pflag.FlagSet) {
+    fs.StringSliceP("show", "s", defaultColumns(), "Only show comma-separated columns, supported columns are\n"+
+        strings.Join(supportedColumns(), ",")+",\nand changes of any window, eg. %Change(15m), %Change(4h), %Change(7d)")
+}
+
+// AddOutputFlag adds the flag of output format
+func AddOutputFlag(fs *pflag.FlagSet) {
+    fs.StringP("output", "o", defaultOutput, "Output format, one of table, json, ndjson, csv, tsv,\n"+
+        "all but table are machine readable, eg. pipe json into jq")
+}
+
+// AddHistoryFlag adds the flag of the history file
+func AddHistoryFlag(fs *pflag.FlagSet, usage string) {
+    fs.String("history-file", "", usage)
+}
+
+// AddOHLCFlags adds flags of candles
+func AddOHLCFlags(fs *pflag.FlagSet) {
+    fs.String("interval", defaultOHLCInterval, "Interval of candles, eg. 15m, 4h, 1d or 1w")
+    fs.Int("last", defaultOHLCLast, "Number of candles")
+}
+
+// Load reads the config file, overridden by flags set in fs, a missing config file is fine unless one is specified
+func Load(fs *pflag.FlagSet) (*Config, error) {
+    v := viper.New()
+    for key, value := range map[string]interface{}{
+        "output":           defaultOutput,
+        "show":             defaultColumns(),
+        "timeout":          defaultTimeout,
+        "retry.count":      defaultRetries,
+        "retry.base_delay": defaultRetryDelay,
+        "retry.max_delay":  defaultRetryMaxDelay,
+        "retry.jitter":     defaultRetryJitter,
+        "ohlc.interval":    defaultOHLCInterval,
+        "ohlc.last":        defaultOHLCLast,
+    } {
+        v.SetDefault(key, value)
+    }
+    fs.VisitAll(func(f *pflag.Flag) {
+        if key, ok := flagKeys[f.Name]; ok {
+            v.BindPFlag(key, f)
+        }
+    })
+    // Set configure file
+    v.SetConfigName("my_token") // name of config file (without extension)
+    v.AddConfigPath(".")        // path to look for the config file in
+    v.AddConfigPath("$HOME")    // optionally look for config in the HOME directory
+    v.AddConfigPath("/etc")     // and /etc
+    if configFile, _ := fs.GetString("config-file"); configFile != "" {
+        v.SetConfigFile(configFile)
+    }
+    if err := v.ReadInConfig(); err != nil {
+        var notFound viper.ConfigFileNotFoundError
+        if !errors.As(err, &notFound) {
+            return nil, fmt.Errorf("failed to read config file: %w", err)
+        }
+    }
+    var cfg Config
+    if err := v.Unmarshal(&cfg, viper.DecodeHook(decodeHook())); err != nil {
+        return nil, fmt.Errorf("failed to parse %q: %w", v.ConfigFileUsed(), err)
+    }
+    cfg.File, cfg.settings = v.ConfigFileUsed(), v.AllSettings()
+    if cfg.Debug {
+        logrus.SetLevel(logrus.DebugLevel)
+    }
+    logrus.Debugln("Using config file:", cfg.File)
+    if err := cfg.Validate(); err != nil {
+        return nil, err
+    }
+    if cfg.Stream && cfg.Replay != "" {
+        logrus.Warnln("Streaming is not supported when replaying, polling instead")
+        cfg.Stream = false
+    }
+    if cfg.Stream && cfg.Refresh == 0 {
+        cfg.Refresh = 60 // Streams push prices, polling is only a fallback
     }
+    return &cfg, nil
+}
 
-    if *showVersion {
-        fmt.Fprintf(os.Stderr, "Version %s", Version)
-        if Rev != "" {
-            fmt.Fprintf(os.Stderr, ", commit %s", Rev)
+// Validate checks settings not depending on other packages, exchanges, alerts and notifiers are checked where they are made
+func (c *Config) Validate() error {
+    if c.Record != "" && c.Replay != "" {
+        return errors.New("cannot record and replay at the same time")
+    }
+    if err := ValidateColumns(c.Columns); err != nil {
+        return err
+    }
+    if c.Refresh < 0 {
+        return fmt.Errorf("invalid refresh %d, expecting seconds not negative", c.Refresh)
+    }
+    if c.Deadline < 0 {
+        return fmt.Errorf("invalid deadline %s, expecting a duration not negative", c.Deadline)
+    }
+    if c.StaleAfter < 0 {
+        return fmt.Errorf("invalid stale_after %s, expecting a duration not negative", c.StaleAfter)
+    }
+    if c.History.Retention != "" {
+        if _, err := ParseWindow(c.History.Retention); err != nil {
+            return fmt.Errorf("invalid history retention: %w", err)
+        }
+    }
+    if _, err := ParseWindow(c.OHLC.Interval); err != nil {
+        return fmt.Errorf("invalid ohlc interval: %w", err)
+    }
+    if c.OHLC.Last <= 0 {
+        return fmt.Errorf("invalid number of candles %d, expecting a positive one", c.OHLC.Last)
+    }
+    for _, query := range c.Queries {
+        if err := query.validatePairs(); err != nil {
+            return err
         }
-        if Date != "" {
-            fmt.Fprintf(os.Stderr, ", built at %s", Date)
+    }
+    return nil
+}
+
+// ValidateColumns checks every column is supported, or a change of some window, eg. %Change(15m)
+func ValidateColumns(columns []string) error {
+    supported := make(map[string]bool)
+    for _, column := range supportedColumns() {
+        supported[strings.ToLower(column)] = true
+    }
+    for _, column := range columns {
+        if _, ok := ParseChangeColumn(column); !ok && !supported[strings.ToLower(column)] {
+            return fmt.Errorf("unknown column %q, supported columns are %s and changes of any window, eg. %%Change(15m)",
+                column, strings.Join(supportedColumns(), ","))
         }
-        fmt.Fprintln(os.Stderr)
-        os.Exit(0)
     }
+    return nil
+}
 
-    if exampleConfigFile != "" {
-        writeExampleConfig(exampleConfigFile)
-        os.Exit(0)
+// SetQueries replaces queries in the config file with the ones in command-line arguments, if any
+func (c *Config) SetQueries(args []string) error {
+    queries, err := parseQueryFromCLI(args)
+    if err != nil {
+        return err
     }
+    if len(queries) > 0 {
+        c.Queries = queries
+    }
+    return nil
+}
 
-    viper.BindPFlags(pflag.CommandLine)
-    // Set configure file
-    viper.SetConfigName("my_token") // name of config file (without extension)
-    // viper.SetConfigName("token_ticker") // for compatibility reason
-    viper.AddConfigPath(".")     // path to look for the config file in
-    viper.AddConfigPath("$HOME") // optionally look for config in the HOME directory
-    viper.AddConfigPath("/etc")  // and /etc
-    if configFile != "" {
-        viper.SetConfigFile(configFile)
-    }
-    err := viper.ReadInConfig() // Find and read the config file
+// Settings holding secrets, masked when shown, webhook urls of Slack and Discord carry the token in the path
+var secretKeys = map[string]bool{"api_key": true, "password": true, "username": true, "url": true}
+
+// Settings whose values are all secrets, eg. "Authorization: Bearer ..." in webhook headers
+var secretMaps = map[string]bool{"headers": true}
+
+const masked = "********"
+
+// WriteYAML writes settings in effect, merged from the config file, flags and defaults, secrets are masked,
+// so it's safe to paste into a bug report
+func (c *Config) WriteYAML(w io.Writer) error {
+    out, err := yaml.Marshal(printable(c.settings))
     if err != nil {
-        switch err.(type) {
-        case viper.ConfigFileNotFoundError:
-            if pflag.NArg() == 0 { // And no specified tokens
-                showUsageAndExit()
+        return err
+    }
+    _, err = w.Write(out)
+    return err
+}
+
+// Mask secrets, and show durations as they are written, yaml takes them as plain integers
+func printable(setting interface{}) interface{} {
+    switch s := setting.(type) {
+    case map[string]interface{}:
+        keys := make([]string, 0, len(s))
+        for key := range s {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        m := make(yaml.MapSlice, 0, len(s))
+        for _, key := range keys {
+            value := s[key]
+            if secretKeys[strings.ToLower(key)] && value != "" {
+                value = masked
             }
-        default:
-            logrus.Warnf("Error reading config file: %v", err)
+            if secretMaps[strings.ToLower(key)] {
+                value = maskValues(value)
+            }
+            m = append(m, yaml.MapItem{Key: key, Value: printable(value)})
         }
+        return m
+    case map[interface{}]interface{}:
+        m := make(map[string]interface{}, len(s))
+        for key, value := range s {
+            m[fmt.Sprint(key)] = value
+        }
+        return printable(m)
+    case []interface{}:
+        l := make([]interface{}, len(s))
+        for i, value := range s {
+            l[i] = printable(value)
+        }
+        return l
+    case time.Duration:
+        return s.String()
     }
-    var cfg Config
-    if err := viper.Unmarshal(&cfg); err != nil {
-        logrus.Fatalf("Failed to parse %q, error: %s\n", viper.ConfigFileUsed(), err)
-    }
-    if cfg.Debug {
-        logrus.SetLevel(logrus.DebugLevel)
+    return setting
+}
+
+// Mask every value of a map, keys are kept to tell what's set
+func maskValues(setting interface{}) interface{} {
+    var keys []string
+    switch s := setting.(type) {
+    case map[string]interface{}:
+        for key := range s {
+            keys = append(keys, key)
+        }
+    case map[interface{}]interface{}:
+        for key := range s {
+            keys = append(keys, fmt.Sprint(key))
+        }
+    case map[string]string:
+        for key := range s {
+            keys = append(keys, key)
+        }
+    default:
+        return setting
     }
-    if pflag.NArg() != 0 {
-        // command-line queries take precedence
-        cfg.Queries = parseQueryFromCLI(pflag.Args())
+    m := make(map[string]interface{}, len(keys))
+    for _, key := range keys {
+        m[key] = masked
     }
-    logrus.Debugln("Using config file:", viper.ConfigFileUsed())
-    return &cfg
+    return m
 }
 
-func showUsageAndExit() {
-    // Print usage message and exit
-    fmt.Fprintf(os.Stderr, "\nUsage: %s [Options] [Exchange1.Token1 Exchange2.Token2.<api_key> ...]\n", os.Args[0])
-    fmt.Fprintln(os.Stderr, "\nTrack token prices of your favorite exchanges in the terminal")
-    fmt.Fprintln(os.Stderr, "\nOptions:")
-    pflag.PrintDefaults()
-    fmt.Fprintln(os.Stderr, "\nSpace-separated exchange.token pairs:")
-    fmt.Fprintln(os.Stderr, "  Specify which exchange and token pair to query, different exchanges use different forms to express tokens/trading pairs, refer to their URLs to find the format"+
-        " (eg. \"Bitfinex.BTCUSDT\"). Optionally you can set api_key in the third place.")
-    fmt.Fprintln(os.Stderr, "\nFind help/updates from here - https://github.com/polyrabbit/my-token")
-    os.Exit(0)
+// VersionString tells the version, commit and build time if known
+func VersionString() string {
+    s := "Version " + Version
+    if Rev != "" {
+        s += ", commit " + Rev
+    }
+    if Date != "" {
+        s += ", built at " + Date
+    }
+    return s
 }
 
-func writeExampleConfig(fpath string) {
+// WriteExample writes the example config file to path, or stdout if it's "-", an existing file is not overwritten
+func WriteExample(path string) error {
     if exampleConfig == "" {
-        logrus.Fatalln("example config should be set by build script!")
+        return errors.New("example config should be set by build script")
     }
-    fout, err := os.Stdout, error(nil)
-    if fpath != "-" {
-        if _, err := os.Stat(fpath); err == nil {
-            logrus.Warnf("%s already exists, skipping", fpath)
-            return
-        }
-        if fout, err = os.Create(fpath); err != nil {
-            logrus.Errorf("Failed to create config file %s, error: %v", fpath, err)
-            return
-        }
-        defer fout.Close()
+    if path == "-" {
+        _, err := os.Stdout.WriteString(exampleConfig)
+        return err
     }
+    if _, err := os.Stat(path); err == nil {
+        return fmt.Errorf("%s already exists", path)
+    }
+    fout, err := os.Create(path)
+    if err != nil {
+        return fmt.Errorf("failed to create config file %s: %w", path, err)
+    }
+    defer fout.Close()
     if _, err := fout.WriteString(exampleConfig); err != nil {
-        logrus.Errorf("Failed to write config file %s, error: %v", fpath, err)
-    } else if fout != os.Stdout {
-        logrus.Infof("Write example config file to %s", fpath)
+        return fmt.Errorf("failed to write config file %s: %w", path, err)
     }
+    return nil
 }
 
-func ListExchangesAndExit(exchanges []string) {
-    fmt.Fprintln(os.Stderr, "Supported exchanges:")
-    for _, name := range exchanges {
-        fmt.Fprintf(os.Stderr, " %s\n", name)
-    }
-    os.Exit(0)
+// Viper's default hooks, plus tokens given as plain symbols and decimals given as numbers or strings
+func decodeHook() mapstructure.DecodeHookFunc {
+    return mapstructure.ComposeDecodeHookFunc(
+        mapstructure.StringToTimeDurationHookFunc(),
+        mapstructure.StringToSliceHookFunc(","),
+        func(from, to reflect.Type, data interface{}) (interface{}, error) {
+            if to != reflect.TypeOf(Token{}) || from.Kind() != reflect.String {
+                return data, nil
+            }
+            return Token{Symbol: data.(string)}, nil
+        },
+        func(from, to reflect.Type, data interface{}) (interface{}, error) {
+            if to != reflect.TypeOf(decimal.Decimal{}) {
+                return data, nil
+            }
+            switch v := data.(type) {
+            case string:
+                return decimal.NewFromString(strings.TrimSpace(v))
+            case float64:
+                return decimal.NewFromFloat(v), nil
+            case int:
+                return decimal.NewFromInt(int64(v)), nil
+            }
+            return data, nil
+        },
+    )
 }
 
 // CLI format exchange.token.<api_key> - api_key is optional
-func parseQueryFromCLI(cliArgs []string) []*PriceQuery {
+func parseQueryFromCLI(cliArgs []string) ([]*PriceQuery, error) {
     var (
         lastExchangeDef = &PriceQuery{}
         exchangeList    []*PriceQuery
@@ -165,16 +404,16 @@ func parseQueryFromCLI(cliArgs []string) []*PriceQuery {
     for _, arg := range cliArgs {
         tokenDef := strings.SplitN(arg, ".", -1)
         if len(tokenDef) < 2 {
-            logrus.Fatalf("Unrecognized token definition - %s, expecting {exchange}.{token}.<api_key>\n", arg)
+            return nil, fmt.Errorf("unrecognized token definition - %s, expecting {exchange}.{token}.<api_key>", arg)
         }
         if lastExchangeDef.Name == tokenDef[0] {
             // Merge consecutive exchange definitions
             // Do not sort/reorder here, to remain the order user specified
-            lastExchangeDef.Tokens = append(lastExchangeDef.Tokens, tokenDef[1])
+            lastExchangeDef.Tokens = append(lastExchangeDef.Tokens, Token{Symbol: tokenDef[1]})
         } else {
             exchangeDef := &PriceQuery{
                 Name:   tokenDef[0],
-                Tokens: []string{tokenDef[1]},
+                Tokens: []Token{{Symbol: tokenDef[1]}},
             }
             lastExchangeDef = exchangeDef
             exchangeList = append(exchangeList, exchangeDef)
@@ -184,5 +423,10 @@ func parseQueryFromCLI(cliArgs []string) []*PriceQuery {
             exchangeList[len(exchangeList)-1].APIKey = tokenDef[2]
         }
     }
-    return exchangeList
+    for _, query := range exchangeList {
+        if err := query.validatePairs(); err != nil {
+            return nil, err
+        }
+    }
+    return exchangeList, nil
 }
@@ -0,0 +1,74 @@
+package config
+
+import (
+    "bytes"
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+const secretConfig = `
+exchanges:
+  - name: CoinMarketCap
+    tokens:
+      - BTC
+    api_key: secret-api-key
+notifiers:
+  - type: webhook
+    url: https://hooks.slack.com/services/secret-webhook-path
+    headers:
+      Authorization: Bearer secret-bearer-token
+  - type: smtp
+    host: smtp.example.com
+    username: secret-user@example.com
+    password: secret-password
+`
+
+func TestConfig_WriteYAML(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "my_token.yml")
+    if err := ioutil.WriteFile(path, []byte(secretConfig), 0644); err != nil {
+        t.Fatalf("Failed to write config: %v", err)
+    }
+    fs := NewFlagSet("config")
+    AddConfigFlags(fs)
+    if err := fs.Parse([]string{"-c", path}); err != nil {
+        t.Fatalf("Failed to parse flags: %v", err)
+    }
+    cfg, err := Load(fs)
+    if err != nil {
+        t.Fatalf("Failed to load config: %v", err)
+    }
+
+    var out bytes.Buffer
+    if err := cfg.WriteYAML(&out); err != nil {
+        t.Fatalf("Failed to write yaml: %v", err)
+    }
+    if strings.Contains(out.String(), "secret") {
+        t.Fatalf("Expecting secrets masked, got\n%s", out.String())
+    }
+    for _, kept := range []string{"smtp.example.com", "Authorization: '********'", "username: '********'", "url: '********'", "timeout: 20"} {
+        if !strings.Contains(out.String(), kept) {
+            t.Fatalf("Expecting %q shown, got\n%s", kept, out.String())
+        }
+    }
+}
+
+func TestLoad_negativeDurations(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "my_token.yml")
+    if err := ioutil.WriteFile(path, []byte("refresh: 0\n"), 0644); err != nil {
+        t.Fatalf("Failed to write config: %v", err)
+    }
+    for _, args := range [][]string{{"-r", "1"}, {"-r", "-1"}, {"--deadline", "-1s"}, {"--stale-after", "-1m"}} {
+        fs := NewFlagSet("watch")
+        AddConfigFlags(fs)
+        AddWatchFlags(fs)
+        if err := fs.Parse(append(args, "-c", path)); err != nil {
+            t.Fatalf("Failed to parse flags: %v", err)
+        }
+        _, err := Load(fs)
+        if valid := args[1] == "1"; valid != (err == nil) {
+            t.Fatalf("Expecting %v valid: %v, got error %v", args, valid, err)
+        }
+    }
+}
@@ -1,33 +1,271 @@
 package config
 
-import "strings"
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/shopspring/decimal"
+)
 
 const (
     ColumnSymbol       = "Symbol"
     ColumnPrice        = "Price"
+    ColumnQuote        = "Quote"
+    ColumnBid          = "Bid"
+    ColumnAsk          = "Ask"
+    ColumnHigh24h      = "High(24h)"
+    ColumnLow24h       = "Low(24h)"
+    ColumnVolume24h    = "Volume(24h)"
     ColumnChange1hPct  = "%Change(1h)"
     ColumnChange24hPct = "%Change(24h)"
     ColumnSource       = "Source"
     ColumnUpdated      = "Updated"
+    // Portfolio columns, of tokens having an amount
+    ColumnHolding = "Holding"
+    ColumnValue   = "Value"
+    ColumnPnL     = "P&L"
+    ColumnPnLPct  = "P&L%"
 )
 
 func supportedColumns() []string {
+    return []string{ColumnSymbol, ColumnPrice, ColumnQuote, ColumnBid, ColumnAsk, ColumnHigh24h, ColumnLow24h, ColumnVolume24h,
+        ColumnChange1hPct, ColumnChange24hPct, ColumnSource, ColumnUpdated, ColumnHolding, ColumnValue, ColumnPnL, ColumnPnLPct}
+}
+
+// Columns shown if not specified, the rest are too wide to fit in a terminal all together
+func defaultColumns() []string {
     return []string{ColumnSymbol, ColumnPrice, ColumnChange1hPct, ColumnChange24hPct, ColumnSource, ColumnUpdated}
 }
 
+// Change columns take any window, eg. "%Change(15m)", "%Change(7d)"
+const (
+    changeColumnPrefix = "%Change("
+    changeColumnSuffix = ")"
+)
+
+// ParseChangeColumn returns the window of a change column, false if it's not one
+func ParseChangeColumn(column string) (time.Duration, bool) {
+    lower := strings.ToLower(column)
+    if !strings.HasPrefix(lower, strings.ToLower(changeColumnPrefix)) || !strings.HasSuffix(lower, changeColumnSuffix) {
+        return 0, false
+    }
+    window, err := ParseWindow(lower[len(changeColumnPrefix) : len(lower)-len(changeColumnSuffix)])
+    if err != nil {
+        return 0, false
+    }
+    return window, true
+}
+
+// Units of windows, Go durations don't have days or weeks
+var windowUnits = map[string]time.Duration{"m": time.Minute, "h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+
+// ParseWindow parses windows like "15m", "4h", "7d" or "2w"
+func ParseWindow(s string) (time.Duration, error) {
+    s = strings.TrimSpace(strings.ToLower(s))
+    if len(s) < 2 {
+        return 0, fmt.Errorf("invalid window %q, expecting a number followed by m, h, d or w, eg. 7d", s)
+    }
+    unit, ok := windowUnits[s[len(s)-1:]]
+    n, err := strconv.Atoi(s[:len(s)-1])
+    if !ok || err != nil || n <= 0 {
+        return 0, fmt.Errorf("invalid window %q, expecting a number followed by m, h, d or w, eg. 7d", s)
+    }
+    return time.Duration(n) * unit, nil
+}
+
+// Output formats of builtin writers
+const (
+    OutputTable  = "table"
+    OutputJSON   = "json"
+    OutputNDJSON = "ndjson"
+    OutputCSV    = "csv"
+    OutputTSV    = "tsv"
+)
+
+// Token is a queried symbol, optionally with holdings of it. In config files it's either a plain symbol,
+// or a map with the symbol, amount held and cost basis, which is the total paid for the amount.
+type Token struct {
+    Symbol    string          `mapstructure:"symbol"`
+    Amount    decimal.Decimal `mapstructure:"amount"`
+    CostBasis decimal.Decimal `mapstructure:"cost_basis"`
+}
+
+// Whether any amount of the token is held
+func (t Token) IsHolding() bool {
+    return !t.Amount.IsZero()
+}
+
 type PriceQuery struct {
-    Name   string   `mapstructure:"name"`
-    Tokens []string `mapstructure:"tokens"`
-    APIKey string   `mapstructure:"api_key"`
+    Name    string  `mapstructure:"name"`
+    Tokens  []Token `mapstructure:"tokens"`
+    APIKey  string  `mapstructure:"api_key"`
+    BaseURL string  `mapstructure:"base_url"`
+    // Max requests per second and burst size, override the default limits of an exchange
+    RateLimit float64 `mapstructure:"rate_limit"`
+    RateBurst int     `mapstructure:"rate_burst"`
+}
+
+// Symbols of queried tokens, in the same order
+func (q *PriceQuery) Symbols() []string {
+    symbols := make([]string, len(q.Tokens))
+    for i, token := range q.Tokens {
+        symbols[i] = token.Symbol
+    }
+    return symbols
+}
+
+// IsPair tells whether a symbol is in the unified notation, eg. BTC/USDT, instead of the native one of an exchange,
+// eg. BTCUSDT of Binance or USDT-BTC of Bittrex
+func IsPair(symbol string) bool {
+    return strings.Contains(symbol, "/")
+}
+
+// SplitPair splits a symbol in the unified notation into base and quote currencies, in upper case
+func SplitPair(symbol string) (base, quote string, err error) {
+    parts := strings.Split(strings.ToUpper(strings.TrimSpace(symbol)), "/")
+    if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+        return "", "", fmt.Errorf("invalid pair %s, expecting {base}/{quote}, eg. BTC/USDT", symbol)
+    }
+    return parts[0], parts[1], nil
+}
+
+// Pairs in the unified notation must be well-formed, native symbols are left for exchanges to judge
+func (q *PriceQuery) validatePairs() error {
+    for _, symbol := range q.Symbols() {
+        if !IsPair(symbol) {
+            continue
+        }
+        if _, _, err := SplitPair(symbol); err != nil {
+            return fmt.Errorf("%s: %w", q.Name, err)
+        }
+    }
+    return nil
+}
+
+// Retry failed requests with exponential backoff, the n-th retry waits for
+// min(BaseDelay * 2^n, MaxDelay), randomly spread by a factor of Jitter
+type RetryConfig struct {
+    Count     int           `mapstructure:"count"`
+    BaseDelay time.Duration `mapstructure:"base_delay"`
+    MaxDelay  time.Duration `mapstructure:"max_delay"`
+    Jitter    float64       `mapstructure:"jitter"`
+}
+
+// AlertRule fires when the price of a symbol meets its condition, which is one of Above, Below,
+// Window and Move, CrossAverage, or Failures
+type AlertRule struct {
+    Exchange string          `mapstructure:"exchange"` // Any exchange if empty
+    Symbol   string          `mapstructure:"symbol"`   // Any symbol if empty, only for Failures
+    Above    decimal.Decimal `mapstructure:"above"`
+    Below    decimal.Decimal `mapstructure:"below"`
+    // Percent change of the window beyond Move in either direction, eg. window: 1h, move: 5
+    Window string  `mapstructure:"window"`
+    Move   float64 `mapstructure:"move"`
+    // Price crossing its moving average over this many refreshes
+    CrossAverage int `mapstructure:"cross_average"`
+    // Queries of a symbol failing this many times in a row
+    Failures int `mapstructure:"failures"`
+    // A fired rule is re-armed after the price (or change) moves back this many percent (points),
+    // so it doesn't fire back and forth around the threshold
+    Hysteresis float64 `mapstructure:"hysteresis"`
+    // Fire again no sooner than this
+    Cooldown time.Duration `mapstructure:"cooldown"`
+}
+
+// NotifierConfig tells where fired alerts are sent, fields other than Type are specific to the type of notifier
+type NotifierConfig struct {
+    Type string `mapstructure:"type"` // webhook, exec or smtp
+    // Webhook, the body is a Go template of the alert, a JSON of it by default
+    URL     string            `mapstructure:"url"`
+    Body    string            `mapstructure:"body"`
+    Headers map[string]string `mapstructure:"headers"`
+    // Exec, run by the shell with the alert in environment variables
+    Command string `mapstructure:"command"`
+    // SMTP
+    Host     string   `mapstructure:"host"`
+    Port     int      `mapstructure:"port"`
+    Username string   `mapstructure:"username"`
+    Password string   `mapstructure:"password"`
+    From     string   `mapstructure:"from"`
+    To       []string `mapstructure:"to"`
+}
+
+// OHLCConfig tells which candles the ohlc command prints
+type OHLCConfig struct {
+    Interval string `mapstructure:"interval"` // eg. 15m, 4h, 1d or 1w
+    Last     int    `mapstructure:"last"`
+}
+
+// HistoryConfig tells where every fetched price is saved, nothing is saved if Path is empty
+type HistoryConfig struct {
+    Path string `mapstructure:"path"`
+    // Prices older than this are deleted, eg. 30d, kept forever if empty
+    Retention string `mapstructure:"retention"`
 }
 
 type Config struct {
-    Timeout int           `mapstructure:"timeout"`
-    Proxy   string        `mapstructure:"proxy"`
-    Refresh int           `mapstructure:"refresh"`
-    Columns []string      `mapstructure:"show"`
-    Debug   bool          `mapstructure:"debug"`
-    Queries []*PriceQuery `mapstructure:"exchanges"`
+    Timeout    int               `mapstructure:"timeout"`
+    Retry      RetryConfig       `mapstructure:"retry"`
+    Proxy      string            `mapstructure:"proxy"`
+    Refresh    int               `mapstructure:"refresh"`
+    Deadline   time.Duration     `mapstructure:"deadline"`
+    StaleAfter time.Duration     `mapstructure:"stale_after"`
+    Stream     bool              `mapstructure:"stream"`
+    Columns    []string          `mapstructure:"show"`
+    Output     string            `mapstructure:"output"`
+    Debug      bool              `mapstructure:"debug"`
+    Record     string            `mapstructure:"record"`
+    Replay     string            `mapstructure:"replay"`
+    Queries    []*PriceQuery     `mapstructure:"exchanges"`
+    Alerts     []*AlertRule      `mapstructure:"alerts"`
+    Notifiers  []*NotifierConfig `mapstructure:"notifiers"`
+    History    HistoryConfig     `mapstructure:"history"`
+    OHLC       OHLCConfig        `mapstructure:"ohlc"`
+    // Path of the config file loaded, empty if none
+    File string `mapstructure:"-"`
+    // Settings merged from the config file, flags and defaults, see WriteYAML
+    settings map[string]interface{}
+}
+
+// Deadline of fetching prices in each refresh, 0 means no deadline
+func (c *Config) RefreshDeadline() time.Duration {
+    if c.Deadline > 0 {
+        return c.Deadline
+    }
+    return time.Duration(c.Refresh) * time.Second
+}
+
+// Last known prices are marked stale after this long without an update, twice the refresh interval by default
+func (c *Config) RefreshStaleAfter() time.Duration {
+    if c.StaleAfter > 0 {
+        return c.StaleAfter
+    }
+    return 2 * time.Duration(c.Refresh) * time.Second
+}
+
+// Windows of change columns shown and alert rules, other than 1h and 24h which are always fetched
+func (c *Config) ChangeWindows() []time.Duration {
+    var windows []time.Duration
+    seen := map[time.Duration]bool{time.Hour: true, 24 * time.Hour: true}
+    add := func(window time.Duration) {
+        if !seen[window] {
+            seen[window] = true
+            windows = append(windows, window)
+        }
+    }
+    for _, column := range c.Columns {
+        if window, ok := ParseChangeColumn(column); ok {
+            add(window)
+        }
+    }
+    for _, rule := range c.Alerts {
+        if window, err := ParseWindow(rule.Window); rule.Window != "" && err == nil {
+            add(window)
+        }
+    }
+    return windows
 }
 
 func (c *Config) GroupQueryByExchange() map[string]*PriceQuery {
@@ -0,0 +1,130 @@
+package exchange
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/shopspring/decimal"
+    "github.com/sirupsen/logrus"
+)
+
+// BatchExchangeClient is implemented by exchanges having all-symbol endpoints, so a watchlist on one exchange
+// takes a request or two, instead of one (or more) per symbol
+type BatchExchangeClient interface {
+    ExchangeClient
+    // GetSymbolPrices returns a result for every symbol in the same order, symbols not found are kept as errors,
+    // an error means the whole batch failed
+    GetSymbolPrices(ctx context.Context, symbols []string) ([]*SymbolResult, error)
+}
+
+// Same as getPricesAsync, but fetch all symbols in a batch, fall back to one by one if the batch failed,
+// eg. Binance fails the whole batch if any symbol is invalid
+func (r *Registry) getBatchPricesAsync(ctx context.Context, client BatchExchangeClient, symbols []string) []chan *SymbolResult {
+    waitingChans := make([]chan *SymbolResult, len(symbols))
+    for i := range waitingChans {
+        waitingChans[i] = make(chan *SymbolResult, 1)
+    }
+    resultsCh := make(chan []*SymbolResult, 1) // Buffered, so a cut off batch won't leak the goroutine
+    go func() {
+        results, err := client.GetSymbolPrices(ctx, symbols)
+        if err == nil && len(results) != len(symbols) {
+            err = fmt.Errorf("expecting %d results in a batch, got %d", len(symbols), len(results))
+        }
+        if err == nil {
+            for _, result := range results {
+                if result.Err != nil {
+                    logrus.Warnf("Failed to get symbol price for %s from %s, error: %v", result.Symbol, client.GetName(), result.Err)
+                }
+            }
+            forEachConcurrently(len(results), func(i int) {
+                if sp := results[i].Price; sp != nil {
+                    r.fillChanges(ctx, client, sp)
+                }
+            })
+        } else {
+            if ctx.Err() == nil {
+                logrus.Warnf("%s - Failed to get symbol prices of %s in a batch, trying one by one, error: %v",
+                    client.GetName(), strings.Join(symbols, ","), err)
+            }
+            results = make([]*SymbolResult, 0, len(symbols))
+            for _, doneCh := range r.getSinglePricesAsync(ctx, client, symbols) {
+                results = append(results, <-doneCh)
+            }
+        }
+        resultsCh <- results
+    }()
+    go func() {
+        select {
+        case results := <-resultsCh:
+            for i, result := range results {
+                waitingChans[i] <- result
+            }
+        case <-ctx.Done():
+            for i, symbol := range symbols {
+                waitingChans[i] <- &SymbolResult{Symbol: symbol, Source: client.GetName(), Err: cutOffError(ctx)}
+            }
+        }
+    }()
+    return waitingChans
+}
+
+// Call fetch with every index in [0, n) concurrently, and wait for all of them
+func forEachConcurrently(n int, fetch func(i int)) {
+    var wg sync.WaitGroup
+    wg.Add(n)
+    for i := 0; i < n; i++ {
+        go func(i int) {
+            defer wg.Done()
+            fetch(i)
+        }(i)
+    }
+    wg.Wait()
+}
+
+// pastPriceCache remembers prices in the past, which don't change until the next kline starts,
+// so batch clients won't fetch klines of every symbol on every refresh
+type pastPriceCache struct {
+    period time.Duration // Of klines
+    mu     sync.Mutex
+    prices map[string]pastPrice // Only the latest one of a symbol is kept
+}
+
+type pastPrice struct {
+    at    time.Time
+    price decimal.Decimal
+}
+
+func newPastPriceCache(period time.Duration) *pastPriceCache {
+    return &pastPriceCache{period: period, prices: make(map[string]pastPrice)}
+}
+
+// Nothing tells prices 1 hour ago in bulk on some exchanges, they take a kline request per symbol. Keep them for
+// a quarter, so most refreshes cost the ticker request only, while the 1 hour change spans up to 75 minutes.
+const hourAgoCachePeriod = 15 * time.Minute
+
+func newHourAgoCache() *pastPriceCache {
+    return newPastPriceCache(hourAgoCachePeriod)
+}
+
+// Get the price of symbol at the given time, fetch it if not cached, failures are not cached
+func (c *pastPriceCache) get(symbol string, at time.Time, fetch func() (decimal.Decimal, error)) (decimal.Decimal, error) {
+    key, at := strings.ToUpper(symbol), at.Truncate(c.period)
+    c.mu.Lock()
+    cached, ok := c.prices[key]
+    c.mu.Unlock()
+    if ok && cached.at.Equal(at) {
+        return cached.price, nil
+    }
+
+    price, err := fetch()
+    if err != nil {
+        return price, err
+    }
+    c.mu.Lock()
+    c.prices[key] = pastPrice{at: at, price: price}
+    c.mu.Unlock()
+    return price, nil
+}
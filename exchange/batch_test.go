@@ -0,0 +1,48 @@
+package exchange
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/shopspring/decimal"
+)
+
+func TestPastPriceCache(t *testing.T) {
+    cache := newPastPriceCache(time.Minute)
+    fetched := 0
+    fetch := func() (decimal.Decimal, error) {
+        fetched++
+        return decimal.NewFromInt(int64(fetched)), nil
+    }
+
+    cache.get("BTCUSDT", fixtureTime, fetch)
+    price, _ := cache.get("btcusdt", fixtureTime.Add(59*time.Second), fetch)
+    assertPrice(t, "cached price", price, "1")
+
+    price, _ = cache.get("BTCUSDT", fixtureTime.Add(time.Minute), fetch)
+    assertPrice(t, "price of the next kline", price, "2")
+
+    _, err := cache.get("ETHUSDT", fixtureTime, func() (decimal.Decimal, error) { return decimal.Zero, errors.New("boom") })
+    if err == nil {
+        t.Fatalf("Expecting the error of fetch")
+    }
+    price, _ = cache.get("ETHUSDT", fixtureTime, fetch)
+    assertPrice(t, "price after a failure", price, "3")
+}
+
+func TestNewHourAgoCache(t *testing.T) {
+    cache := newHourAgoCache()
+    fetched := 0
+    fetch := func() (decimal.Decimal, error) {
+        fetched++
+        return decimal.NewFromInt(int64(fetched)), nil
+    }
+    // Refreshed every minute
+    for at := fixtureTime; at.Before(fixtureTime.Add(15 * time.Minute)); at = at.Add(time.Minute) {
+        cache.get("BTCUSDT", at.Add(-time.Hour), fetch)
+    }
+    if fetched != 1 {
+        t.Fatalf("Expecting prices 1 hour ago fetched once in 15 minutes, got %d times", fetched)
+    }
+}
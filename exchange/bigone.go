@@ -1,16 +1,16 @@
 package exchange
 
 import (
+    "context"
     "encoding/json"
     "errors"
     "fmt"
-    "math"
-    "strconv"
     "strings"
     "time"
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
+    "github.com/shopspring/decimal"
     "github.com/sirupsen/logrus"
 )
 
@@ -19,6 +19,7 @@ const bigOneBaseApi = "https://api.big.one/"
 
 type bigOneClient struct {
     *http.Client
+    baseApi   string
     AccessKey string
     SecretKey string
 }
@@ -36,7 +37,7 @@ type bigOneMarketResponse struct {
     Data struct {
         Symbol string
         Ticker struct {
-            Price float64 `json:",string"`
+            Price decimal.Decimal
         }
         //Metrics map[string][]interface{}
         Metrics struct {
@@ -49,37 +50,59 @@ type bigOneMarketResponse struct {
 }
 
 func NewBigOneClient(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient {
-    return &bigOneClient{Client: httpClient}
+    client := &bigOneClient{Client: httpClient}
+    client.baseApi = getBaseApi(queries, client.GetName(), bigOneBaseApi)
+    return client
 }
 
 func (client *bigOneClient) GetName() string {
     return "BigONE"
 }
 
-func (client *bigOneClient) SearchKlinePriceNear(klineIntervals [][]interface{}, after time.Time) (float64, error) {
-    var intervalTime time.Time
-    for _, interval := range klineIntervals {
-        if ts, ok := interval[0].(float64); ok {
-            intervalTime = time.Unix(int64(ts)/1000, 0)
-            if after.Equal(intervalTime) || after.After(intervalTime) {
-                // Assume candles are sorted in asc order, so the first less than or equal to is the candle looking for
-                logrus.Debugf("%s - Kline for %v uses open price at %v", client.GetName(), after.Local(), intervalTime.Local())
-                if openStr, ok := interval[1].(string); ok {
-                    return strconv.ParseFloat(openStr, 64)
-                } else {
-                    return 0, fmt.Errorf("cannot convert open price item %v of kline to string", interval[1])
-                }
+// Metrics come with the market response, convert them to share the same lookup with other exchanges
+func (client *bigOneClient) candlesOf(metrics [][]interface{}) ([]Candle, error) {
+    candles := make([]Candle, 0, len(metrics))
+    for _, metric := range metrics {
+        if len(metric) < 6 {
+            return nil, fmt.Errorf("malformed kline %v", metric)
+        }
+        ts, ok := metric[0].(float64)
+        if !ok {
+            return nil, fmt.Errorf("cannot convert first item %v of kline to float64", metric[0])
+        }
+        // timestamp, open, close, high, low, volume
+        var ohlcv [5]decimal.Decimal
+        for i := range ohlcv {
+            s, ok := metric[i+1].(string)
+            if !ok {
+                return nil, fmt.Errorf("cannot convert item %v of kline to string", metric[i+1])
             }
-        } else {
-            return 0, fmt.Errorf("cannot convert first item %v of kline to float64", interval[0])
+            ohlcv[i] = parseDecimal(s)
         }
+        candles = append(candles, Candle{
+            Time:   time.Unix(int64(ts)/1000, 0),
+            Open:   ohlcv[0],
+            Close:  ohlcv[1],
+            High:   ohlcv[2],
+            Low:    ohlcv[3],
+            Volume: ohlcv[4],
+        })
+    }
+    return candles, nil
+}
+
+func (client *bigOneClient) searchPriceAt(metrics [][]interface{}, at time.Time, interval time.Duration) (decimal.Decimal, error) {
+    candles, err := client.candlesOf(metrics)
+    if err != nil {
+        return decimal.Zero, err
     }
-    return 0, fmt.Errorf("no time found right after %v, the last time in this interval is %v", after.Local(), intervalTime.Local())
+    price, _, err := priceAt(candles, at, interval)
+    return price, err
 }
 
-func (client *bigOneClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
+func (client *bigOneClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
     // One api to get all
-    respBytes, err := client.Get(binanceBaseApi + "/markets/" + strings.ToUpper(symbol))
+    respBytes, err := client.Get(ctx, client.baseApi+"markets/"+strings.ToUpper(symbol))
     if err != nil {
         return nil, err
     }
@@ -93,35 +116,30 @@ func (client *bigOneClient) GetSymbolPrice(symbol string) (*SymbolPrice, error)
         return nil, errors.New(respJSON.Error.Description)
     }
 
-    var (
-        now                               = time.Now()
-        percentChange1h, percentChange24h = math.MaxFloat64, math.MaxFloat64
-    )
-    price1hAgo, err := client.SearchKlinePriceNear(respJSON.Data.Metrics.Min1, now.Add(-1*time.Hour))
-    if price1hAgo == 0 {
+    now := client.Now()
+    price1hAgo, err1h := client.searchPriceAt(respJSON.Data.Metrics.Min1, now.Add(-1*time.Hour), time.Minute)
+    if price1hAgo.IsZero() {
         // BigOne has a very low volume, that prices after certain amount are all zero, so enlarge intervals here.
-        price1hAgo, err = client.SearchKlinePriceNear(respJSON.Data.Metrics.Min5, now.Add(-1*time.Hour))
+        price1hAgo, err1h = client.searchPriceAt(respJSON.Data.Metrics.Min5, now.Add(-1*time.Hour), 5*time.Minute)
     }
-    if err != nil {
-        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err)
-    } else if price1hAgo != 0 {
-        percentChange1h = (respJSON.Data.Ticker.Price - price1hAgo) / price1hAgo * 100
+    if err1h != nil {
+        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err1h)
     }
 
-    price24hAgo, err := client.SearchKlinePriceNear(respJSON.Data.Metrics.Min15, now.Add(-24*time.Hour))
-    if err != nil {
-        logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err)
-    } else if price24hAgo != 0 {
-        percentChange24h = (respJSON.Data.Ticker.Price - price24hAgo) / price24hAgo * 100
+    price24hAgo, err24h := client.searchPriceAt(respJSON.Data.Metrics.Min15, now.Add(-24*time.Hour), 15*time.Minute)
+    if err24h != nil {
+        logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err24h)
     }
 
     return &SymbolPrice{
         Symbol:           symbol,
-        Price:            strconv.FormatFloat(respJSON.Data.Ticker.Price, 'f', -1, 64),
-        UpdateAt:         time.Now(),
+        Price:            respJSON.Data.Ticker.Price,
+        UpdateAt:         client.Now(),
         Source:           client.GetName(),
-        PercentChange1h:  percentChange1h,
-        PercentChange24h: percentChange24h,
+        Price1hAgo:       price1hAgo,
+        Price24hAgo:      price24hAgo,
+        PercentChange1h:  changeSince(respJSON.Data.Ticker.Price, price1hAgo, err1h),
+        PercentChange24h: changeSince(respJSON.Data.Ticker.Price, price24hAgo, err24h),
     }, nil
 }
 
@@ -1,6 +1,7 @@
 package exchange
 
 import (
+    "context"
     "testing"
 )
 
@@ -11,24 +12,24 @@ func TestBigOneClient(t *testing.T) {
     var client = registry.getClient("bigone").(*bigOneClient)
 
     t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("bTC-usdt")
+        sp, err := client.GetSymbolPrice(context.Background(), "bTC-usdt")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
+        if sp.Price.IsZero() {
             t.Fatalf("Get an empty price?")
         }
-        if sp.PercentChange1h == 0 {
+        if sp.PercentChange1h.Percent == 0 {
             t.Logf("WARNING - PercentChange1h is zero?")
         }
-        if sp.PercentChange24h == 0 {
+        if sp.PercentChange24h.Percent == 0 {
             t.Logf("WARNING - PercentChange24h is zero?")
         }
     })
 
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("ABC123")
+        _, err := client.GetSymbolPrice(context.Background(), "ABC123")
 
         if err == nil {
             t.Fatalf("Should throws on invalid symbol")
@@ -1,24 +1,32 @@
 package exchange
 
 import (
+    "context"
     "encoding/json"
     "errors"
     "fmt"
-    "math"
     "strconv"
     "strings"
     "time"
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
+    "github.com/shopspring/decimal"
     "github.com/sirupsen/logrus"
+    "github.com/tidwall/gjson"
 )
 
 // https://github.com/binance-exchange/binance-official-api-docs/blob/master/rest-api.md
 const binanceBaseApi = "https://api.binance.com"
 
+// https://github.com/binance/binance-spot-api-docs/blob/master/web-socket-streams.md
+const binanceStreamApi = "wss://stream.binance.com:9443/stream"
+
 type binanceClient struct {
     *http.Client
+    *marketIndex
+    baseApi   string
+    streamApi string
 }
 
 type binanceErrorResponse struct {
@@ -35,58 +43,99 @@ type binancePriceResponse struct {
 type binance24hStatistics struct {
     binanceErrorResponse
     Symbol             string
-    LastPrice          string
-    PrevClosePrice     string
-    PriceChange        float64 `json:",string"`
-    PriceChangePercent float64 `json:",string"`
+    LastPrice          decimal.Decimal
+    PrevClosePrice     decimal.Decimal
+    OpenPrice          decimal.Decimal
+    PriceChange        decimal.Decimal
+    PriceChangePercent decimal.Decimal
+    HighPrice          decimal.NullDecimal
+    LowPrice           decimal.NullDecimal
+    BidPrice           decimal.NullDecimal
+    AskPrice           decimal.NullDecimal
+    Volume             decimal.NullDecimal
     OpenTime           int64
     CloseTime          int64
 }
 
 func NewBinanceClient(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient {
-    return &binanceClient{Client: httpClient}
+    client := &binanceClient{Client: httpClient}
+    client.baseApi = getBaseApi(queries, client.GetName(), binanceBaseApi)
+    client.streamApi = getStreamApi(queries, client.GetName(), binanceStreamApi)
+    limitRate(httpClient, queries, client.GetName(), client.baseApi, 10, 10) // 1200 request weight per minute
+    client.marketIndex = newMarketIndex(client.GetName(), client.ListSymbols)
+    return client
 }
 
 func (client *binanceClient) GetName() string {
     return "Binance"
 }
 
-func (client *binanceClient) GetPrice1hAgo(symbol string) (float64, error) {
-    now := time.Now()
-    lastHour := now.Add(-1 * time.Hour)
-    respBytes, err := client.Get(binanceBaseApi+"/api/v1/klines", http.WithQuery(map[string]string{
+var binanceCandleIntervals = map[time.Duration]string{
+    time.Minute: "1m", 3 * time.Minute: "3m", 5 * time.Minute: "5m", 15 * time.Minute: "15m", 30 * time.Minute: "30m",
+    time.Hour: "1h", 2 * time.Hour: "2h", 4 * time.Hour: "4h", 6 * time.Hour: "6h", 8 * time.Hour: "8h", 12 * time.Hour: "12h",
+    24 * time.Hour: "1d", 3 * 24 * time.Hour: "3d", 7 * 24 * time.Hour: "1w",
+}
+
+func (client *binanceClient) CandleIntervals() []time.Duration {
+    return sortedIntervals(binanceCandleIntervals)
+}
+
+func (client *binanceClient) GetCandles(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]Candle, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"/api/v1/klines", http.WithQuery(map[string]string{
         "symbol":    strings.ToUpper(symbol),
-        "interval":  "1m",
-        "limit":     "1",
-        "startTime": strconv.FormatInt(lastHour.Unix()*1000, 10),
+        "interval":  binanceCandleIntervals[interval],
+        "startTime": strconv.FormatInt(start.Unix()*1000, 10),
+        "endTime":   strconv.FormatInt(end.Unix()*1000, 10),
     }))
     if err != nil {
-        return 0, err
+        return nil, err
     }
 
-    var klines [][]interface{}
+    // open time, open, high, low, close, volume, close time...
+    var klines [][]json.RawMessage
     if err := json.Unmarshal(respBytes, &klines); err != nil {
-        return 0, err
-    }
-    if len(klines) == 0 {
-        return 0, errors.New("got an empty kline")
+        return nil, err
     }
-    if s, ok := klines[0][1].(string); ok {
-        p, err := strconv.ParseFloat(s, 64)
-        if err != nil {
-            return 0, fmt.Errorf("failed to convert %v to float", s)
+    candles := make([]Candle, 0, len(klines))
+    for _, kline := range klines {
+        if len(kline) < 6 {
+            return nil, fmt.Errorf("unexpected kline %s", kline)
         }
-        return p, nil
+        var (
+            openTime int64
+            ohlcv    [5]decimal.Decimal
+        )
+        if err := json.Unmarshal(kline[0], &openTime); err != nil {
+            return nil, err
+        }
+        for i := range ohlcv {
+            if err := json.Unmarshal(kline[i+1], &ohlcv[i]); err != nil {
+                return nil, err
+            }
+        }
+        candles = append(candles, Candle{
+            Time:   time.Unix(0, openTime*int64(time.Millisecond)),
+            Open:   ohlcv[0],
+            High:   ohlcv[1],
+            Low:    ohlcv[2],
+            Close:  ohlcv[3],
+            Volume: ohlcv[4],
+        })
     }
-    return 0, fmt.Errorf("failed to convert %v to string", klines[0][1])
+    return candles, nil
 }
 
-func (client *binanceClient) Get24hStatistics(symbol string) (*binance24hStatistics, error) {
+func (client *binanceClient) Get24hStatistics(ctx context.Context, symbol string) (*binance24hStatistics, error) {
     // always return an empty response, so the caller doesn't need to handle error
     var respJSON binance24hStatistics
 
-    respBytes, err := client.Get(binanceBaseApi+"/api/v1/ticker/24hr", http.WithQuery(map[string]string{"symbol": strings.ToUpper(symbol)}))
+    respBytes, err := client.Get(ctx, client.baseApi+"/api/v1/ticker/24hr", http.WithQuery(map[string]string{"symbol": strings.ToUpper(symbol)}))
     if err != nil {
+        // Error responses come with a more specific message
+        var errResp binanceErrorResponse
+        if json.Unmarshal(respBytes, &errResp) == nil && errResp.Msg != nil {
+            return nil, errors.New(*errResp.Msg)
+        }
         return nil, err
     }
 
@@ -100,7 +149,7 @@ func (client *binanceClient) Get24hStatistics(symbol string) (*binance24hStatist
     return &respJSON, nil
 }
 
-func (client *binanceClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
+func (client *binanceClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
     // I found 24 hour price statistics already covers required info, uncomment the following code if needed
 
     //rawUrl := client.buildUrl("/api/v3/ticker/price", map[string]string{"symbol": strings.ToUpper(symbol)})
@@ -120,32 +169,170 @@ func (client *binanceClient) GetSymbolPrice(symbol string) (*SymbolPrice, error)
     //	return nil, errors.New(*respJSON.Msg)
     //}
 
-    stat24h, err := client.Get24hStatistics(symbol)
+    stat24h, err := client.Get24hStatistics(ctx, symbol)
     if err != nil {
         //logrus.Warnf("Failed to get 24 hour price change statistics, error: %s\n", err)
         return nil, err
     }
 
-    var percentChange1h = math.MaxFloat64
-    price1hAgo, err2 := client.GetPrice1hAgo(symbol)
-    if err2 != nil {
-        logrus.Warnf("%s - Failed on GetPrice1hAgo, error: %s\n", client.GetName(), err2)
-    } else if price1hAgo != 0 {
-        currentPrice, err := strconv.ParseFloat(stat24h.LastPrice, 64)
-        if err != nil {
-            logrus.Warnf("%s - Failed to convert current price %v to float", client.GetName(), stat24h.LastPrice)
-        }
-        percentChange1h = (currentPrice - price1hAgo) / price1hAgo * 100
+    price1hAgo, err1h := priceAgo(ctx, client, symbol, client.Now(), time.Hour)
+    if err1h != nil {
+        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %s\n", client.GetName(), err1h)
     }
 
+    return client.symbolPriceOf(symbol, stat24h, price1hAgo, err1h), nil
+}
+
+func (client *binanceClient) symbolPriceOf(symbol string, stat24h *binance24hStatistics, price1hAgo decimal.Decimal, err1h error) *SymbolPrice {
     return &SymbolPrice{
         Symbol:           symbol,
         Price:            stat24h.LastPrice,
         UpdateAt:         time.Unix(stat24h.CloseTime/1000, 0),
         Source:           client.GetName(),
-        PercentChange1h:  percentChange1h,
-        PercentChange24h: stat24h.PriceChangePercent,
-    }, nil
+        Price1hAgo:       price1hAgo,
+        Price24hAgo:      stat24h.OpenPrice,
+        PercentChange1h:  changeSince(stat24h.LastPrice, price1hAgo, err1h),
+        PercentChange24h: percentChange(stat24h.LastPrice, stat24h.OpenPrice),
+        Volume24h:        stat24h.Volume,
+        High24h:          stat24h.HighPrice,
+        Low24h:           stat24h.LowPrice,
+        Bid:              stat24h.BidPrice,
+        Ask:              stat24h.AskPrice,
+    }
+}
+
+// Get statistics of symbols in a rolling window, eg. "1h", or "" for 24 hours, keyed by upper-cased symbols
+func (client *binanceClient) GetWindowStatistics(ctx context.Context, symbols []string, windowSize string) (map[string]*binance24hStatistics, error) {
+    upperSymbols := make([]string, len(symbols))
+    for i, symbol := range symbols {
+        upperSymbols[i] = strings.ToUpper(symbol)
+    }
+    symbolsJSON, _ := json.Marshal(upperSymbols)
+    path, query := "/api/v3/ticker/24hr", map[string]string{"symbols": string(symbolsJSON)}
+    if windowSize != "" {
+        path, query["windowSize"] = "/api/v3/ticker", windowSize
+    }
+    respBytes, err := client.Get(ctx, client.baseApi+path, http.WithQuery(query))
+    if err != nil {
+        var errResp binanceErrorResponse
+        if json.Unmarshal(respBytes, &errResp) == nil && errResp.Msg != nil {
+            return nil, errors.New(*errResp.Msg)
+        }
+        return nil, err
+    }
+
+    var respJSON []*binance24hStatistics
+    if err := json.Unmarshal(respBytes, &respJSON); err != nil {
+        return nil, err
+    }
+    statistics := make(map[string]*binance24hStatistics, len(respJSON))
+    for _, stat := range respJSON {
+        statistics[stat.Symbol] = stat
+    }
+    return statistics, nil
+}
+
+// All symbols take two requests, one for 24 hours statistics, and one for opening prices of the last hour.
+// Binance fails the whole batch if any symbol is invalid.
+func (client *binanceClient) GetSymbolPrices(ctx context.Context, symbols []string) ([]*SymbolResult, error) {
+    stats24h, err := client.GetWindowStatistics(ctx, symbols, "")
+    if err != nil {
+        return nil, err
+    }
+    stats1h, err1h := client.GetWindowStatistics(ctx, symbols, "1h")
+    if err1h != nil {
+        logrus.Warnf("%s - Failed to get statistics of the last hour, error: %v", client.GetName(), err1h)
+    }
+
+    results := make([]*SymbolResult, len(symbols))
+    for i, symbol := range symbols {
+        results[i] = &SymbolResult{Symbol: symbol, Source: client.GetName()}
+        stat24h, ok := stats24h[strings.ToUpper(symbol)]
+        if !ok {
+            results[i].Err = errors.New("symbol not found")
+            continue
+        }
+        var price1hAgo decimal.Decimal
+        if stat1h, ok := stats1h[strings.ToUpper(symbol)]; ok {
+            price1hAgo = stat1h.OpenPrice
+        }
+        results[i].Price = client.symbolPriceOf(symbol, stat24h, price1hAgo, err1h)
+    }
+    return results, nil
+}
+
+// Subscribe to 24 hour rolling window tickers, which are pushed every second
+func (client *binanceClient) StreamSymbolPrices(ctx context.Context, symbols []string, out chan<- *SymbolPrice) error {
+    streams := make([]string, len(symbols))
+    queried := make(map[string]string, len(symbols)) // Symbols in tickers are upper-cased
+    for i, symbol := range symbols {
+        streams[i] = strings.ToLower(symbol) + "@ticker"
+        queried[strings.ToUpper(symbol)] = symbol
+    }
+    conn, err := client.DialWebsocket(ctx, client.streamApi+"?streams="+strings.Join(streams, "/"))
+    if err != nil {
+        return err
+    }
+
+    for {
+        _, message, err := conn.ReadMessage()
+        if err != nil {
+            return err
+        }
+        // Keys differ only in case (eg. "c" and "C"), which encoding/json cannot tell apart
+        ticker := gjson.GetBytes(message, "data")
+        symbol, ok := queried[ticker.Get("s").String()]
+        if !ok {
+            logrus.Debugf("%s - Unexpected stream message: %s", client.GetName(), message)
+            continue
+        }
+        lastPrice := parseDecimal(ticker.Get("c").String())
+        openPrice := parseDecimal(ticker.Get("o").String())
+        err = sendPrice(ctx, out, &SymbolPrice{
+            Symbol:           symbol,
+            Price:            lastPrice,
+            UpdateAt:         time.Unix(0, ticker.Get("E").Int()*int64(time.Millisecond)),
+            Source:           client.GetName(),
+            Price24hAgo:      openPrice,
+            PercentChange1h:  UnknownChange(ReasonNotSupported),
+            PercentChange24h: percentChange(lastPrice, openPrice),
+            Volume24h:        parseNullDecimal(ticker.Get("v").String()),
+            High24h:          parseNullDecimal(ticker.Get("h").String()),
+            Low24h:           parseNullDecimal(ticker.Get("l").String()),
+            Bid:              parseNullDecimal(ticker.Get("b").String()),
+            Ask:              parseNullDecimal(ticker.Get("a").String()),
+        })
+        if err != nil {
+            return err
+        }
+    }
+}
+
+func (client *binanceClient) ListSymbols(ctx context.Context) ([]Market, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"/api/v3/exchangeInfo")
+    if err != nil {
+        return nil, err
+    }
+
+    var respJSON struct {
+        Symbols []struct {
+            Symbol     string
+            Status     string
+            BaseAsset  string
+            QuoteAsset string
+        }
+    }
+    if err := json.Unmarshal(respBytes, &respJSON); err != nil {
+        return nil, err
+    }
+    markets := make([]Market, 0, len(respJSON.Symbols))
+    for _, s := range respJSON.Symbols {
+        if s.Status != "TRADING" {
+            continue
+        }
+        markets = append(markets, Market{Symbol: s.Symbol, Base: s.BaseAsset, Quote: s.QuoteAsset})
+    }
+    return markets, nil
 }
 
 func init() {
@@ -1,15 +1,28 @@
 package exchange
 
 import (
+    "context"
+    "strings"
     "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
 )
 
 func TestBinanceClient(t *testing.T) {
 
-    var client = registry.getClient("binance").(*binanceClient)
+    var client = newFixtureClient(t, "binance", map[string]fixture{
+        "/api/v1/ticker/24hr?symbol=ETHBTC":                                {file: "ticker_24hr.json"},
+        "/api/v1/ticker/24hr?symbol=ABC123":                                {file: "invalid_symbol.json", status: 400},
+        "/api/v1/klines?symbol=ETHBTC&interval=1m&startTime=1639551540000": {file: "klines.json"},
+        "/api/v1/klines?symbol=ABC123":                                     {file: "invalid_symbol.json", status: 400},
+        "/api/v3/ticker/24hr":                                              {file: "tickers_24hr.json"},
+        "/api/v3/ticker?windowSize=1h":                                     {file: "tickers_1h.json"},
+        "/api/v3/exchangeInfo":                                             {file: "exchange_info.json"},
+    }).(*binanceClient)
 
     t.Run("Get24hStatistics", func(t *testing.T) {
-        stat, err := client.Get24hStatistics("ethbtc")
+        stat, err := client.Get24hStatistics(context.Background(), "ethbtc")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
@@ -18,49 +31,97 @@ func TestBinanceClient(t *testing.T) {
             t.Fatalf("Error message %s", *stat.Msg)
         }
 
-        if stat.LastPrice == "" {
-            t.Fatalf("Get unknown price")
-        }
+        assertPrice(t, "LastPrice", stat.LastPrice, "0.08125000")
     })
 
-    t.Run("GetPrice1hAgo", func(t *testing.T) {
-        price, err := client.GetPrice1hAgo("ethbtc")
+    t.Run("GetCandles", func(t *testing.T) {
+        start := fixtureTime.Add(-time.Hour - time.Minute)
+        candles, err := client.GetCandles(context.Background(), "ethbtc", time.Minute, start, start.Add(2*time.Minute))
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if price == 0 {
-            t.Fatalf("Price is zero? must be the end of word")
+        if len(candles) != 1 || !candles[0].Time.Equal(time.Unix(1639551600, 0)) {
+            t.Fatalf("Unexpected candles %v", candles)
         }
+        assertPrice(t, "Open", candles[0].Open, "0.08")
+        assertPrice(t, "Close", candles[0].Close, "0.08005")
+        assertPrice(t, "Volume", candles[0].Volume, "12.3")
     })
 
-    t.Run("GetPrice1hAgo", func(t *testing.T) {
-        price, err := client.GetPrice1hAgo("ethbtc")
+    t.Run("GetSymbolPrice", func(t *testing.T) {
+        sp, err := client.GetSymbolPrice(context.Background(), "ethbtc")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if price == 0 {
-            t.Fatalf("Price is zero? must be the end of word")
+        assertPrice(t, "Price", sp.Price, "0.08125000")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, 1.5625)
+        assertPrice(t, "Price1hAgo", sp.Price1hAgo, "0.08")
+        assertPrice(t, "Price24hAgo", sp.Price24hAgo, "0.082265")
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, -1.233817540874)
+        assertStatistics(t, sp, "78213.4153", "0.0828", "0.080511", "0.081249", "0.08125")
+        if sp.QuoteCurrency != "" {
+            t.Fatalf("Expecting quote currency %q, got %q", "", sp.QuoteCurrency)
+        }
+        if !sp.UpdateAt.Equal(time.Unix(1639555199, 0)) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
         }
     })
 
-    t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("ethbtc")
+    t.Run("GetSymbolPrices", func(t *testing.T) {
+        results, err := client.GetSymbolPrices(context.Background(), []string{"ethbtc", "BNBUSDT", "ABC123"})
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
-            t.Fatalf("Get an empty price?")
+        if len(results) != 3 {
+            t.Fatalf("Expecting 3 results, got %d", len(results))
+        }
+        sp := results[0].Price
+        assertPrice(t, "Price", sp.Price, "0.08125000")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, 1.5625)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, -1.233817540874)
+        assertStatistics(t, sp, "78213.4153", "0.0828", "0.080511", "0.081249", "0.08125")
+        assertPrice(t, "Price", results[1].Price.Price, "524.8")
+        assertPrice(t, "Price1hAgo", results[1].Price.Price1hAgo, "520")
+        if results[2].Err == nil {
+            t.Fatalf("Expecting ABC123 not found")
         }
     })
 
+    t.Run("StreamSymbolPrices", func(t *testing.T) {
+        client := newStreamFixtureClient(t, "binance", nil, func(conn *websocket.Conn) {
+            conn.WriteMessage(websocket.TextMessage, readFixture(t, "binance", "stream_ticker.json"))
+            conn.ReadMessage() // Hold until the client is gone
+        })
+        sp := collectStreamed(t, client, []string{"ethbtc"}, 1)[0]
+
+        if sp.Symbol != "ethbtc" {
+            t.Fatalf("Expecting symbol as subscribed, got %s", sp.Symbol)
+        }
+        assertPrice(t, "Price", sp.Price, "0.08125000")
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, -1.233817540874)
+        assertStatistics(t, sp, "78213.4153", "0.0828", "0.080511", "0.081249", "0.08125")
+        if !sp.UpdateAt.Equal(time.Unix(1639555200, 123000000)) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
+        }
+    })
+
+    t.Run("ListSymbols", func(t *testing.T) {
+        markets, err := client.ListSymbols(context.Background())
+
+        assertMarkets(t, markets, err, Market{"ETHBTC", "ETH", "BTC"}, Market{"BNBUSDT", "BNB", "USDT"})
+    })
+
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("ABC123")
+        _, err := client.GetSymbolPrice(context.Background(), "ABC123")
 
         if err == nil {
             t.Fatalf("Should throws on invalid symbol")
         }
+        if !strings.Contains(err.Error(), "Invalid symbol.") {
+            t.Fatalf("Expecting error message from response, got %v", err)
+        }
     })
 }
@@ -1,28 +1,41 @@
 package exchange
 
 import (
+    "context"
     "encoding/json"
     "errors"
     "fmt"
-    "math"
     "strconv"
     "strings"
     "time"
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
+    "github.com/shopspring/decimal"
     "github.com/sirupsen/logrus"
+    "github.com/tidwall/gjson"
 )
 
 // https://docs.bitfinex.com/v2/docs
 const bitfinixBaseApi = "https://api.bitfinex.com/v2/" //Need api v2 to get kline
 
+// https://docs.bitfinex.com/docs/ws-public
+const bitfinixStreamApi = "wss://api-pub.bitfinex.com/ws/2"
+
 type bitfinixClient struct {
     *http.Client
+    *marketIndex
+    baseApi   string
+    streamApi string
 }
 
 func NewBitfinixClient(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient {
-    return &bitfinixClient{Client: httpClient}
+    client := &bitfinixClient{Client: httpClient}
+    client.baseApi = getBaseApi(queries, client.GetName(), bitfinixBaseApi)
+    client.streamApi = getStreamApi(queries, client.GetName(), bitfinixStreamApi)
+    limitRate(httpClient, queries, client.GetName(), client.baseApi, 0.5, 5) // Candles are limited to 30 requests per minute
+    client.marketIndex = newMarketIndex(client.GetName(), client.ListSymbols)
+    return client
 }
 
 func (client *bitfinixClient) GetName() string {
@@ -46,32 +59,54 @@ func (client *bitfinixClient) checkError(respContent []byte) error {
     return nil
 }
 
-func (client *bitfinixClient) GetKlinePrice(symbol, frame string, start time.Time) (float64, error) {
-    candlePath := fmt.Sprintf("candles/trade:%s:t%s/hist", frame, symbol)
-    respBytes, err := client.Get(bitfinixBaseApi+candlePath, http.WithQuery(map[string]string{
+var bitfinixCandleIntervals = map[time.Duration]string{
+    time.Minute: "1m", 5 * time.Minute: "5m", 15 * time.Minute: "15m", 30 * time.Minute: "30m", time.Hour: "1h",
+    3 * time.Hour: "3h", 6 * time.Hour: "6h", 12 * time.Hour: "12h", 24 * time.Hour: "1D", 7 * 24 * time.Hour: "1W",
+}
+
+func (client *bitfinixClient) CandleIntervals() []time.Duration {
+    return sortedIntervals(bitfinixCandleIntervals)
+}
+
+func (client *bitfinixClient) GetCandles(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]Candle, error) {
+    candlePath := fmt.Sprintf("candles/trade:%s:t%s/hist", bitfinixCandleIntervals[interval], strings.ToUpper(symbol))
+    respBytes, err := client.Get(ctx, client.baseApi+candlePath, http.WithQuery(map[string]string{
         "start": strconv.FormatInt(start.Unix()*1000, 10),
+        "end":   strconv.FormatInt(end.Unix()*1000, 10),
         "sort":  "1",
-        "limit": "1",
     }))
     if err != nil {
-        return 0, err
+        return nil, err
     }
     if err := client.checkError(respBytes); err != nil {
-        return 0, err
+        return nil, err
     }
 
-    var klineResp [][]float64
+    // [MTS, OPEN, CLOSE, HIGH, LOW, VOLUME]
+    var klineResp [][]decimal.Decimal
     if err := json.Unmarshal(respBytes, &klineResp); err != nil {
-        return 0, err
+        return nil, err
     }
-    logrus.Debugf("%s - %s Kline for %s uses price at %s", client.GetName(), symbol, start,
-        time.Unix(int64(klineResp[0][0])/1000, 0))
-    return klineResp[0][1], nil
+    candles := make([]Candle, 0, len(klineResp))
+    for _, kline := range klineResp {
+        if len(kline) < 6 {
+            return nil, fmt.Errorf("[%s] - not enough data in kline, get %v", client.GetName(), kline)
+        }
+        candles = append(candles, Candle{
+            Time:   time.Unix(0, kline[0].IntPart()*int64(time.Millisecond)),
+            Open:   kline[1],
+            Close:  kline[2],
+            High:   kline[3],
+            Low:    kline[4],
+            Volume: kline[5],
+        })
+    }
+    return candles, nil
 }
 
-func (client *bitfinixClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
+func (client *bitfinixClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
     symbol = strings.ToUpper(symbol)
-    respBytes, err := client.Get(bitfinixBaseApi + "ticker/t" + symbol)
+    respBytes, err := client.Get(ctx, client.baseApi+"ticker/t"+symbol)
     if err != nil {
         return nil, err
     }
@@ -79,45 +114,135 @@ func (client *bitfinixClient) GetSymbolPrice(symbol string) (*SymbolPrice, error
         return nil, err
     }
 
-    var tickerResp []float64
+    var tickerResp []decimal.Decimal
     if err := json.Unmarshal(respBytes, &tickerResp); err != nil {
         return nil, err
     }
-    if len(tickerResp) < 7 {
+    // [BID, BID_SIZE, ASK, ASK_SIZE, DAILY_CHANGE, DAILY_CHANGE_RELATIVE, LAST_PRICE, VOLUME, HIGH, LOW]
+    if len(tickerResp) < 10 {
         return nil, fmt.Errorf("[%s] - not enough data in response array, get %v", client.GetName(), tickerResp)
     }
 
-    percentChange1h, percentChange24h := math.MaxFloat64, math.MaxFloat64
     currentPrice := tickerResp[6]
-    now := time.Now()
-    lastHour := now.Add(-1 * time.Hour)
-    lastHourPrice, err := client.GetKlinePrice(symbol, "1m", lastHour)
-    if err != nil {
-        logrus.Warnf("Failed to get price 1 hour ago, error: %v", err)
-    }
-    if lastHourPrice != 0 {
-        percentChange1h = (currentPrice - lastHourPrice) / lastHourPrice * 100
+    now := client.Now()
+    lastHourPrice, err1h := priceAgo(ctx, client, symbol, now, time.Hour)
+    if err1h != nil {
+        logrus.Warnf("Failed to get price 1 hour ago, error: %v", err1h)
     }
 
-    lastDay := now.Add(-24 * time.Hour)
-    lastDayPrice, err := client.GetKlinePrice(symbol, "1m", lastDay)
-    if err != nil {
-        logrus.Warnf("Failed to get price 24 hour ago, error: %v", err)
-    }
-    if lastDayPrice != 0 {
-        percentChange24h = (currentPrice - lastDayPrice) / lastDayPrice * 100
+    lastDayPrice, err24h := priceAgo(ctx, client, symbol, now, 24*time.Hour)
+    if err24h != nil {
+        logrus.Warnf("Failed to get price 24 hour ago, error: %v", err24h)
     }
 
     return &SymbolPrice{
         Symbol:           strings.ToUpper(symbol),
-        Price:            strconv.FormatFloat(currentPrice, 'f', -1, 64),
+        Price:            currentPrice,
         Source:           client.GetName(),
-        UpdateAt:         time.Now(),
-        PercentChange1h:  percentChange1h,
-        PercentChange24h: percentChange24h,
+        UpdateAt:         client.Now(),
+        Price1hAgo:       lastHourPrice,
+        Price24hAgo:      lastDayPrice,
+        PercentChange1h:  changeSince(currentPrice, lastHourPrice, err1h),
+        PercentChange24h: changeSince(currentPrice, lastDayPrice, err24h),
+        Volume24h:        decimal.NewNullDecimal(tickerResp[7]),
+        High24h:          decimal.NewNullDecimal(tickerResp[8]),
+        Low24h:           decimal.NewNullDecimal(tickerResp[9]),
+        Bid:              decimal.NewNullDecimal(tickerResp[0]),
+        Ask:              decimal.NewNullDecimal(tickerResp[2]),
     }, nil
 }
 
+// Every symbol is subscribed to a channel, updates come as [CHANNEL_ID, TICKER]
+func (client *bitfinixClient) StreamSymbolPrices(ctx context.Context, symbols []string, out chan<- *SymbolPrice) error {
+    conn, err := client.DialWebsocket(ctx, client.streamApi)
+    if err != nil {
+        return err
+    }
+    queried := make(map[string]string, len(symbols))
+    for _, symbol := range symbols {
+        symbol = strings.ToUpper(symbol)
+        queried["t"+symbol] = symbol
+        subscription := map[string]string{"event": "subscribe", "channel": "ticker", "symbol": "t" + symbol}
+        if err := conn.WriteJSON(subscription); err != nil {
+            return err
+        }
+    }
+
+    channels := make(map[int64]string, len(symbols)) // Channel id -> symbol
+    for {
+        _, message, err := conn.ReadMessage()
+        if err != nil {
+            return err
+        }
+        messageV := gjson.ParseBytes(message)
+        if messageV.IsObject() {
+            switch messageV.Get("event").String() {
+            case "subscribed":
+                channels[messageV.Get("chanId").Int()] = queried[messageV.Get("symbol").String()]
+            case "error":
+                return fmt.Errorf("bitfinex subscribe: %s", messageV.Get("msg").String())
+            }
+            continue
+        }
+        symbol, ok := channels[messageV.Get("0").Int()]
+        if !ok || !messageV.Get("1").IsArray() {
+            continue // Heartbeats
+        }
+        var tickerResp []decimal.Decimal
+        if err := json.Unmarshal([]byte(messageV.Get("1").Raw), &tickerResp); err != nil {
+            return err
+        }
+        // [BID, BID_SIZE, ASK, ASK_SIZE, DAILY_CHANGE, DAILY_CHANGE_RELATIVE, LAST_PRICE, VOLUME, HIGH, LOW]
+        if len(tickerResp) < 10 {
+            return fmt.Errorf("[%s] - not enough data in ticker array, get %v", client.GetName(), tickerResp)
+        }
+        currentPrice := tickerResp[6]
+        lastDayPrice := currentPrice.Sub(tickerResp[4])
+        err = sendPrice(ctx, out, &SymbolPrice{
+            Symbol:           symbol,
+            Price:            currentPrice,
+            Source:           client.GetName(),
+            UpdateAt:         client.Now(),
+            Price24hAgo:      lastDayPrice,
+            PercentChange1h:  UnknownChange(ReasonNotSupported),
+            PercentChange24h: percentChange(currentPrice, lastDayPrice),
+            Volume24h:        decimal.NewNullDecimal(tickerResp[7]),
+            High24h:          decimal.NewNullDecimal(tickerResp[8]),
+            Low24h:           decimal.NewNullDecimal(tickerResp[9]),
+            Bid:              decimal.NewNullDecimal(tickerResp[0]),
+            Ask:              decimal.NewNullDecimal(tickerResp[2]),
+        })
+        if err != nil {
+            return err
+        }
+    }
+}
+
+func (client *bitfinixClient) ListSymbols(ctx context.Context) ([]Market, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"conf/pub:list:pair:exchange")
+    if err != nil {
+        return nil, err
+    }
+
+    var pairs [][]string
+    if err := json.Unmarshal(respBytes, &pairs); err != nil {
+        return nil, err
+    }
+    if len(pairs) == 0 {
+        return nil, fmt.Errorf("unexpected pair list %s", respBytes)
+    }
+    markets := make([]Market, 0, len(pairs[0]))
+    for _, pair := range pairs[0] {
+        // Currencies longer than 3 letters are separated by a colon, eg. TESTBTC:TESTUSD
+        m := marketOf(pair, ":", false)
+        if m.Base == "" && len(pair) == 6 {
+            m.Base, m.Quote = pair[:3], pair[3:]
+        }
+        markets = append(markets, unaliasMarket(client.GetName(), m))
+    }
+    return markets, nil
+}
+
 func init() {
     Register(NewBitfinixClient)
 }
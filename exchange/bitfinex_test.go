@@ -1,29 +1,97 @@
 package exchange
 
 import (
+    "context"
+    "strings"
     "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
 )
 
 func TestBitfinixClient(t *testing.T) {
 
-    var client = registry.getClient("Bitfinex").(*bitfinixClient)
+    var client = newFixtureClient(t, "Bitfinex", map[string]fixture{
+        "/v2/ticker/tBTCUSD":    {file: "ticker.json"},
+        "/v2/ticker/tBTCUSD121": {file: "unknown_symbol.json", status: 500},
+        "/v2/candles/trade:1m:tBTCUSD/hist?start=1639551540000":  {file: "candles_1h.json"},
+        "/v2/candles/trade:30m:tBTCUSD/hist?start=1639467000000": {file: "candles_24h.json"},
+        "/v2/conf/pub:list:pair:exchange":                        {file: "pairs.json"},
+    }).(*bitfinixClient)
+
+    t.Run("GetCandles", func(t *testing.T) {
+        start := fixtureTime.Add(-61 * time.Minute)
+        candles, err := client.GetCandles(context.Background(), "btcusd", time.Minute, start, start.Add(2*time.Minute))
+
+        if err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        if len(candles) != 1 || !candles[0].Time.Equal(fixtureTime.Add(-time.Hour)) {
+            t.Fatalf("Unexpected candles %v", candles)
+        }
+        assertPrice(t, "Open", candles[0].Open, "46200")
+        assertPrice(t, "Close", candles[0].Close, "46150")
+        assertPrice(t, "High", candles[0].High, "46250")
+    })
 
     t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("btcusd")
+        sp, err := client.GetSymbolPrice(context.Background(), "btcusd")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
-            t.Fatalf("Get an empty price?")
+        assertPrice(t, "Price", sp.Price, "46000.5")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, (46000.5-46200)/46200*100)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, (46000.5-40000.5)/40000.5*100)
+        assertStatistics(t, sp, "8123.45", "47000", "45000", "46000", "46001")
+        if sp.QuoteCurrency != "" {
+            t.Fatalf("Expecting quote currency %q, got %q", "", sp.QuoteCurrency)
+        }
+        if !sp.UpdateAt.Equal(fixtureTime) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
         }
     })
 
+    t.Run("ListSymbols", func(t *testing.T) {
+        markets, err := client.ListSymbols(context.Background())
+
+        assertMarkets(t, markets, err, Market{"BTCUSD", "BTC", "USD"}, Market{"ETHUSD", "ETH", "USD"}, Market{"BTCUST", "BTC", "USDT"},
+            Market{"TESTBTC:TESTUSD", "TESTBTC", "TESTUSD"})
+    })
+
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("btcusd121")
+        _, err := client.GetSymbolPrice(context.Background(), "btcusd121")
 
         if err == nil {
             t.Fatalf("Should throws error '400 Unknown symbol'")
         }
+        if !strings.Contains(err.Error(), "symbol: invalid") {
+            t.Fatalf("Expecting error message from response, got %v", err)
+        }
+    })
+
+    t.Run("StreamSymbolPrices", func(t *testing.T) {
+        client := newStreamFixtureClient(t, "Bitfinex", nil, func(conn *websocket.Conn) {
+            var subscription struct {
+                Symbol string
+            }
+            if err := conn.ReadJSON(&subscription); err != nil || subscription.Symbol != "tBTCUSD" {
+                t.Errorf("Expecting a subscription to tBTCUSD, got %+v, error: %v", subscription, err)
+                return
+            }
+            conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"info","version":2,"platform":{"status":1}}`))
+            conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"subscribed","channel":"ticker","chanId":224555,"symbol":"tBTCUSD","pair":"BTCUSD"}`))
+            conn.WriteMessage(websocket.TextMessage, []byte(`[224555,"hb"]`))
+            conn.WriteMessage(websocket.TextMessage, readFixture(t, "bitfinex", "stream_ticker.json"))
+            conn.ReadMessage() // Hold until the client is gone
+        })
+        sp := collectStreamed(t, client, []string{"btcusd"}, 1)[0]
+
+        if sp.Symbol != "BTCUSD" {
+            t.Fatalf("Expecting symbol BTCUSD, got %s", sp.Symbol)
+        }
+        assertPrice(t, "Price", sp.Price, "46000.5")
+        assertPrice(t, "Price24hAgo", sp.Price24hAgo, "46500.5")
+        assertStatistics(t, sp, "8123.45", "47000", "45000", "46000", "46001")
     })
 }
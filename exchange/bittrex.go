@@ -1,17 +1,15 @@
 package exchange
 
 import (
+    "context"
     "encoding/json"
     "errors"
-    "fmt"
-    "math"
-    "sort"
-    "strconv"
     "strings"
     "time"
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
+    "github.com/shopspring/decimal"
     "github.com/sirupsen/logrus"
 )
 
@@ -23,10 +21,18 @@ const bittrexV2BaseApi = "https://bittrex.com/Api/v2.0/pub/market/"
 
 type bittrexClient struct {
     *http.Client
+    *marketIndex
+    baseApi   string
+    v2BaseApi string
 }
 
 func NewBittrexClient(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient {
-    return &bittrexClient{Client: httpClient}
+    client := &bittrexClient{Client: httpClient}
+    client.baseApi = getBaseApi(queries, client.GetName(), bittrexBaseApi)
+    client.v2BaseApi = getBaseApi(queries, client.GetName(), bittrexV2BaseApi)
+    limitRate(httpClient, queries, client.GetName(), client.baseApi, 1, 5) // 60 requests per minute, v2 api shares the same host
+    client.marketIndex = newMarketIndex(client.GetName(), client.ListSymbols)
+    return client
 }
 
 type bittrexCommonResponse struct {
@@ -37,20 +43,32 @@ type bittrexCommonResponse struct {
 type bittrexTickerResponse struct {
     bittrexCommonResponse
     Result struct {
-        Last float64
+        Bid  decimal.NullDecimal
+        Ask  decimal.NullDecimal
+        Last decimal.Decimal
     }
 }
 
 type bittrexKlineResponse struct {
     bittrexCommonResponse
     Result []struct {
-        High       float64 `json:"H"`
-        Open       float64 `json:"O"`
-        Close      float64 `json:"C"`
-        Low        float64 `json:"L"`
-        Volume     float64 `json:"V"`
-        BaseVolume float64 `json:"BV"`
-        Timestamp  string  `json:"T"`
+        High       decimal.Decimal `json:"H"`
+        Open       decimal.Decimal `json:"O"`
+        Close      decimal.Decimal `json:"C"`
+        Low        decimal.Decimal `json:"L"`
+        Volume     decimal.Decimal `json:"V"`
+        BaseVolume decimal.Decimal `json:"BV"`
+        Timestamp  string          `json:"T"`
+    }
+}
+
+type bittrexMarketsResponse struct {
+    bittrexCommonResponse
+    Result []struct {
+        MarketName     string
+        MarketCurrency string
+        BaseCurrency   string // Bittrex names the quote currency base
+        IsActive       bool
     }
 }
 
@@ -62,6 +80,10 @@ func (resp *bittrexKlineResponse) getCommonResponse() bittrexCommonResponse {
     return resp.bittrexCommonResponse
 }
 
+func (resp *bittrexMarketsResponse) getCommonResponse() bittrexCommonResponse {
+    return resp.bittrexCommonResponse
+}
+
 // Any way to hold the common response, instead of adding an interface here?
 type bittrexCommonResponseProvider interface {
     getCommonResponse() bittrexCommonResponse
@@ -84,11 +106,18 @@ func (client *bittrexClient) decodeResponse(respBytes []byte, respJSON bittrexCo
     return nil
 }
 
-func (client *bittrexClient) GetKlineTicks(market, interval string) (*bittrexKlineResponse, error) {
-    market = strings.ToLower(market)
-    respBytes, err := client.Get(bittrexV2BaseApi+"/GetTicks", http.WithQuery(map[string]string{
-        "marketName":   market,
-        "tickInterval": interval,
+// oneMin and fiveMin are too large, bittrex doesn't support filter
+var bittrexCandleIntervals = map[time.Duration]string{30 * time.Minute: "thirtyMin", time.Hour: "hour", 24 * time.Hour: "day"}
+
+func (client *bittrexClient) CandleIntervals() []time.Duration {
+    return sortedIntervals(bittrexCandleIntervals)
+}
+
+// Bittrex returns all candles it has, start and end are ignored
+func (client *bittrexClient) GetCandles(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]Candle, error) {
+    respBytes, err := client.Get(ctx, client.v2BaseApi+"GetTicks", http.WithQuery(map[string]string{
+        "marketName":   strings.ToLower(symbol),
+        "tickInterval": bittrexCandleIntervals[interval],
     }))
     if err != nil {
         return nil, err
@@ -99,25 +128,26 @@ func (client *bittrexClient) GetKlineTicks(market, interval string) (*bittrexKli
     if err != nil {
         return nil, err
     }
-    return &respJSON, nil
-}
-
-func (client *bittrexClient) GetPriceRightAfter(klineResp *bittrexKlineResponse, after time.Time) (float64, error) {
-    for _, candle := range klineResp.Result {
-        candleTime, err := time.Parse("2006-01-02T15:04:05", candle.Timestamp)
-        if err == nil {
-            if after.Equal(candleTime) || after.Before(candleTime) {
-                // Assume candles are sorted in asc order, so the first less than or equal to is the candle looking for
-                logrus.Debugf("%s - Kline for %v uses open price at %v", client.GetName(), after.Local(), candleTime.Local())
-                return candle.Open, nil
-            }
+    candles := make([]Candle, 0, len(respJSON.Result))
+    for _, tick := range respJSON.Result {
+        candleTime, err := time.Parse("2006-01-02T15:04:05", tick.Timestamp)
+        if err != nil {
+            return nil, err
         }
+        candles = append(candles, Candle{
+            Time:   candleTime,
+            Open:   tick.Open,
+            High:   tick.High,
+            Low:    tick.Low,
+            Close:  tick.Close,
+            Volume: tick.Volume,
+        })
     }
-    return 0, fmt.Errorf("no time found right after %v", after)
+    return candles, nil
 }
 
-func (client *bittrexClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
-    respBytes, err := client.Get(bittrexBaseApi+"/public/getticker", http.WithQuery(map[string]string{"market": strings.ToUpper(symbol)}))
+func (client *bittrexClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"public/getticker", http.WithQuery(map[string]string{"market": strings.ToUpper(symbol)}))
     if err != nil {
         return nil, err
     }
@@ -128,43 +158,51 @@ func (client *bittrexClient) GetSymbolPrice(symbol string) (*SymbolPrice, error)
         return nil, err
     }
 
-    var percentChange1h, percentChange24h = math.MaxFloat64, math.MaxFloat64
-    klineResp, err := client.GetKlineTicks(symbol, "thirtyMin") // oneMin, fiveMin are too large, bittrex doesn't support filter
-    if err != nil {
-        logrus.Warnf("%s - Failed to get kline ticks, error: %v", client.GetName(), err)
-    } else {
-        now := time.Now()
-        sort.Slice(klineResp.Result, func(i, j int) bool {
-            return klineResp.Result[i].Timestamp < klineResp.Result[j].Timestamp
-        })
-
-        lastHour := now.Add(-1 * time.Hour)
-        price1hAgo, err := client.GetPriceRightAfter(klineResp, lastHour)
-        if err != nil {
-            logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err)
-        } else if price1hAgo != 0 {
-            percentChange1h = (respJSON.Result.Last - price1hAgo) / price1hAgo * 100
-        }
-
-        last24Hour := now.Add(-24 * time.Hour)
-        price24hAgo, err := client.GetPriceRightAfter(klineResp, last24Hour)
-        if err != nil {
-            logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err)
-        } else if price24hAgo != 0 {
-            percentChange24h = (respJSON.Result.Last - price24hAgo) / price24hAgo * 100
-        }
+    now := client.Now()
+    price1hAgo, err1h := priceAgo(ctx, client, symbol, now, time.Hour)
+    if err1h != nil {
+        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err1h)
+    }
+    price24hAgo, err24h := priceAgo(ctx, client, symbol, now, 24*time.Hour)
+    if err24h != nil {
+        logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err24h)
     }
 
     return &SymbolPrice{
         Symbol:           symbol,
-        Price:            strconv.FormatFloat(respJSON.Result.Last, 'f', -1, 64),
-        UpdateAt:         time.Now(),
+        Price:            respJSON.Result.Last,
+        UpdateAt:         client.Now(),
         Source:           client.GetName(),
-        PercentChange1h:  percentChange1h,
-        PercentChange24h: percentChange24h,
+        Price1hAgo:       price1hAgo,
+        Price24hAgo:      price24hAgo,
+        PercentChange1h:  changeSince(respJSON.Result.Last, price1hAgo, err1h),
+        PercentChange24h: changeSince(respJSON.Result.Last, price24hAgo, err24h),
+        Bid:              respJSON.Result.Bid,
+        Ask:              respJSON.Result.Ask,
+        QuoteCurrency:    quoteCurrency(symbol, "-", true),
     }, nil
 }
 
+func (client *bittrexClient) ListSymbols(ctx context.Context) ([]Market, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"public/getmarkets")
+    if err != nil {
+        return nil, err
+    }
+
+    var respJSON bittrexMarketsResponse
+    if err := client.decodeResponse(respBytes, &respJSON); err != nil {
+        return nil, err
+    }
+    markets := make([]Market, 0, len(respJSON.Result))
+    for _, m := range respJSON.Result {
+        if !m.IsActive {
+            continue
+        }
+        markets = append(markets, Market{Symbol: m.MarketName, Base: m.MarketCurrency, Quote: m.BaseCurrency})
+    }
+    return markets, nil
+}
+
 func init() {
     Register(NewBittrexClient)
 }
@@ -1,53 +1,71 @@
 package exchange
 
 import (
+    "context"
     "testing"
     "time"
 )
 
 func TestBittrexClient(t *testing.T) {
 
-    var client = registry.getClient("bittrex").(*bittrexClient)
+    var client = newFixtureClient(t, "bittrex", map[string]fixture{
+        "/api/v1.1/public/getticker?market=USDT-BTC":                               {file: "ticker.json"},
+        "/api/v1.1/public/getticker?market=ABC123":                                 {file: "invalid_market.json"},
+        "/Api/v2.0/pub/market/GetTicks?marketName=usdt-btc&tickInterval=thirtyMin": {file: "ticks.json"},
+        "/Api/v2.0/pub/market/GetTicks?marketName=abcedfg":                         {file: "invalid_market.json"},
+        "/api/v1.1/public/getmarkets":                                              {file: "markets.json"},
+    }).(*bittrexClient)
 
-    t.Run("GetKlineTicks", func(t *testing.T) {
-        klineResp, err := client.GetKlineTicks("USDT-BTc", "thirtyMin")
+    t.Run("GetCandles", func(t *testing.T) {
+        candles, err := client.GetCandles(context.Background(), "USDT-BTc", 30*time.Minute, fixtureTime.Add(-time.Hour), fixtureTime)
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-
-        now := time.Now()
-        lastHour := now.Add(-1 * time.Hour)
-        _, err = client.GetPriceRightAfter(klineResp, lastHour)
-
-        if err != nil {
-            t.Fatalf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err)
+        if len(candles) != 4 || !candles[2].Time.Equal(fixtureTime.Add(-time.Hour)) {
+            t.Fatalf("Unexpected candles %v", candles)
         }
+        assertPrice(t, "Open", candles[2].Open, "45000")
+        assertPrice(t, "Close", candles[2].Close, "45050")
     })
 
-    t.Run("GetKlineTicks of unknown symbol", func(t *testing.T) {
-        time.Sleep(time.Second * 1)
-        _, err := client.GetKlineTicks("abcedfg", "thirtyMin")
+    t.Run("GetCandles of unknown symbol", func(t *testing.T) {
+        _, err := client.GetCandles(context.Background(), "abcedfg", 30*time.Minute, fixtureTime.Add(-time.Hour), fixtureTime)
 
         if err == nil {
             t.Fatalf("Expecting error when fetching unknown price, but get nil")
         }
-        t.Logf("Returned error is %v, expected?", err)
+        if err.Error() != "INVALID_MARKET" {
+            t.Fatalf("Expecting error message from response, got %v", err)
+        }
     })
 
     t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("USDT-BTc")
+        sp, err := client.GetSymbolPrice(context.Background(), "USDT-BTc")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
-            t.Fatalf("Get an empty price?")
+        assertPrice(t, "Price", sp.Price, "46020.456")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, (46020.456-45000)/45000*100)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, (46020.456-50000)/50000*100)
+        assertStatistics(t, sp, "", "", "", "46010.123", "46020.456")
+        if sp.QuoteCurrency != "USDT" {
+            t.Fatalf("Expecting quote currency %q, got %q", "USDT", sp.QuoteCurrency)
         }
+        if !sp.UpdateAt.Equal(fixtureTime) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
+        }
+    })
+
+    t.Run("ListSymbols", func(t *testing.T) {
+        markets, err := client.ListSymbols(context.Background())
+
+        assertMarkets(t, markets, err, Market{"USDT-BTC", "BTC", "USDT"}, Market{"BTC-LTC", "LTC", "BTC"})
     })
 
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("ABC123")
+        _, err := client.GetSymbolPrice(context.Background(), "ABC123")
 
         if err == nil {
             t.Fatalf("Should throws on invalid symbol")
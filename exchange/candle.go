@@ -0,0 +1,159 @@
+package exchange
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+
+    "github.com/shopspring/decimal"
+    "github.com/sirupsen/logrus"
+)
+
+// Candle is one kline of a symbol, opening at Time and lasting an interval
+type Candle struct {
+    Time   time.Time
+    Open   decimal.Decimal
+    High   decimal.Decimal
+    Low    decimal.Decimal
+    Close  decimal.Decimal
+    Volume decimal.Decimal // In base currency, zero if unknown
+}
+
+// CandleProvider is implemented by exchanges having kline endpoints, so the price of any window ago
+// is looked up the same way, see priceAgo
+type CandleProvider interface {
+    ExchangeClient
+    // CandleIntervals lists supported intervals in ascending order
+    CandleIntervals() []time.Duration
+    // GetCandles returns candles of the interval covering [start, end] in any order, a few more around them are fine
+    GetCandles(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]Candle, error)
+}
+
+// A window is looked up in candles fine enough to span it with at most this many
+const maxCandlesPerWindow = 60
+
+// Sort intervals supported by an exchange, keyed by how they are named in its api
+func sortedIntervals(named map[time.Duration]string) []time.Duration {
+    intervals := make([]time.Duration, 0, len(named))
+    for interval := range named {
+        intervals = append(intervals, interval)
+    }
+    sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+    return intervals
+}
+
+// Choose the finest interval spanning the window with at most maxCandlesPerWindow candles,
+// or the coarsest one if none is coarse enough
+func candleIntervalFor(intervals []time.Duration, window time.Duration) time.Duration {
+    for _, interval := range intervals {
+        if window <= interval*maxCandlesPerWindow {
+            return interval
+        }
+    }
+    return intervals[len(intervals)-1]
+}
+
+// Find the price at the given time in candles of the interval, which is the open of the candle containing it,
+// or the close of an earlier one if nothing traded since. Exchanges may skip candles without trades, so the first
+// candle is taken if it opens no later than an interval after.
+func priceAt(candles []Candle, at time.Time, interval time.Duration) (decimal.Decimal, time.Time, error) {
+    if len(candles) == 0 {
+        return decimal.Zero, time.Time{}, errors.New("got an empty kline")
+    }
+    sorted := make([]Candle, len(candles))
+    copy(sorted, candles)
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+    // Index of the first candle opening after at
+    i := sort.Search(len(sorted), func(i int) bool { return sorted[i].Time.After(at) })
+    if i == 0 {
+        if first := sorted[0]; !first.Time.After(at.Add(interval)) {
+            return first.Open, first.Time, nil
+        }
+        return decimal.Zero, time.Time{}, fmt.Errorf("no candle found around %v, the first one opens at %v", at.Local(), sorted[0].Time.Local())
+    }
+    candle := sorted[i-1]
+    if candle.Time.Add(interval).After(at) {
+        return candle.Open, candle.Time, nil
+    }
+    return candle.Close, candle.Time.Add(interval), nil
+}
+
+// Look up the price of symbol window ago from now, in candles chosen by candleIntervalFor
+func priceAgo(ctx context.Context, provider CandleProvider, symbol string, now time.Time, window time.Duration) (decimal.Decimal, error) {
+    interval := candleIntervalFor(provider.CandleIntervals(), window)
+    at := now.Add(-window)
+    candles, err := provider.GetCandles(ctx, symbol, interval, at.Add(-interval), at.Add(interval))
+    if err != nil {
+        return decimal.Zero, err
+    }
+    price, pricedAt, err := priceAt(candles, at, interval)
+    if err != nil {
+        return decimal.Zero, err
+    }
+    logrus.Debugf("%s - Kline of %s for %v uses price at %v", provider.GetName(), interval, at.Local(), pricedAt.Local())
+    return price, nil
+}
+
+// Prices of a window ago change once per candle at most, look them up again after this long
+func candlePeriodOf(window time.Duration) time.Duration {
+    if period := window / maxCandlesPerWindow; period > time.Minute {
+        return period
+    }
+    return time.Minute
+}
+
+// PriceHistory looks up prices saved locally, see history.Store
+type PriceHistory interface {
+    // PriceAt returns the last price saved no later than at, and no earlier than tolerance before it
+    PriceAt(source, symbol string, at time.Time, tolerance time.Duration) (decimal.Decimal, error)
+}
+
+// A saved price is taken as the one a window ago if it's no older than a tenth of the window
+func historyToleranceOf(window time.Duration) time.Duration {
+    if tolerance := window / 10; tolerance > time.Minute {
+        return tolerance
+    }
+    return time.Minute
+}
+
+// Fill in changes of windows other than 1h and 24h, in candles of exchanges having them, or in prices saved
+// in history for others. Windows already given by the exchange are kept, eg. 7d and 30d of CoinMarketCap.
+func (r *Registry) fillChanges(ctx context.Context, client ExchangeClient, sp *SymbolPrice) {
+    provider, hasCandles := client.(CandleProvider)
+    var windows []time.Duration
+    for _, window := range r.changeWindows {
+        if _, ok := sp.PercentChanges[window]; ok {
+            continue
+        }
+        if !hasCandles && r.history == nil {
+            sp.setChange(window, decimal.Zero, UnknownChange(ReasonNotSupported))
+            continue
+        }
+        windows = append(windows, window)
+    }
+
+    prices, errs := make([]decimal.Decimal, len(windows)), make([]error, len(windows))
+    forEachConcurrently(len(windows), func(i int) {
+        window, key := windows[i], client.GetName()+"."+sp.Symbol
+        price, err := r.pastPrices[window].get(key, sp.UpdateAt.Add(-window), func() (decimal.Decimal, error) {
+            if !hasCandles {
+                return r.history.PriceAt(client.GetName(), sp.Symbol, sp.UpdateAt.Add(-window), historyToleranceOf(window))
+            }
+            return priceAgo(ctx, provider, sp.Symbol, sp.UpdateAt, window)
+        })
+        if err != nil && !hasCandles {
+            // Expected until enough prices are saved
+            logrus.Debugf("%s - No price %s ago of %s in history, error: %v", client.GetName(), window, sp.Symbol, err)
+        } else if err != nil && ctx.Err() == nil {
+            logrus.Warnf("%s - Failed to get price %s ago of %s, error: %v", client.GetName(), window, sp.Symbol, err)
+            errs[i] = err
+        }
+        prices[i] = price
+    })
+    for i, window := range windows {
+        sp.setChange(window, prices[i], changeSince(sp.Price, prices[i], errs[i]))
+    }
+}
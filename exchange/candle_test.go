@@ -0,0 +1,171 @@
+package exchange
+
+import (
+    "context"
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/shopspring/decimal"
+)
+
+// Serves candles of every interval from memory, opening at multiples of the interval and priced by their open time
+type fakeCandleProvider struct {
+    ExchangeClient
+    intervals []time.Duration
+    mu        sync.Mutex
+    requested []time.Duration
+}
+
+func (p *fakeCandleProvider) CandleIntervals() []time.Duration {
+    return p.intervals
+}
+
+func (p *fakeCandleProvider) GetCandles(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]Candle, error) {
+    p.mu.Lock()
+    p.requested = append(p.requested, interval)
+    p.mu.Unlock()
+    var candles []Candle
+    for t := start.Truncate(interval); !t.After(end); t = t.Add(interval) {
+        // Most exchanges return the latest first
+        candles = append([]Candle{candleAt(t, t.Unix())}, candles...)
+    }
+    return candles, nil
+}
+
+func candleAt(t time.Time, open int64) Candle {
+    return Candle{Time: t, Open: decimal.NewFromInt(open), Close: decimal.NewFromInt(open + 1)}
+}
+
+func TestPriceAt(t *testing.T) {
+    at := fixtureTime.Add(-time.Hour)
+
+    t.Run("either order", func(t *testing.T) {
+        asc := []Candle{candleAt(at.Add(-time.Minute), 1), candleAt(at, 2), candleAt(at.Add(time.Minute), 3)}
+        desc := []Candle{asc[2], asc[1], asc[0]}
+        for _, candles := range [][]Candle{asc, desc} {
+            price, _, err := priceAt(candles, at.Add(30*time.Second), time.Minute)
+            if err != nil {
+                t.Fatalf("Unexpected error: %v", err)
+            }
+            assertPrice(t, "price", price, "2")
+        }
+        if desc[0].Time != asc[2].Time {
+            t.Fatalf("Candles of the caller should not be reordered")
+        }
+    })
+
+    t.Run("nothing traded since", func(t *testing.T) {
+        candles := []Candle{candleAt(at.Add(-5*time.Minute), 1), candleAt(at.Add(5*time.Minute), 3)}
+        price, pricedAt, err := priceAt(candles, at, time.Minute)
+        if err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        assertPrice(t, "close of an earlier candle", price, "2")
+        if !pricedAt.Equal(at.Add(-4 * time.Minute)) {
+            t.Fatalf("Expecting the price at close time, got %v", pricedAt)
+        }
+    })
+
+    t.Run("first candle right after", func(t *testing.T) {
+        price, _, err := priceAt([]Candle{candleAt(at.Add(time.Minute), 3)}, at, time.Minute)
+        if err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        assertPrice(t, "price", price, "3")
+
+        if _, _, err := priceAt([]Candle{candleAt(at.Add(2*time.Minute), 3)}, at, time.Minute); err == nil {
+            t.Fatalf("Expecting error when candles start long after")
+        }
+        if _, _, err := priceAt(nil, at, time.Minute); err == nil {
+            t.Fatalf("Expecting error on empty candles")
+        }
+    })
+}
+
+func TestPriceAgo(t *testing.T) {
+    provider := &fakeCandleProvider{
+        ExchangeClient: registry.getClient("binance"),
+        intervals:      []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, time.Hour, 4 * time.Hour},
+    }
+    for _, tc := range []struct {
+        window   time.Duration
+        interval time.Duration
+    }{
+        {5 * time.Minute, time.Minute},
+        {time.Hour, time.Minute},
+        {4 * time.Hour, 5 * time.Minute},
+        {24 * time.Hour, 30 * time.Minute},
+        {7 * 24 * time.Hour, 4 * time.Hour},
+        {30 * 24 * time.Hour, 4 * time.Hour}, // Coarsest one as the last resort
+    } {
+        provider.requested = nil
+        price, err := priceAgo(context.Background(), provider, "BTCUSDT", fixtureTime, tc.window)
+        if err != nil {
+            t.Fatalf("Unexpected error of %s: %v", tc.window, err)
+        }
+        if len(provider.requested) != 1 || provider.requested[0] != tc.interval {
+            t.Fatalf("Expecting %s candles for %s, requested %v", tc.interval, tc.window, provider.requested)
+        }
+        at := fixtureTime.Add(-tc.window)
+        assertPrice(t, "price "+tc.window.String()+" ago", price, decimal.NewFromInt(at.Truncate(tc.interval).Unix()).String())
+    }
+}
+
+func TestRegistry_fillChanges(t *testing.T) {
+    cfg := &config.Config{Columns: []string{config.ColumnSymbol, "%Change(1h)", "%change(15m)", "%Change(7d)", "%Change(30d)"}}
+    r := NewRegistry(cfg, newHTTPClient(t, cfg))
+    provider := &fakeCandleProvider{ExchangeClient: r.getClient("binance"), intervals: []time.Duration{time.Minute, time.Hour}}
+
+    sp := &SymbolPrice{Symbol: "BTCUSDT", Price: decimal.NewFromInt(fixtureTime.Unix()), UpdateAt: fixtureTime}
+    sp.setChange(30*24*time.Hour, decimal.Zero, KnownChange(-10)) // Given by the exchange
+    r.fillChanges(context.Background(), provider, sp)
+
+    if len(provider.requested) != 2 {
+        t.Fatalf("Expecting candles of 15m and 7d only, requested %v", provider.requested)
+    }
+    assertPrice(t, "price 15m ago", sp.PricesAgo[15*time.Minute], decimal.NewFromInt(fixtureTime.Add(-15*time.Minute).Unix()).String())
+    if change := sp.PercentChange(7 * 24 * time.Hour); !change.Known || change.Percent <= 0 {
+        t.Fatalf("Expecting a rise over 7d, got %+v", change)
+    }
+    assertPercentChange(t, "PercentChange(30d)", sp.PercentChange(30*24*time.Hour), -10)
+
+    // Cached until the next candle
+    sp = &SymbolPrice{Symbol: "BTCUSDT", UpdateAt: fixtureTime.Add(time.Second)}
+    sp.setChange(30*24*time.Hour, decimal.Zero, KnownChange(-10))
+    r.fillChanges(context.Background(), provider, sp)
+    if len(provider.requested) != 2 {
+        t.Fatalf("Expecting cached prices ago, requested %v", provider.requested)
+    }
+
+    sp = &SymbolPrice{Symbol: "BTC", Price: decimal.NewFromInt(1), UpdateAt: fixtureTime}
+    r.fillChanges(context.Background(), r.getClient("coinmarketcap"), sp)
+    if change := sp.PercentChange(15 * time.Minute); change.Known || change.Reason != ReasonNotSupported {
+        t.Fatalf("Expecting unsupported change without candles, got %+v", change)
+    }
+}
+
+// Saved prices of BTC on CoinMarketCap, priced by their time
+type fakeHistory struct{}
+
+func (fakeHistory) PriceAt(source, symbol string, at time.Time, tolerance time.Duration) (decimal.Decimal, error) {
+    if source != "CoinMarketCap" || symbol != "BTC" || tolerance != 90*time.Second {
+        return decimal.Zero, fmt.Errorf("no price of %s on %s within %s", symbol, source, tolerance)
+    }
+    return decimal.NewFromInt(at.Unix()), nil
+}
+
+func TestRegistry_fillChanges_history(t *testing.T) {
+    cfg := &config.Config{Columns: []string{config.ColumnSymbol, "%Change(15m)", "%Change(7d)"}}
+    r := NewRegistry(cfg, newHTTPClient(t, cfg))
+    r.SetHistory(fakeHistory{})
+
+    sp := &SymbolPrice{Symbol: "BTC", Price: decimal.NewFromInt(fixtureTime.Unix()), UpdateAt: fixtureTime}
+    r.fillChanges(context.Background(), r.getClient("coinmarketcap"), sp)
+    assertPrice(t, "price 15m ago", sp.PricesAgo[15*time.Minute], decimal.NewFromInt(fixtureTime.Add(-15*time.Minute).Unix()).String())
+    if change := sp.PercentChange(7 * 24 * time.Hour); change.Known {
+        t.Fatalf("Expecting unknown change without prices saved within tolerance, got %+v", change)
+    }
+}
@@ -1,90 +1,233 @@
 package exchange
 
 import (
+    "context"
+    "encoding/json"
+    "errors"
     "fmt"
-    "math"
-    "sort"
     "strconv"
+    "strings"
     "time"
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
-    "github.com/preichenberger/go-coinbasepro/v2"
+    "github.com/shopspring/decimal"
     "github.com/sirupsen/logrus"
+    "github.com/tidwall/gjson"
 )
 
+// https://docs.cloud.coinbase.com/exchange/reference
+const coinbaseBaseApi = "https://api.pro.coinbase.com/"
+
+// https://docs.cloud.coinbase.com/exchange/docs/websocket-overview
+const coinbaseStreamApi = "wss://ws-feed.pro.coinbase.com"
+
 type coinbaseClient struct {
-    coinbasepro *coinbasepro.Client
+    *http.Client
+    *marketIndex
+    baseApi   string
+    streamApi string
 }
 
 func NewCoinBaseClient(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient {
-    client := coinbasepro.NewClient()
-    client.HTTPClient = httpClient.StdClient
-    return &coinbaseClient{coinbasepro: client}
+    client := &coinbaseClient{Client: httpClient}
+    client.baseApi = getBaseApi(queries, client.GetName(), coinbaseBaseApi)
+    client.streamApi = getStreamApi(queries, client.GetName(), coinbaseStreamApi)
+    limitRate(httpClient, queries, client.GetName(), client.baseApi, 3, 6) // 3 requests per second, up to 6 in bursts
+    client.marketIndex = newMarketIndex(client.GetName(), client.ListSymbols)
+    return client
 }
 
 func (client *coinbaseClient) GetName() string {
     return "Coinbase"
 }
 
-func (client *coinbaseClient) GetPriceRightAfter(candles []coinbasepro.HistoricRate, after time.Time) (float64, error) {
-    for _, candle := range candles {
-        if after.Equal(candle.Time) || after.After(candle.Time) {
-            // Assume candles are sorted in desc order, so the first less than or equal to is the candle looking for
-            logrus.Debugf("%s - Kline for %v uses open price at %v", client.GetName(), after.Local(), candle.Time.Local())
-            return candle.Open, nil
+// Errors come as {"message": "NotFound"} with a non-2xx status
+func (client *coinbaseClient) get(ctx context.Context, path string, query map[string]string) ([]byte, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+path, http.WithQuery(query))
+    if err != nil {
+        if msg := gjson.GetBytes(respBytes, "message").String(); msg != "" {
+            return nil, errors.New(msg)
         }
+        return nil, err
     }
-    return 0, fmt.Errorf("no time found right after %v", after)
+    return respBytes, nil
 }
 
-func (client *coinbaseClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
-    ticker, err := client.coinbasepro.GetTicker(symbol)
+func (client *coinbaseClient) CandleIntervals() []time.Duration {
+    return []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour}
+}
+
+func (client *coinbaseClient) GetCandles(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]Candle, error) {
+    respBytes, err := client.get(ctx, "products/"+strings.ToUpper(symbol)+"/candles", map[string]string{
+        "granularity": strconv.Itoa(int(interval / time.Second)),
+        "start":       start.UTC().Format(time.RFC3339),
+        "end":         end.UTC().Format(time.RFC3339),
+    })
     if err != nil {
         return nil, err
     }
-    currentPrice, err := strconv.ParseFloat(ticker.Price, 64)
-    if err != nil {
+
+    // [TIME, LOW, HIGH, OPEN, CLOSE, VOLUME]
+    var rates [][]decimal.Decimal
+    if err := json.Unmarshal(respBytes, &rates); err != nil {
         return nil, err
     }
+    candles := make([]Candle, 0, len(rates))
+    for _, rate := range rates {
+        if len(rate) < 6 {
+            return nil, fmt.Errorf("[%s] - not enough data in candle, get %v", client.GetName(), rate)
+        }
+        candles = append(candles, Candle{
+            Time:   time.Unix(rate[0].IntPart(), 0),
+            Low:    rate[1],
+            High:   rate[2],
+            Open:   rate[3],
+            Close:  rate[4],
+            Volume: rate[5],
+        })
+    }
+    return candles, nil
+}
 
-    var percentChange1h, percentChange24h = math.MaxFloat64, math.MaxFloat64
-    candles, err := client.coinbasepro.GetHistoricRates(symbol, coinbasepro.GetHistoricRatesParams{
-        Granularity: 300,
-    })
+func (client *coinbaseClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
+    respBytes, err := client.get(ctx, "products/"+strings.ToUpper(symbol)+"/ticker", nil)
     if err != nil {
-        logrus.Warnf("%s - Failed to get kline ticks, error: %v", client.GetName(), err)
-    } else {
-        now := time.Now()
-        sort.Slice(candles, func(i, j int) bool { return candles[i].Time.After(candles[j].Time) })
-
-        lastHour := now.Add(-1 * time.Hour)
-        price1hAgo, err := client.GetPriceRightAfter(candles, lastHour)
-        if err != nil {
-            logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err)
-        } else if price1hAgo != 0 {
-            percentChange1h = (currentPrice - price1hAgo) / price1hAgo * 100
-        }
+        return nil, err
+    }
+    var ticker struct {
+        Price  decimal.Decimal
+        Bid    decimal.NullDecimal
+        Ask    decimal.NullDecimal
+        Volume decimal.NullDecimal
+        Time   time.Time
+    }
+    if err := json.Unmarshal(respBytes, &ticker); err != nil {
+        return nil, err
+    }
+    currentPrice := ticker.Price
 
-        last24Hour := now.Add(-24 * time.Hour)
-        price24hAgo, err := client.GetPriceRightAfter(candles, last24Hour)
-        if err != nil {
-            logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err)
-        } else if price24hAgo != 0 {
-            percentChange24h = (currentPrice - price24hAgo) / price24hAgo * 100
-        }
+    now := client.Now()
+    price1hAgo, err1h := priceAgo(ctx, client, symbol, now, time.Hour)
+    if err1h != nil {
+        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err1h)
+    }
+    price24hAgo, err24h := priceAgo(ctx, client, symbol, now, 24*time.Hour)
+    if err24h != nil {
+        logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err24h)
     }
 
     return &SymbolPrice{
         Symbol:           symbol,
-        Price:            ticker.Price,
-        UpdateAt:         time.Time(ticker.Time),
+        Price:            currentPrice,
+        UpdateAt:         ticker.Time,
         Source:           client.GetName(),
-        PercentChange1h:  percentChange1h,
-        PercentChange24h: percentChange24h,
+        Price1hAgo:       price1hAgo,
+        Price24hAgo:      price24hAgo,
+        PercentChange1h:  changeSince(currentPrice, price1hAgo, err1h),
+        PercentChange24h: changeSince(currentPrice, price24hAgo, err24h),
+        Volume24h:        ticker.Volume,
+        Bid:              ticker.Bid,
+        Ask:              ticker.Ask,
+        QuoteCurrency:    quoteCurrency(symbol, "-", false),
     }, nil
 }
 
+func (client *coinbaseClient) StreamSymbolPrices(ctx context.Context, symbols []string, out chan<- *SymbolPrice) error {
+    productIDs := make([]string, len(symbols))
+    queried := make(map[string]string, len(symbols))
+    for i, symbol := range symbols {
+        productIDs[i] = strings.ToUpper(symbol)
+        queried[productIDs[i]] = symbol
+    }
+    conn, err := client.DialWebsocket(ctx, client.streamApi)
+    if err != nil {
+        return err
+    }
+    subscription := map[string]interface{}{
+        "type":        "subscribe",
+        "product_ids": productIDs,
+        "channels":    []string{"ticker"},
+    }
+    if err := conn.WriteJSON(subscription); err != nil {
+        return err
+    }
+
+    for {
+        var message struct {
+            Type      string
+            Message   string
+            ProductID string `json:"product_id"`
+            Price     decimal.Decimal
+            Open24h   decimal.Decimal     `json:"open_24h"`
+            Volume24h decimal.NullDecimal `json:"volume_24h"`
+            High24h   decimal.NullDecimal `json:"high_24h"`
+            Low24h    decimal.NullDecimal `json:"low_24h"`
+            BestBid   decimal.NullDecimal `json:"best_bid"`
+            BestAsk   decimal.NullDecimal `json:"best_ask"`
+            Time      time.Time
+        }
+        if err := conn.ReadJSON(&message); err != nil {
+            return err
+        }
+        switch message.Type {
+        case "error":
+            return fmt.Errorf("coinbase subscribe: %s", message.Message)
+        case "ticker":
+        default:
+            continue // eg. subscriptions
+        }
+        symbol, ok := queried[message.ProductID]
+        if !ok {
+            logrus.Debugf("%s - Unexpected ticker of %s", client.GetName(), message.ProductID)
+            continue
+        }
+        err = sendPrice(ctx, out, &SymbolPrice{
+            Symbol:           symbol,
+            Price:            message.Price,
+            UpdateAt:         message.Time,
+            Source:           client.GetName(),
+            Price24hAgo:      message.Open24h,
+            PercentChange1h:  UnknownChange(ReasonNotSupported),
+            PercentChange24h: percentChange(message.Price, message.Open24h),
+            Volume24h:        message.Volume24h,
+            High24h:          message.High24h,
+            Low24h:           message.Low24h,
+            Bid:              message.BestBid,
+            Ask:              message.BestAsk,
+            QuoteCurrency:    quoteCurrency(symbol, "-", false),
+        })
+        if err != nil {
+            return err
+        }
+    }
+}
+
+func (client *coinbaseClient) ListSymbols(ctx context.Context) ([]Market, error) {
+    respBytes, err := client.get(ctx, "products", nil)
+    if err != nil {
+        return nil, err
+    }
+    var products []struct {
+        ID              string
+        BaseCurrency    string `json:"base_currency"`
+        QuoteCurrency   string `json:"quote_currency"`
+        TradingDisabled bool   `json:"trading_disabled"`
+        Status          string
+    }
+    if err := json.Unmarshal(respBytes, &products); err != nil {
+        return nil, err
+    }
+    markets := make([]Market, 0, len(products))
+    for _, p := range products {
+        if p.TradingDisabled || p.Status == "delisted" {
+            continue
+        }
+        markets = append(markets, Market{Symbol: p.ID, Base: p.BaseCurrency, Quote: p.QuoteCurrency})
+    }
+    return markets, nil
+}
+
 func init() {
     Register(NewCoinBaseClient)
 }
@@ -1,36 +1,89 @@
 package exchange
 
 import (
-    "math"
+    "context"
     "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
 )
 
 func TestCoinbaseClient(t *testing.T) {
 
-    var client = registry.getClient("coinbase").(*coinbaseClient)
+    var client = newFixtureClient(t, "coinbase", map[string]fixture{
+        "/products/BTC-USD/ticker": {file: "ticker.json"},
+        "/products/BTC-USD/candles?granularity=60&start=2021-12-15T06:59:00Z":   {file: "candles_1h.json"},
+        "/products/BTC-USD/candles?granularity=3600&start=2021-12-14T07:00:00Z": {file: "candles_24h.json"},
+        "/products/ABC123/ticker": {file: "not_found.json", status: 404},
+        "/products":               {file: "products.json"},
+    }).(*coinbaseClient)
 
     t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("BTC-USd")
+        sp, err := client.GetSymbolPrice(context.Background(), "BTC-USD")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
-            t.Fatalf("Get an empty price?")
+        assertPrice(t, "Price", sp.Price, "46100.01")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, (46100.01-46000)/46000*100)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, (46100.01-48000)/48000*100)
+        assertStatistics(t, sp, "12345.6789", "", "", "46100", "46100.02")
+        if sp.QuoteCurrency != "USD" {
+            t.Fatalf("Expecting quote currency %q, got %q", "USD", sp.QuoteCurrency)
         }
-        if sp.PercentChange1h == math.MaxFloat64 {
-            t.Logf("WARNING - PercentChange1h unset?")
+        if !sp.UpdateAt.Equal(time.Date(2021, 12, 15, 7, 59, 58, 123e6, time.UTC)) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
         }
-        if sp.PercentChange24h == math.MaxFloat64 {
-            t.Logf("WARNING - PercentChange24h unset?")
+    })
+
+    t.Run("GetSymbolPrice cancelled", func(t *testing.T) {
+        ctx, cancel := context.WithCancel(context.Background())
+        cancel()
+        if _, err := client.GetSymbolPrice(ctx, "BTC-USD"); err == nil {
+            t.Fatalf("Expecting error once the context is done")
         }
     })
 
+    t.Run("ListSymbols", func(t *testing.T) {
+        markets, err := client.ListSymbols(context.Background())
+
+        assertMarkets(t, markets, err, Market{"BTC-USD", "BTC", "USD"}, Market{"ETH-BTC", "ETH", "BTC"})
+    })
+
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("ABC123")
+        _, err := client.GetSymbolPrice(context.Background(), "ABC123")
 
         if err == nil {
             t.Fatalf("Should throws on invalid symbol")
         }
+        if err.Error() != "NotFound" {
+            t.Fatalf("Expecting error message from response, got %v", err)
+        }
+    })
+
+    t.Run("StreamSymbolPrices", func(t *testing.T) {
+        client := newStreamFixtureClient(t, "coinbase", nil, func(conn *websocket.Conn) {
+            var subscription struct {
+                ProductIDs []string `json:"product_ids"`
+            }
+            if err := conn.ReadJSON(&subscription); err != nil || len(subscription.ProductIDs) != 1 || subscription.ProductIDs[0] != "BTC-USD" {
+                t.Errorf("Expecting a subscription to BTC-USD, got %+v, error: %v", subscription, err)
+                return
+            }
+            conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscriptions","channels":[{"name":"ticker","product_ids":["BTC-USD"]}]}`))
+            conn.WriteMessage(websocket.TextMessage, readFixture(t, "coinbase", "stream_ticker.json"))
+            conn.ReadMessage() // Hold until the client is gone
+        })
+        sp := collectStreamed(t, client, []string{"btc-usd"}, 1)[0]
+
+        if sp.Symbol != "btc-usd" {
+            t.Fatalf("Expecting symbol as subscribed, got %s", sp.Symbol)
+        }
+        assertPrice(t, "Price", sp.Price, "46100.01")
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, (46100.01-48000)/48000*100)
+        assertStatistics(t, sp, "12345.6789", "48500", "45500.5", "46100", "46100.02")
+        if !sp.UpdateAt.Equal(time.Date(2021, 12, 15, 7, 59, 58, 123e6, time.UTC)) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
+        }
     })
 }
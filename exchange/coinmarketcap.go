@@ -1,12 +1,15 @@
 package exchange
 
 import (
+    "context"
     "errors"
     "fmt"
     "strings"
+    "time"
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
+    "github.com/shopspring/decimal"
     "github.com/tidwall/gjson"
 )
 
@@ -15,7 +18,8 @@ const coinmarketcapBaseApi = "https://pro-api.coinmarketcap.com"
 
 type coinMarketCapClient struct {
     *http.Client
-    APIKey string
+    baseApi string
+    APIKey  string
 }
 
 // An example 200 response
@@ -72,7 +76,9 @@ type coinMarketCapClient struct {
 
 func NewCoinMarketCapClient(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient {
     c := &coinMarketCapClient{Client: httpClient}
-    if query, ok := queries[strings.ToUpper(c.GetName())]; ok { // If user queries CoinMarketCap, then API key is required
+    c.baseApi = getBaseApi(queries, c.GetName(), coinmarketcapBaseApi)
+    limitRate(httpClient, queries, c.GetName(), c.baseApi, 0.5, 5) // 30 requests per minute for basic plan
+    if query, ok := queries[strings.ToUpper(c.GetName())]; ok {    // If user queries CoinMarketCap, then API key is required
         c.APIKey = query.APIKey
         if c.APIKey == "" {
             panic(fmt.Errorf("%s now requires API key, get one from https://coinmarketcap.com/api/", c.GetName()))
@@ -91,8 +97,8 @@ func (client *coinMarketCapClient) HTTPHeader() map[string]string {
     }
 }
 
-func (client *coinMarketCapClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
-    respBytes, err := client.Get(coinmarketcapBaseApi+"/v1/cryptocurrency/quotes/latest",
+func (client *coinMarketCapClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"/v1/cryptocurrency/quotes/latest",
         http.WithQuery(map[string]string{"symbol": strings.ToUpper(symbol)}),
         http.WithHeader(client.HTTPHeader()))
     // If there is a more specific error
@@ -113,13 +119,43 @@ func (client *coinMarketCapClient) GetSymbolPrice(symbol string) (*SymbolPrice,
         return nil, fmt.Errorf("quote.USD not found in %q", symbol)
     }
 
-    return &SymbolPrice{
+    // Raw JSON number, so no precision is lost
+    price, err := decimal.NewFromString(usdQuote.Get("price").Raw)
+    if err != nil {
+        return nil, fmt.Errorf("invalid price of %q: %w", symbol, err)
+    }
+
+    // Only percentage changes are provided, prices ago are left unknown
+    sp := &SymbolPrice{
         Symbol:           symbolInfo.Get("symbol").String(),
-        Price:            usdQuote.Get("price").String(),
+        Price:            price,
         Source:           client.GetName(),
         UpdateAt:         usdQuote.Get("last_updated").Time(),
-        PercentChange1h:  usdQuote.Get("percent_change_1h").Float(),
-        PercentChange24h: usdQuote.Get("percent_change_24h").Float()}, nil
+        PercentChange1h:  client.getChange(usdQuote, "percent_change_1h"),
+        PercentChange24h: client.getChange(usdQuote, "percent_change_24h"),
+        QuoteCurrency:    "USD"}
+    for window, key := range coinMarketCapChangeKeys {
+        if usdQuote.Get(key).Exists() {
+            sp.setChange(window, decimal.Zero, client.getChange(usdQuote, key))
+        }
+    }
+    return sp, nil
+}
+
+// Changes of longer windows come with the quote
+var coinMarketCapChangeKeys = map[time.Duration]string{
+    7 * 24 * time.Hour:  "percent_change_7d",
+    30 * 24 * time.Hour: "percent_change_30d",
+    60 * 24 * time.Hour: "percent_change_60d",
+    90 * 24 * time.Hour: "percent_change_90d",
+}
+
+func (client *coinMarketCapClient) getChange(quote gjson.Result, key string) Change {
+    changeV := quote.Get(key)
+    if changeV.Type != gjson.Number {
+        return UnknownChange(ReasonNotSupported) // Missing or null for newly listed tokens
+    }
+    return KnownChange(changeV.Float())
 }
 
 func init() {
@@ -1,31 +1,50 @@
 package exchange
 
 import (
+    "context"
+    "strings"
     "testing"
+    "time"
 )
 
 func TestCoinMarketCapClient(t *testing.T) {
 
-    t.Skip("CoinMarketCap turns their api into private, need to investigate more.")
-
-    var client = registry.getClient("coinMarketCap").(*coinMarketCapClient)
+    var client = newFixtureClient(t, "coinMarketCap", map[string]fixture{
+        "/v1/cryptocurrency/quotes/latest?symbol=BTC":        {file: "quotes_latest.json"},
+        "/v1/cryptocurrency/quotes/latest?symbol=BITCOIN222": {file: "invalid_symbol.json", status: 400},
+    }).(*coinMarketCapClient)
 
     t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("bitcoin")
+        sp, err := client.GetSymbolPrice(context.Background(), "btc")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
-            t.Fatalf("Get an empty price?")
+        assertPrice(t, "Price", sp.Price, "46088.920608781234")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, 0.15396551)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, -2.31814582)
+        assertPercentChange(t, "PercentChange(7d)", sp.PercentChange(7*24*time.Hour), -8.8879362)
+        assertPercentChange(t, "PercentChange(30d)", sp.PercentChange(30*24*time.Hour), -28.30631608)
+        if change := sp.PercentChange(60 * 24 * time.Hour); change.Known {
+            t.Fatalf("Expecting unknown change of a window not returned, got %v", change)
+        }
+        assertStatistics(t, sp, "", "", "", "", "")
+        if sp.QuoteCurrency != "USD" {
+            t.Fatalf("Expecting quote currency %q, got %q", "USD", sp.QuoteCurrency)
+        }
+        if !sp.UpdateAt.Equal(time.Date(2021, 12, 15, 7, 58, 2, 0, time.UTC)) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
         }
     })
 
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("bitcoin222")
+        _, err := client.GetSymbolPrice(context.Background(), "bitcoin222")
 
         if err == nil {
             t.Fatalf("Should throws error 'id not found'")
         }
+        if !strings.Contains(err.Error(), "Invalid value") {
+            t.Fatalf("Expecting error message from response, got %v", err)
+        }
     })
 }
@@ -0,0 +1,229 @@
+package exchange
+
+import (
+    "context"
+    "io/ioutil"
+    stdhttp "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/http"
+    "github.com/shopspring/decimal"
+)
+
+// When responses under testdata were recorded, clocks of clients under test are frozen at this time
+var fixtureTime = time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC)
+
+// A canned response read from testdata/<exchange>/<file>
+type fixture struct {
+    file   string
+    status int           // 200 if not set
+    delay  time.Duration // Hold the response for a while, to simulate a slow exchange
+}
+
+// Start a local server answering with canned responses, and return a client of the exchange talking to it.
+// Routes are keyed by URL path, optionally followed by query parameters that must also match,
+// eg. "/public?command=returnTicker", the route with most matched parameters wins.
+func newFixtureClient(t *testing.T, exchangeName string, routes map[string]fixture) ExchangeClient {
+    return newFixtureRegistry(t, exchangeName, routes).getClient(exchangeName)
+}
+
+// Same as newFixtureClient, but return the registry holding the client
+func newFixtureRegistry(t *testing.T, exchangeName string, routes map[string]fixture) *Registry {
+    server := httptest.NewServer(fixtureHandler(t, exchangeName, routes))
+    t.Cleanup(server.Close)
+    return newRegistryOf(t, exchangeName, server.URL)
+}
+
+// Start a local server serving websocket connections with serve, other requests are answered by routes,
+// and return a streaming client of the exchange talking to it
+func newStreamFixtureClient(t *testing.T, exchangeName string, routes map[string]fixture, serve func(conn *websocket.Conn)) StreamingExchangeClient {
+    handler := fixtureHandler(t, exchangeName, routes)
+    server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, req *stdhttp.Request) {
+        if !websocket.IsWebSocketUpgrade(req) {
+            handler(w, req)
+            return
+        }
+        conn, err := (&websocket.Upgrader{}).Upgrade(w, req, nil)
+        if err != nil {
+            t.Errorf("Failed to upgrade to websocket: %v", err)
+            return
+        }
+        defer conn.Close()
+        serve(conn)
+    }))
+    t.Cleanup(server.Close)
+    client, ok := newRegistryOf(t, exchangeName, server.URL).getClient(exchangeName).(StreamingExchangeClient)
+    if !ok {
+        t.Fatalf("%s cannot stream prices", exchangeName)
+    }
+    return client
+}
+
+// Subscribe to symbols and return the first n streamed prices
+func collectStreamed(t *testing.T, client StreamingExchangeClient, symbols []string, n int) []*SymbolPrice {
+    t.Helper()
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    out := make(chan *SymbolPrice)
+    errCh := make(chan error, 1)
+    go func() { errCh <- client.StreamSymbolPrices(ctx, symbols, out) }()
+
+    var prices []*SymbolPrice
+    for len(prices) < n {
+        select {
+        case sp := <-out:
+            prices = append(prices, sp)
+        case err := <-errCh:
+            t.Fatalf("Stream ended after %d prices, error: %v", len(prices), err)
+        }
+    }
+    return prices
+}
+
+// Read a fixture, nil if it cannot be read. It's called by servers outside the test goroutine, so it fails the test
+// without stopping it.
+func readFixture(t *testing.T, exchangeName, file string) []byte {
+    body, err := ioutil.ReadFile(filepath.Join("testdata", strings.ToLower(exchangeName), file))
+    if err != nil {
+        t.Errorf("Failed to read fixture: %v", err)
+        return nil
+    }
+    return body
+}
+
+// Answer requests with canned responses of routes
+func fixtureHandler(t *testing.T, exchangeName string, routes map[string]fixture) stdhttp.HandlerFunc {
+    return func(w stdhttp.ResponseWriter, req *stdhttp.Request) {
+        matched, matchedParams := "", -1
+        for route := range routes {
+            path, rawQuery := route, ""
+            if i := strings.Index(route, "?"); i != -1 {
+                path, rawQuery = route[:i], route[i+1:]
+            }
+            if path != req.URL.Path {
+                continue
+            }
+            params := 0
+            for _, kv := range strings.Split(rawQuery, "&") {
+                if kv == "" {
+                    continue
+                }
+                pair := strings.SplitN(kv, "=", 2)
+                if len(pair) != 2 || req.URL.Query().Get(pair[0]) != pair[1] {
+                    params = -1
+                    break
+                }
+                params++
+            }
+            if params > matchedParams {
+                matched, matchedParams = route, params
+            }
+        }
+        if matched == "" {
+            t.Errorf("No fixture found for %s", req.URL)
+            stdhttp.NotFound(w, req)
+            return
+        }
+
+        f := routes[matched]
+        body := readFixture(t, exchangeName, f.file)
+        if body == nil {
+            stdhttp.Error(w, "fixture not readable", stdhttp.StatusInternalServerError)
+            return
+        }
+        if f.delay > 0 {
+            select {
+            case <-time.After(f.delay):
+            case <-req.Context().Done():
+                return
+            }
+        }
+        if f.status == 0 {
+            f.status = stdhttp.StatusOK
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(f.status)
+        w.Write(body)
+    }
+}
+
+func newHTTPClient(t *testing.T, cfg *config.Config) *http.Client {
+    httpClient, err := http.New(cfg)
+    if err != nil {
+        t.Fatalf("Failed to create http client: %v", err)
+    }
+    return httpClient
+}
+
+func newRegistryOf(t *testing.T, exchangeName, serverURL string) *Registry {
+    // Fixtures are served locally, no need to throttle
+    cfg := &config.Config{Queries: []*config.PriceQuery{{Name: exchangeName, APIKey: "test", BaseURL: serverURL, RateLimit: -1}}}
+    httpClient := newHTTPClient(t, cfg)
+    httpClient.Clock = func() time.Time { return fixtureTime }
+    registry := NewRegistry(cfg, httpClient)
+    client := registry.getClient(exchangeName)
+    if client == nil {
+        t.Fatalf("Unknown exchange %s", exchangeName)
+    }
+    return registry
+}
+
+func assertPrice(t *testing.T, name string, got decimal.Decimal, want string) {
+    t.Helper()
+    if !got.Equal(decimal.RequireFromString(want)) {
+        t.Fatalf("Expecting %s %s, got %s", name, want, got)
+    }
+}
+
+// Check 24 hours statistics and the top of order book, an empty string means unknown
+func assertStatistics(t *testing.T, sp *SymbolPrice, volume, high, low, bid, ask string) {
+    t.Helper()
+    for _, field := range []struct {
+        name string
+        got  decimal.NullDecimal
+        want string
+    }{{"Volume24h", sp.Volume24h, volume}, {"High24h", sp.High24h, high}, {"Low24h", sp.Low24h, low}, {"Bid", sp.Bid, bid}, {"Ask", sp.Ask, ask}} {
+        if field.want == "" {
+            if field.got.Valid {
+                t.Fatalf("Expecting %s unknown, got %s", field.name, field.got.Decimal)
+            }
+            continue
+        }
+        if !field.got.Valid {
+            t.Fatalf("Expecting %s %s, got unknown", field.name, field.want)
+        }
+        assertPrice(t, field.name, field.got.Decimal, field.want)
+    }
+}
+
+func assertPercentChange(t *testing.T, name string, got Change, want float64) {
+    t.Helper()
+    if !got.Known {
+        t.Fatalf("Expecting %s %v, got unknown as %s", name, want, got.Reason)
+    }
+    if diff := got.Percent - want; diff > 1e-6 || diff < -1e-6 {
+        t.Fatalf("Expecting %s %v, got %v", name, want, got.Percent)
+    }
+}
+
+// Check markets listed, in any order
+func assertMarkets(t *testing.T, markets []Market, err error, want ...Market) {
+    t.Helper()
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    for _, list := range [][]Market{markets, want} {
+        sort.Slice(list, func(i, j int) bool { return list[i].Symbol < list[j].Symbol })
+    }
+    if !reflect.DeepEqual(markets, want) {
+        t.Fatalf("Expecting markets %v, got %v", want, markets)
+    }
+}
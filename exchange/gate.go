@@ -1,6 +1,7 @@
 package exchange
 
 import (
+    "context"
     "encoding/json"
     "errors"
     "fmt"
@@ -11,6 +12,7 @@ import (
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
+    "github.com/shopspring/decimal"
     "github.com/sirupsen/logrus"
 )
 
@@ -19,6 +21,8 @@ const gateBaseApi = "http://data.gateio.io/api2/1/"
 
 type gateClient struct {
     *http.Client
+    *marketIndex
+    baseApi string
 }
 
 type gateCommonResponse struct {
@@ -28,7 +32,13 @@ type gateCommonResponse struct {
 
 type gateTickerResponse struct {
     gateCommonResponse
-    Last float64 `json:",string"`
+    Last       decimal.Decimal
+    HighestBid decimal.NullDecimal
+    LowestAsk  decimal.NullDecimal
+    High24hr   decimal.NullDecimal
+    Low24hr    decimal.NullDecimal
+    // Gate swaps base and quote volume, this one is in base currency, eg. BTC of btc_usdt
+    QuoteVolume decimal.NullDecimal
 }
 
 type gateKlineResponse struct {
@@ -50,7 +60,11 @@ type gateCommonResponseProvider interface {
 }
 
 func NewGateClient(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient {
-    return &gateClient{Client: httpClient}
+    client := &gateClient{Client: httpClient}
+    client.baseApi = getBaseApi(queries, client.GetName(), gateBaseApi)
+    limitRate(httpClient, queries, client.GetName(), client.baseApi, 10, 10)
+    client.marketIndex = newMarketIndex(client.GetName(), client.ListSymbols)
+    return client
 }
 
 func (client *gateClient) GetName() string {
@@ -70,35 +84,53 @@ func (client *gateClient) decodeResponse(respBytes []byte, respJSON gateCommonRe
     return nil
 }
 
-func (client *gateClient) GetKlinePrice(symbol string, groupedSeconds int, size int) (float64, error) {
+// Seems gate.io only supports 60, 300, 600 etc. seconds
+func (client *gateClient) CandleIntervals() []time.Duration {
+    return []time.Duration{time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+        time.Hour, 4 * time.Hour, 8 * time.Hour, 24 * time.Hour}
+}
+
+// Gate returns candles of the last hours, end is ignored
+func (client *gateClient) GetCandles(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]Candle, error) {
     symbol = strings.ToLower(symbol)
-    respBytes, err := client.Get(gateBaseApi+"candlestick2/"+symbol, http.WithQuery(map[string]string{
-        "group_sec":  strconv.Itoa(groupedSeconds),
-        "range_hour": strconv.Itoa(size),
+    rangeHours := int(math.Ceil(client.Now().Sub(start).Hours()))
+    respBytes, err := client.Get(ctx, client.baseApi+"candlestick2/"+symbol, http.WithQuery(map[string]string{
+        "group_sec":  strconv.Itoa(int(interval / time.Second)),
+        "range_hour": strconv.Itoa(rangeHours),
     }))
     if err != nil {
-        return 0, err
+        return nil, err
     }
 
     var respJSON gateKlineResponse
     err = client.decodeResponse(respBytes, &respJSON)
     if err != nil {
-        return 0, err
+        return nil, err
     }
-    if len(respJSON.Data) == 0 {
-        return 0, fmt.Errorf("%s - get a zero size kline response", client.GetName())
+    candles := make([]Candle, 0, len(respJSON.Data))
+    for _, kline := range respJSON.Data {
+        // timestamp, volume, close, high, low, open
+        if len(kline) < 6 {
+            return nil, fmt.Errorf("%s - malformed kline %v", client.GetName(), kline)
+        }
+        ts, err := strconv.ParseInt(kline[0], 10, 64)
+        if err != nil {
+            return nil, err
+        }
+        candles = append(candles, Candle{
+            Time:   time.Unix(0, ts*int64(time.Millisecond)),
+            Open:   parseDecimal(kline[5]),
+            High:   parseDecimal(kline[3]),
+            Low:    parseDecimal(kline[4]),
+            Close:  parseDecimal(kline[2]),
+            Volume: parseDecimal(kline[1]),
+        })
     }
-    ts, err := strconv.ParseInt(respJSON.Data[0][0], 10, 64)
-    if err != nil {
-        return 0, err
-    }
-    logrus.Debugf("%s - Kline for %v hour(s) uses price at %s", client.GetName(), size,
-        time.Unix(ts/1000, 0))
-    return strconv.ParseFloat(respJSON.Data[0][5], 64)
+    return candles, nil
 }
 
-func (client *gateClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
-    respBytes, err := client.Get(gateBaseApi + "ticker/" + symbol)
+func (client *gateClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"ticker/"+symbol)
     if err != nil {
         return nil, err
     }
@@ -109,31 +141,52 @@ func (client *gateClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
         return nil, err
     }
 
-    var percentChange1h, percentChange24h = math.MaxFloat64, math.MaxFloat64
-    price1hAgo, err := client.GetKlinePrice(symbol, 60, 1)
-    if err != nil {
-        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err)
-    } else if price1hAgo != 0 {
-        percentChange1h = (respJSON.Last - price1hAgo) / price1hAgo * 100
+    now := client.Now()
+    price1hAgo, err1h := priceAgo(ctx, client, symbol, now, time.Hour)
+    if err1h != nil {
+        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err1h)
     }
 
-    price24hAgo, err := client.GetKlinePrice(symbol, 300, 24) // Seems gate.io only supports 60, 300, 600 etc. seconds
-    if err != nil {
-        logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err)
-    } else if price24hAgo != 0 {
-        percentChange24h = (respJSON.Last - price24hAgo) / price24hAgo * 100
+    price24hAgo, err24h := priceAgo(ctx, client, symbol, now, 24*time.Hour)
+    if err24h != nil {
+        logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err24h)
     }
 
     return &SymbolPrice{
         Symbol:           symbol,
-        Price:            strconv.FormatFloat(respJSON.Last, 'f', -1, 64),
-        UpdateAt:         time.Now(),
+        Price:            respJSON.Last,
+        UpdateAt:         client.Now(),
         Source:           client.GetName(),
-        PercentChange1h:  percentChange1h,
-        PercentChange24h: percentChange24h,
+        Price1hAgo:       price1hAgo,
+        Price24hAgo:      price24hAgo,
+        PercentChange1h:  changeSince(respJSON.Last, price1hAgo, err1h),
+        PercentChange24h: changeSince(respJSON.Last, price24hAgo, err24h),
+        Volume24h:        respJSON.QuoteVolume,
+        High24h:          respJSON.High24hr,
+        Low24h:           respJSON.Low24hr,
+        Bid:              respJSON.HighestBid,
+        Ask:              respJSON.LowestAsk,
+        QuoteCurrency:    quoteCurrency(symbol, "_", false),
     }, nil
 }
 
+func (client *gateClient) ListSymbols(ctx context.Context) ([]Market, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"pairs")
+    if err != nil {
+        return nil, err
+    }
+
+    var pairs []string
+    if err := json.Unmarshal(respBytes, &pairs); err != nil {
+        return nil, err
+    }
+    markets := make([]Market, len(pairs))
+    for i, pair := range pairs {
+        markets[i] = marketOf(pair, "_", false)
+    }
+    return markets, nil
+}
+
 func init() {
     Register(NewGateClient)
 }
@@ -1,45 +1,73 @@
 package exchange
 
 import (
+    "context"
     "testing"
     "time"
 )
 
 func TestGateClient(t *testing.T) {
 
-    var client = registry.getClient("gate").(*gateClient)
+    var client = newFixtureClient(t, "gate", map[string]fixture{
+        "/api2/1/ticker/btc_usdt":                                    {file: "ticker.json"},
+        "/api2/1/ticker/ABC123":                                      {file: "invalid_pair.json"},
+        "/api2/1/candlestick2/btc_usdt?group_sec=60&range_hour=2":    {file: "candles_1h.json"},
+        "/api2/1/candlestick2/btc_usdt?group_sec=1800&range_hour=25": {file: "candles_24h.json"},
+        "/api2/1/candlestick2/abcedfg":                               {file: "invalid_pair.json"},
+        "/api2/1/pairs":                                              {file: "pairs.json"},
+    }).(*gateClient)
 
-    t.Run("GetKlinePrice", func(t *testing.T) {
-        _, err := client.GetKlinePrice("bTC_usdt", 60, 1)
+    t.Run("GetCandles", func(t *testing.T) {
+        candles, err := client.GetCandles(context.Background(), "bTC_usdt", time.Minute, fixtureTime.Add(-61*time.Minute), fixtureTime)
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
+        if len(candles) != 2 || !candles[0].Time.Equal(fixtureTime.Add(-time.Hour)) {
+            t.Fatalf("Unexpected candles %v", candles)
+        }
+        assertPrice(t, "Open", candles[0].Open, "45500")
+        assertPrice(t, "Close", candles[0].Close, "45500.5")
+        assertPrice(t, "Volume", candles[0].Volume, "1.2345")
     })
 
-    t.Run("GetKlinePrice of unknown symbol", func(t *testing.T) {
-        time.Sleep(time.Second * 1)
-        _, err := client.GetKlinePrice("abcedfg", 60, 1)
+    t.Run("GetCandles of unknown symbol", func(t *testing.T) {
+        _, err := client.GetCandles(context.Background(), "abcedfg", time.Minute, fixtureTime.Add(-61*time.Minute), fixtureTime)
 
         if err == nil {
             t.Fatalf("Expecting error when fetching unknown price, but get nil")
         }
-        t.Logf("Returned error is %v, expected?", err)
+        if err.Error() != "Error: invalid currency pair" {
+            t.Fatalf("Expecting error message from response, got %v", err)
+        }
     })
 
     t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("bTC_usdt")
+        sp, err := client.GetSymbolPrice(context.Background(), "btc_usdt")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
-            t.Fatalf("Get an empty price?")
+        assertPrice(t, "Price", sp.Price, "46000.1")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, (46000.1-45500)/45500*100)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, (46000.1-48000)/48000*100)
+        assertStatistics(t, sp, "1234.5678", "47000", "45000", "46000", "46000.2")
+        if sp.QuoteCurrency != "USDT" {
+            t.Fatalf("Expecting quote currency %q, got %q", "USDT", sp.QuoteCurrency)
+        }
+        if !sp.UpdateAt.Equal(fixtureTime) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
         }
     })
 
+    t.Run("ListSymbols", func(t *testing.T) {
+        markets, err := client.ListSymbols(context.Background())
+
+        assertMarkets(t, markets, err, Market{"eth_btc", "ETH", "BTC"}, Market{"btc_usdt", "BTC", "USDT"}, Market{"ltc_usdt", "LTC", "USDT"})
+    })
+
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("ABC123")
+        _, err := client.GetSymbolPrice(context.Background(), "ABC123")
 
         if err == nil {
             t.Fatalf("Should throws on invalid symbol")
@@ -1,15 +1,15 @@
 package exchange
 
 import (
+    "context"
     "encoding/json"
     "errors"
-    "math"
-    "strconv"
     "strings"
     "time"
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
+    "github.com/shopspring/decimal"
     "github.com/sirupsen/logrus"
 )
 
@@ -20,6 +20,8 @@ const hitBtcBaseApi = "https://api.hitbtc.com/api/2/"
 
 type hitBtcClient struct {
     *http.Client
+    *marketIndex
+    baseApi string
 }
 
 type hitBtcCommonResponse struct {
@@ -32,15 +34,24 @@ type hitBtcCommonResponse struct {
 
 type hitBtcTickerResponse struct {
     hitBtcCommonResponse
-    Last      float64 `json:",string"`
-    Open      float64 `json:",string"`
+    Last      decimal.Decimal
+    Open      decimal.Decimal
+    High      decimal.NullDecimal
+    Low       decimal.NullDecimal
+    Bid       decimal.NullDecimal
+    Ask       decimal.NullDecimal
+    Volume    decimal.NullDecimal
     Timestamp string
 }
 
 type hitBtcKlineResponse struct {
     hitBtcCommonResponse
     Timestamp string
-    Open      float64 `json:",string"`
+    Open      decimal.Decimal
+    Close     decimal.Decimal
+    Min       decimal.Decimal
+    Max       decimal.Decimal
+    Volume    decimal.Decimal
 }
 
 func (resp *hitBtcTickerResponse) getCommonResponse() hitBtcCommonResponse {
@@ -57,7 +68,11 @@ type hitBtcCommonResponseProvider interface {
 }
 
 func NewHitBtcClient(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient {
-    return &hitBtcClient{Client: httpClient}
+    client := &hitBtcClient{Client: httpClient}
+    client.baseApi = getBaseApi(queries, client.GetName(), hitBtcBaseApi)
+    limitRate(httpClient, queries, client.GetName(), client.baseApi, 20, 20) // 100 requests per second for market data
+    client.marketIndex = newMarketIndex(client.GetName(), client.ListSymbols)
+    return client
 }
 
 func (client *hitBtcClient) GetName() string {
@@ -77,25 +92,49 @@ func (client *hitBtcClient) decodeResponse(respBytes []byte, respJSON hitBtcComm
     return nil
 }
 
-func (client *hitBtcClient) GetKlinePrice(symbol, period string, limit int) (float64, error) {
-    respBytes, err := client.Get(hitBtcBaseApi+"public/candles/"+strings.ToUpper(symbol), http.WithQuery(map[string]string{
-        "period": period,
-        "limit":  strconv.Itoa(limit),
+var hitBtcCandleIntervals = map[time.Duration]string{
+    time.Minute: "M1", 3 * time.Minute: "M3", 5 * time.Minute: "M5", 15 * time.Minute: "M15", 30 * time.Minute: "M30",
+    time.Hour: "H1", 4 * time.Hour: "H4", 24 * time.Hour: "D1", 7 * 24 * time.Hour: "D7",
+}
+
+func (client *hitBtcClient) CandleIntervals() []time.Duration {
+    return sortedIntervals(hitBtcCandleIntervals)
+}
+
+func (client *hitBtcClient) GetCandles(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]Candle, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"public/candles/"+strings.ToUpper(symbol), http.WithQuery(map[string]string{
+        "period": hitBtcCandleIntervals[interval],
+        "from":   start.UTC().Format(time.RFC3339),
+        "till":   end.UTC().Format(time.RFC3339),
     }))
     if err != nil {
-        return 0, err
+        return nil, err
     }
 
     var respJSON []hitBtcKlineResponse
     if err := json.Unmarshal(respBytes, &respJSON); err != nil {
-        return 0, err
+        return nil, err
+    }
+    candles := make([]Candle, 0, len(respJSON))
+    for _, kline := range respJSON {
+        candleTime, err := time.Parse(time.RFC3339, kline.Timestamp)
+        if err != nil {
+            return nil, err
+        }
+        candles = append(candles, Candle{
+            Time:   candleTime,
+            Open:   kline.Open,
+            High:   kline.Max,
+            Low:    kline.Min,
+            Close:  kline.Close,
+            Volume: kline.Volume,
+        })
     }
-    logrus.Debugf("%s - Kline for %s*%v uses price at %s", client.GetName(), period, limit, respJSON[0].Timestamp)
-    return respJSON[0].Open, nil
+    return candles, nil
 }
 
-func (client *hitBtcClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
-    respBytes, err := client.Get(hitBtcBaseApi + "public/ticker/" + strings.ToUpper(symbol))
+func (client *hitBtcClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"public/ticker/"+strings.ToUpper(symbol))
     if err != nil {
         return nil, err
     }
@@ -110,30 +149,51 @@ func (client *hitBtcClient) GetSymbolPrice(symbol string) (*SymbolPrice, error)
         return nil, err
     }
 
-    var percentChange1h, percentChange24h = math.MaxFloat64, math.MaxFloat64
-    price1hAgo, err := client.GetKlinePrice(symbol, "M1", 60)
-    if err != nil {
-        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err)
-    } else if price1hAgo != 0 {
-        percentChange1h = (respJSON.Last - price1hAgo) / price1hAgo * 100
+    price1hAgo, err1h := priceAgo(ctx, client, symbol, client.Now(), time.Hour)
+    if err1h != nil {
+        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err1h)
     }
 
-    //price24hAgo_, err := client.GetKlinePrice(symbol, "M3", 480)
-    //logrus.Warnf("%s - %s", price24hAgo_, respJSON.Open)
-
     price24hAgo := respJSON.Open
-    percentChange24h = (respJSON.Last - price24hAgo) / price24hAgo * 100
 
     return &SymbolPrice{
         Symbol:           symbol,
-        Price:            strconv.FormatFloat(respJSON.Last, 'f', -1, 64),
+        Price:            respJSON.Last,
         UpdateAt:         updated,
         Source:           client.GetName(),
-        PercentChange1h:  percentChange1h,
-        PercentChange24h: percentChange24h,
+        Price1hAgo:       price1hAgo,
+        Price24hAgo:      price24hAgo,
+        PercentChange1h:  changeSince(respJSON.Last, price1hAgo, err1h),
+        PercentChange24h: percentChange(respJSON.Last, price24hAgo),
+        Volume24h:        respJSON.Volume,
+        High24h:          respJSON.High,
+        Low24h:           respJSON.Low,
+        Bid:              respJSON.Bid,
+        Ask:              respJSON.Ask,
     }, nil
 }
 
+func (client *hitBtcClient) ListSymbols(ctx context.Context) ([]Market, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"public/symbol")
+    if err != nil {
+        return nil, err
+    }
+
+    var symbols []struct {
+        ID            string
+        BaseCurrency  string
+        QuoteCurrency string
+    }
+    if err := json.Unmarshal(respBytes, &symbols); err != nil {
+        return nil, err
+    }
+    markets := make([]Market, len(symbols))
+    for i, s := range symbols {
+        markets[i] = Market{Symbol: s.ID, Base: s.BaseCurrency, Quote: s.QuoteCurrency}
+    }
+    return markets, nil
+}
+
 func init() {
     Register(NewHitBtcClient)
 }
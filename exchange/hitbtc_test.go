@@ -1,43 +1,71 @@
 package exchange
 
 import (
+    "context"
     "testing"
+    "time"
 )
 
 func TestHitBtcClient(t *testing.T) {
 
-    var client = registry.getClient("hitbtc").(*hitBtcClient)
+    var client = newFixtureClient(t, "hitbtc", map[string]fixture{
+        "/api/2/public/ticker/BTCUSD":                                      {file: "ticker.json"},
+        "/api/2/public/ticker/ABC123":                                      {file: "symbol_not_found.json", status: 400},
+        "/api/2/public/candles/BTCUSD?period=M1&from=2021-12-15T06:59:00Z": {file: "candles.json"},
+        "/api/2/public/candles/ABCEDFG":                                    {file: "symbol_not_found.json", status: 400},
+        "/api/2/public/symbol":                                             {file: "symbols.json"},
+    }).(*hitBtcClient)
 
-    t.Run("GetKlinePrice", func(t *testing.T) {
-        _, err := client.GetKlinePrice("bTCusd", "M1", 60)
+    t.Run("GetCandles", func(t *testing.T) {
+        start := fixtureTime.Add(-61 * time.Minute)
+        candles, err := client.GetCandles(context.Background(), "bTCusd", time.Minute, start, start.Add(2*time.Minute))
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
+        if len(candles) != 1 || !candles[0].Time.Equal(fixtureTime.Add(-time.Hour)) {
+            t.Fatalf("Unexpected candles %v", candles)
+        }
+        assertPrice(t, "Open", candles[0].Open, "45500")
+        assertPrice(t, "High", candles[0].High, "45560")
+        assertPrice(t, "Low", candles[0].Low, "45480")
     })
 
-    t.Run("GetKlinePrice of unknown symbol", func(t *testing.T) {
-        _, err := client.GetKlinePrice("abcedfg", "M1", 60)
+    t.Run("GetCandles of unknown symbol", func(t *testing.T) {
+        start := fixtureTime.Add(-61 * time.Minute)
+        _, err := client.GetCandles(context.Background(), "abcedfg", time.Minute, start, start.Add(2*time.Minute))
 
         if err == nil {
             t.Fatalf("Expecting error when fetching unknown price, but get nil")
         }
-        t.Logf("Returned error is %v, expected?", err)
     })
 
     t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("bTCusd")
+        sp, err := client.GetSymbolPrice(context.Background(), "bTCusd")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
-            t.Fatalf("Get an empty price?")
+        assertPrice(t, "Price", sp.Price, "46000")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, (46000.0-45500)/45500*100)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, (46000.0-45000)/45000*100)
+        assertStatistics(t, sp, "1234.5678", "47100", "44800", "45999", "46001")
+        if sp.QuoteCurrency != "" {
+            t.Fatalf("Expecting quote currency %q, got %q", "", sp.QuoteCurrency)
+        }
+        if !sp.UpdateAt.Equal(time.Date(2021, 12, 15, 7, 59, 59, 123e6, time.UTC)) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
         }
     })
 
+    t.Run("ListSymbols", func(t *testing.T) {
+        markets, err := client.ListSymbols(context.Background())
+
+        assertMarkets(t, markets, err, Market{"BTCUSD", "BTC", "USD"}, Market{"ETHBTC", "ETH", "BTC"})
+    })
+
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("ABC123")
+        _, err := client.GetSymbolPrice(context.Background(), "ABC123")
 
         if err == nil {
             t.Fatalf("Should throws on invalid symbol")
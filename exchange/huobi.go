@@ -1,25 +1,37 @@
 package exchange
 
 import (
+    "bytes"
+    "compress/gzip"
+    "context"
     "encoding/json"
     "errors"
-    "math"
+    "fmt"
+    "io/ioutil"
     "strconv"
     "strings"
     "time"
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
+    "github.com/shopspring/decimal"
     "github.com/sirupsen/logrus"
 )
 
 // https://github.com/huobiapi/API_Docs/wiki/REST_api_reference
 const huobiBaseApi = "https://api.huobipro.com"
 
+// https://huobiapi.github.io/docs/spot/v1/en/#websocket-market-data
+const huobiStreamApi = "wss://api.huobi.pro/ws"
+
 type huobiClient struct {
     *http.Client
-    AccessKey string
-    SecretKey string
+    *marketIndex
+    baseApi    string
+    streamApi  string
+    pastPrices *pastPriceCache
+    AccessKey  string
+    SecretKey  string
 }
 
 type huobiCommonResponse struct {
@@ -36,7 +48,7 @@ type huobiTickerResponse struct {
         Ts   int64
         Data []struct {
             //Id    string
-            Price float64
+            Price decimal.Decimal
             Ts    int64
         }
     }
@@ -49,7 +61,12 @@ func (resp *huobiTickerResponse) getCommonResponse() huobiCommonResponse {
 type huobiKlineResponse struct {
     huobiCommonResponse
     Data []struct {
-        Open interface{}
+        ID     int64           // Open time in seconds
+        Open   decimal.Decimal // Either a number or a string
+        Close  decimal.Decimal
+        Low    decimal.Decimal
+        High   decimal.Decimal
+        Amount decimal.Decimal // In base currency
     }
 }
 
@@ -63,7 +80,13 @@ type huobiCommonResponseProvider interface {
 }
 
 func NewHuobiClient(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient {
-    return &huobiClient{Client: httpClient}
+    client := &huobiClient{Client: httpClient}
+    client.pastPrices = newHourAgoCache()
+    client.baseApi = getBaseApi(queries, client.GetName(), huobiBaseApi)
+    client.streamApi = getStreamApi(queries, client.GetName(), huobiStreamApi)
+    limitRate(httpClient, queries, client.GetName(), client.baseApi, 10, 10) // 10 requests per second for market data
+    client.marketIndex = newMarketIndex(client.GetName(), client.ListSymbols)
+    return client
 }
 
 func (client *huobiClient) GetName() string {
@@ -86,34 +109,54 @@ func (client *huobiClient) decodeResponse(respBytes []byte, respJSON huobiCommon
     return nil
 }
 
-func (client *huobiClient) GetKlinePrice(symbol, period string, size int) (float64, error) {
-    symbol = strings.ToLower(symbol)
-    respByte, err := client.Get(huobiBaseApi+"/market/history/kline", http.WithQuery(map[string]string{
-        "symbol": symbol,
-        "period": period,
+var huobiCandleIntervals = map[time.Duration]string{
+    time.Minute: "1min", 5 * time.Minute: "5min", 15 * time.Minute: "15min", 30 * time.Minute: "30min",
+    time.Hour: "60min", 4 * time.Hour: "4hour", 24 * time.Hour: "1day", 7 * 24 * time.Hour: "1week",
+}
+
+// Huobi returns up to 2000 latest candles
+const huobiMaxCandles = 2000
+
+func (client *huobiClient) CandleIntervals() []time.Duration {
+    return sortedIntervals(huobiCandleIntervals)
+}
+
+// Huobi has no start time, enough latest candles are fetched to reach start
+func (client *huobiClient) GetCandles(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]Candle, error) {
+    size := int(client.Now().Sub(start)/interval) + 1
+    if size > huobiMaxCandles {
+        size = huobiMaxCandles
+    }
+    respByte, err := client.Get(ctx, client.baseApi+"/market/history/kline", http.WithQuery(map[string]string{
+        "symbol": strings.ToLower(symbol),
+        "period": huobiCandleIntervals[interval],
         "size":   strconv.Itoa(size),
     }))
     if err != nil {
-        return 0, err
+        return nil, err
     }
 
     var respJSON huobiKlineResponse
     err = client.decodeResponse(respByte, &respJSON)
     if err != nil {
-        return 0, err
-    }
-    var open float64
-    if r, ok := respJSON.Data[len(respJSON.Data)-1].Open.(float64); ok {
-        open = r
+        return nil, err
     }
-    if r, ok := respJSON.Data[len(respJSON.Data)-1].Open.(string); ok {
-        open, _ = strconv.ParseFloat(r, 64)
+    candles := make([]Candle, 0, len(respJSON.Data))
+    for _, kline := range respJSON.Data {
+        candles = append(candles, Candle{
+            Time:   time.Unix(kline.ID, 0),
+            Open:   kline.Open,
+            High:   kline.High,
+            Low:    kline.Low,
+            Close:  kline.Close,
+            Volume: kline.Amount,
+        })
     }
-    return open, nil
+    return candles, nil
 }
 
-func (client *huobiClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
-    respByte, err := client.Get(huobiBaseApi+"/market/trade", http.WithQuery(map[string]string{"symbol": strings.ToLower(symbol)}))
+func (client *huobiClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
+    respByte, err := client.Get(ctx, client.baseApi+"/market/trade", http.WithQuery(map[string]string{"symbol": strings.ToLower(symbol)}))
     if err != nil {
         return nil, err
     }
@@ -126,31 +169,210 @@ func (client *huobiClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
 
     ticker := respJSON.Tick.Data[len(respJSON.Tick.Data)-1] // Use the last one
 
-    var percentChange1h, percentChange24h = math.MaxFloat64, math.MaxFloat64
-    price1hAgo, err := client.GetKlinePrice(symbol, "1min", 60)
-    if err != nil {
-        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err)
-    } else if price1hAgo != 0 {
-        percentChange1h = (ticker.Price - price1hAgo) / price1hAgo * 100
+    now := client.Now()
+    price1hAgo, err1h := priceAgo(ctx, client, symbol, now, time.Hour)
+    if err1h != nil {
+        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err1h)
     }
 
-    price24hAgo, err := client.GetKlinePrice(symbol, "60min", 24)
-    if err != nil {
-        logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err)
-    } else if price24hAgo != 0 {
-        percentChange24h = (ticker.Price - price24hAgo) / price24hAgo * 100
+    price24hAgo, err24h := priceAgo(ctx, client, symbol, now, 24*time.Hour)
+    if err24h != nil {
+        logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err24h)
     }
 
     return &SymbolPrice{
         Symbol:           symbol,
-        Price:            strconv.FormatFloat(ticker.Price, 'f', -1, 64),
+        Price:            ticker.Price,
         UpdateAt:         time.Unix(ticker.Ts/1000, 0),
         Source:           client.GetName(),
-        PercentChange1h:  percentChange1h,
-        PercentChange24h: percentChange24h,
+        Price1hAgo:       price1hAgo,
+        Price24hAgo:      price24hAgo,
+        PercentChange1h:  changeSince(ticker.Price, price1hAgo, err1h),
+        PercentChange24h: changeSince(ticker.Price, price24hAgo, err24h),
     }, nil
 }
 
+// Tickers of all symbols come in one request, with the open of the last 24 hours. The price 1 hour ago still takes
+// a kline request per symbol, but only once every 15 minutes, see hourAgoCachePeriod
+func (client *huobiClient) GetSymbolPrices(ctx context.Context, symbols []string) ([]*SymbolResult, error) {
+    respByte, err := client.Get(ctx, client.baseApi+"/market/tickers")
+    if err != nil {
+        return nil, err
+    }
+    var respJSON struct {
+        huobiCommonResponse
+        Data []struct {
+            Symbol string
+            Open   decimal.Decimal
+            High   decimal.NullDecimal
+            Low    decimal.NullDecimal
+            Close  decimal.Decimal
+            Amount decimal.NullDecimal // In base currency, while vol is in quote currency
+            Bid    decimal.NullDecimal
+            Ask    decimal.NullDecimal
+        }
+    }
+    if err := json.Unmarshal(respByte, &respJSON); err != nil {
+        return nil, err
+    }
+    if strings.ToLower(respJSON.Status) != "ok" {
+        return nil, fmt.Errorf("huobi get tickers: %s", respJSON.ErrMsg)
+    }
+
+    results := make([]*SymbolResult, len(symbols))
+    for i, symbol := range symbols {
+        // Named as queried, the same as GetSymbolPrice does, tickers are in lower case
+        results[i] = &SymbolResult{Symbol: symbol, Source: client.GetName(), Err: errors.New("invalid symbol")}
+        for _, ticker := range respJSON.Data {
+            if ticker.Symbol != strings.ToLower(symbol) {
+                continue
+            }
+            results[i].Err = nil
+            results[i].Price = &SymbolPrice{
+                Symbol:           symbol,
+                Price:            ticker.Close,
+                UpdateAt:         time.Unix(respJSON.Ts/1000, 0),
+                Source:           client.GetName(),
+                Price24hAgo:      ticker.Open,
+                PercentChange24h: percentChange(ticker.Close, ticker.Open),
+                Volume24h:        ticker.Amount,
+                High24h:          ticker.High,
+                Low24h:           ticker.Low,
+                Bid:              ticker.Bid,
+                Ask:              ticker.Ask,
+            }
+            break
+        }
+    }
+
+    forEachConcurrently(len(results), func(i int) {
+        sp := results[i].Price
+        if sp == nil {
+            return
+        }
+        now := client.Now()
+        price1hAgo, err1h := client.pastPrices.get(sp.Symbol, now.Add(-time.Hour), func() (decimal.Decimal, error) {
+            return priceAgo(ctx, client, sp.Symbol, now, time.Hour)
+        })
+        if err1h != nil {
+            logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err1h)
+        }
+        sp.Price1hAgo = price1hAgo
+        sp.PercentChange1h = changeSince(sp.Price, price1hAgo, err1h)
+    })
+    return results, nil
+}
+
+// Messages are gzipped, and the server pings us in them, the connection is closed if we don't pong back
+func (client *huobiClient) StreamSymbolPrices(ctx context.Context, symbols []string, out chan<- *SymbolPrice) error {
+    conn, err := client.DialWebsocket(ctx, client.streamApi)
+    if err != nil {
+        return err
+    }
+    queried := make(map[string]string, len(symbols)) // Channel -> symbol
+    for _, symbol := range symbols {
+        channel := "market." + strings.ToLower(symbol) + ".ticker"
+        queried[channel] = symbol
+        if err := conn.WriteJSON(map[string]string{"sub": channel, "id": symbol}); err != nil {
+            return err
+        }
+    }
+
+    for {
+        _, message, err := conn.ReadMessage()
+        if err != nil {
+            return err
+        }
+        gzipReader, err := gzip.NewReader(bytes.NewReader(message))
+        if err != nil {
+            return err
+        }
+        message, err = ioutil.ReadAll(gzipReader)
+        if err != nil {
+            return err
+        }
+
+        var respJSON struct {
+            huobiCommonResponse
+            Ping int64
+            Ch   string
+            Tick struct {
+                Open   decimal.Decimal
+                High   decimal.NullDecimal
+                Low    decimal.NullDecimal
+                Close  decimal.Decimal
+                Amount decimal.NullDecimal // In base currency, while vol is in quote currency
+                Bid    decimal.NullDecimal
+                Ask    decimal.NullDecimal
+            }
+        }
+        if err := json.Unmarshal(message, &respJSON); err != nil {
+            return err
+        }
+        if respJSON.Ping != 0 {
+            if err := conn.WriteJSON(map[string]int64{"pong": respJSON.Ping}); err != nil {
+                return err
+            }
+            continue
+        }
+        if strings.ToLower(respJSON.Status) == "error" {
+            return fmt.Errorf("huobi subscribe: %s", respJSON.ErrMsg)
+        }
+        symbol, ok := queried[respJSON.Ch]
+        if !ok {
+            continue // eg. subscription responses
+        }
+        ticker := respJSON.Tick
+        err = sendPrice(ctx, out, &SymbolPrice{
+            Symbol:           strings.ToLower(symbol),
+            Price:            ticker.Close,
+            UpdateAt:         time.Unix(0, respJSON.Ts*int64(time.Millisecond)),
+            Source:           client.GetName(),
+            Price24hAgo:      ticker.Open,
+            PercentChange1h:  UnknownChange(ReasonNotSupported),
+            PercentChange24h: percentChange(ticker.Close, ticker.Open),
+            Volume24h:        ticker.Amount,
+            High24h:          ticker.High,
+            Low24h:           ticker.Low,
+            Bid:              ticker.Bid,
+            Ask:              ticker.Ask,
+        })
+        if err != nil {
+            return err
+        }
+    }
+}
+
+func (client *huobiClient) ListSymbols(ctx context.Context) ([]Market, error) {
+    respByte, err := client.Get(ctx, client.baseApi+"/v1/common/symbols")
+    if err != nil {
+        return nil, err
+    }
+    var respJSON struct {
+        huobiCommonResponse
+        Data []struct {
+            Symbol        string
+            BaseCurrency  string `json:"base-currency"`
+            QuoteCurrency string `json:"quote-currency"`
+            State         string
+        }
+    }
+    if err := json.Unmarshal(respByte, &respJSON); err != nil {
+        return nil, err
+    }
+    if strings.ToLower(respJSON.Status) != "ok" {
+        return nil, fmt.Errorf("huobi get symbols: %s", respJSON.ErrMsg)
+    }
+    markets := make([]Market, 0, len(respJSON.Data))
+    for _, s := range respJSON.Data {
+        if s.State != "online" {
+            continue
+        }
+        markets = append(markets, Market{Symbol: s.Symbol, Base: strings.ToUpper(s.BaseCurrency), Quote: strings.ToUpper(s.QuoteCurrency)})
+    }
+    return markets, nil
+}
+
 func init() {
     Register(NewHuobiClient)
 }
@@ -1,45 +1,141 @@
 package exchange
 
 import (
+    "bytes"
+    "compress/gzip"
+    "context"
     "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
 )
 
 func TestHuobiClient(t *testing.T) {
 
-    var client = registry.getClient("huobi").(*huobiClient)
+    var client = newFixtureClient(t, "huobi", map[string]fixture{
+        "/market/trade?symbol=btcusdt":                              {file: "trade.json"},
+        "/market/trade?symbol=abc123":                               {file: "invalid_symbol.json"},
+        "/market/history/kline?symbol=btcusdt&period=1min&size=62":  {file: "kline_1min.json"},
+        "/market/history/kline?symbol=btcusdt&period=30min&size=50": {file: "kline_30min.json"},
+        "/market/history/kline?symbol=abcedfg":                      {file: "invalid_symbol.json"},
+        "/market/tickers":                                           {file: "tickers.json"},
+        "/v1/common/symbols":                                        {file: "symbols.json"},
+    }).(*huobiClient)
 
-    t.Run("GetKlinePrice", func(t *testing.T) {
-        _, err := client.GetKlinePrice("bTCusdt", "1min", 60)
+    t.Run("GetCandles", func(t *testing.T) {
+        candles, err := client.GetCandles(context.Background(), "bTCusdt", time.Minute, fixtureTime.Add(-61*time.Minute), fixtureTime)
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
+        if len(candles) != 2 || !candles[1].Time.Equal(fixtureTime.Add(-time.Hour)) {
+            t.Fatalf("Unexpected candles %v", candles)
+        }
+        assertPrice(t, "Open", candles[1].Open, "45900.5")
+        assertPrice(t, "Close", candles[1].Close, "45910")
+        assertPrice(t, "Volume", candles[1].Volume, "2.3")
     })
 
-    t.Run("GetKlinePrice of unknown symbol", func(t *testing.T) {
-        _, err := client.GetKlinePrice("abcedfg", "1min", 60)
+    t.Run("GetCandles of unknown symbol", func(t *testing.T) {
+        _, err := client.GetCandles(context.Background(), "abcedfg", time.Minute, fixtureTime.Add(-61*time.Minute), fixtureTime)
 
         if err == nil {
             t.Fatalf("Expecting error when fetching unknown price, but get nil")
         }
+        if err.Error() != "invalid symbol" {
+            t.Fatalf("Expecting error message from response, got %v", err)
+        }
     })
 
     t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("bTCusdt")
+        sp, err := client.GetSymbolPrice(context.Background(), "bTCusdt")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
-            t.Fatalf("Get an empty price?")
+        assertPrice(t, "Price", sp.Price, "46000.5")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, (46000.5-45900.5)/45900.5*100)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, (46000.5-48100)/48100*100)
+        assertStatistics(t, sp, "", "", "", "", "")
+        if sp.QuoteCurrency != "" {
+            t.Fatalf("Expecting quote currency %q, got %q", "", sp.QuoteCurrency)
         }
+        if !sp.UpdateAt.Equal(time.Unix(1639555199, 0)) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
+        }
+    })
+
+    t.Run("ListSymbols", func(t *testing.T) {
+        markets, err := client.ListSymbols(context.Background())
+
+        assertMarkets(t, markets, err, Market{"btcusdt", "BTC", "USDT"}, Market{"ethbtc", "ETH", "BTC"})
     })
 
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("ABC123")
+        _, err := client.GetSymbolPrice(context.Background(), "ABC123")
 
         if err == nil {
             t.Fatalf("Should throws on invalid symbol")
         }
     })
+
+    t.Run("GetSymbolPrices", func(t *testing.T) {
+        results, err := client.GetSymbolPrices(context.Background(), []string{"BTCUSDT", "abc123"})
+
+        if err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        if len(results) != 2 || results[1].Err == nil {
+            t.Fatalf("Expecting abc123 not found, got %+v", results)
+        }
+        if results[0].Symbol != "BTCUSDT" || results[0].Price.Symbol != "BTCUSDT" || results[1].Symbol != "abc123" {
+            t.Fatalf("Expecting symbols named as queried, got %s and %s", results[0].Symbol, results[1].Symbol)
+        }
+        sp := results[0].Price
+        assertPrice(t, "Price", sp.Price, "46000.5")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, (46000.5-45900.5)/45900.5*100)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, (46000.5-47500.5)/47500.5*100)
+        assertStatistics(t, sp, "8765.4321", "47800", "45600.01", "46000.4", "46000.5")
+    })
+
+    t.Run("StreamSymbolPrices", func(t *testing.T) {
+        client := newStreamFixtureClient(t, "huobi", nil, func(conn *websocket.Conn) {
+            writeGzipped := func(message []byte) {
+                var buf bytes.Buffer
+                gzipWriter := gzip.NewWriter(&buf)
+                gzipWriter.Write(message)
+                gzipWriter.Close()
+                conn.WriteMessage(websocket.BinaryMessage, buf.Bytes())
+            }
+            var subscription struct {
+                Sub string
+            }
+            if err := conn.ReadJSON(&subscription); err != nil || subscription.Sub != "market.btcusdt.ticker" {
+                t.Errorf("Expecting a subscription to market.btcusdt.ticker, got %+v, error: %v", subscription, err)
+                return
+            }
+            writeGzipped([]byte(`{"id":"btcusdt","status":"ok","subbed":"market.btcusdt.ticker","ts":1639555199000}`))
+            writeGzipped([]byte(`{"ping":1639555199100}`))
+            var pong struct {
+                Pong int64
+            }
+            if err := conn.ReadJSON(&pong); err != nil || pong.Pong != 1639555199100 {
+                t.Errorf("Expecting a pong, got %+v, error: %v", pong, err)
+                return
+            }
+            writeGzipped(readFixture(t, "huobi", "stream_ticker.json"))
+            conn.ReadMessage() // Hold until the client is gone
+        })
+        sp := collectStreamed(t, client, []string{"BTCUSDT"}, 1)[0]
+
+        if sp.Symbol != "btcusdt" {
+            t.Fatalf("Expecting symbol btcusdt, got %s", sp.Symbol)
+        }
+        assertPrice(t, "Price", sp.Price, "46000.1")
+        assertPrice(t, "Price24hAgo", sp.Price24hAgo, "47500.5")
+        assertStatistics(t, sp, "8765.4321", "47800", "45600.01", "46000.09", "46000.1")
+        if !sp.UpdateAt.Equal(time.Date(2021, 12, 15, 7, 59, 59, 123e6, time.UTC)) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
+        }
+    })
 }
@@ -1,15 +1,17 @@
 package exchange
 
 import (
+    "context"
     "errors"
     "fmt"
-    "math"
+    "sort"
     "strconv"
     "strings"
     "time"
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
+    "github.com/shopspring/decimal"
     "github.com/sirupsen/logrus"
     "github.com/tidwall/gjson"
 )
@@ -17,12 +19,23 @@ import (
 // https://www.kraken.com/help/api
 const krakenBaseApi = "https://api.kraken.com/0/public/"
 
+// https://docs.kraken.com/websockets/
+const krakenStreamApi = "wss://ws.kraken.com"
+
 type krakenClient struct {
     *http.Client
+    *marketIndex
+    baseApi   string
+    streamApi string
 }
 
 func NewKrakenClient(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient {
-    return &krakenClient{Client: httpClient}
+    client := &krakenClient{Client: httpClient}
+    client.baseApi = getBaseApi(queries, client.GetName(), krakenBaseApi)
+    client.streamApi = getStreamApi(queries, client.GetName(), krakenStreamApi)
+    limitRate(httpClient, queries, client.GetName(), client.baseApi, 1, 2) // About 1 request per second for public api
+    client.marketIndex = newMarketIndex(client.GetName(), client.ListSymbols)
+    return client
 }
 
 func (client *krakenClient) GetName() string {
@@ -33,7 +46,7 @@ func (client *krakenClient) GetName() string {
 func (client *krakenClient) extractError(respByte []byte) error {
     errorArray := gjson.GetBytes(respByte, "error").Array()
     if len(errorArray) > 0 {
-        errMsg := errorArray[0].Get("0").String()
+        errMsg := errorArray[0].String()
         if len(errMsg) != 0 {
             return errors.New(errMsg)
         }
@@ -41,35 +54,57 @@ func (client *krakenClient) extractError(respByte []byte) error {
     return nil
 }
 
-func (client *krakenClient) GetKlinePrice(symbol string, since time.Time, interval int) (float64, error) {
+// In minutes
+var krakenCandleIntervals = map[time.Duration]string{
+    time.Minute: "1", 5 * time.Minute: "5", 15 * time.Minute: "15", 30 * time.Minute: "30", time.Hour: "60",
+    4 * time.Hour: "240", 24 * time.Hour: "1440", 7 * 24 * time.Hour: "10080", 15 * 24 * time.Hour: "21600",
+}
+
+func (client *krakenClient) CandleIntervals() []time.Duration {
+    return sortedIntervals(krakenCandleIntervals)
+}
+
+// Kraken has no end time, it returns up to 720 candles since start, those opening after end are dropped here
+func (client *krakenClient) GetCandles(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]Candle, error) {
     symbolUpperCase := strings.ToUpper(symbol)
-    respByte, err := client.Get(krakenBaseApi+"OHLC", http.WithQuery(map[string]string{
+    respByte, err := client.Get(ctx, client.baseApi+"OHLC", http.WithQuery(map[string]string{
         "pair":     symbolUpperCase,
-        "since":    strconv.FormatInt(since.Unix(), 10),
-        "interval": strconv.Itoa(interval),
+        "since":    strconv.FormatInt(start.Unix(), 10),
+        "interval": krakenCandleIntervals[interval],
     }))
     if err := client.extractError(respByte); err != nil {
-        return 0, fmt.Errorf("kraken get kline: %w", err)
+        return nil, fmt.Errorf("kraken get kline: %w", err)
     }
     if err != nil {
-        return 0, err
+        return nil, err
     }
 
     // gjson saved my life, no need to struggle with different/weird response types
-    candleV := gjson.GetBytes(respByte, fmt.Sprintf("result.%s.0", strings.ToUpper(symbol))).Array()
-    if len(candleV) != 8 {
-        return 0, fmt.Errorf("kraken malformed kline response, expecting 8 elements, got %d", len(candleV))
+    var candles []Candle
+    for _, candleV := range gjson.GetBytes(respByte, "result."+symbolUpperCase).Array() {
+        // time, open, high, low, close, vwap, volume, count
+        fields := candleV.Array()
+        if len(fields) != 8 {
+            return nil, fmt.Errorf("kraken malformed kline response, expecting 8 elements, got %d", len(fields))
+        }
+        openAt := time.Unix(fields[0].Int(), 0)
+        if openAt.After(end) {
+            continue
+        }
+        candles = append(candles, Candle{
+            Time:   openAt,
+            Open:   parseDecimal(fields[1].String()),
+            High:   parseDecimal(fields[2].String()),
+            Low:    parseDecimal(fields[3].String()),
+            Close:  parseDecimal(fields[4].String()),
+            Volume: parseDecimal(fields[6].String()),
+        })
     }
-
-    timestamp := candleV[0].Int()
-    openPrice := candleV[1].Float()
-    logrus.Debugf("%s - Kline for %s uses open price at %s", client.GetName(), since.Local(),
-        time.Unix(timestamp, 0).Local())
-    return openPrice, nil
+    return candles, nil
 }
 
-func (client *krakenClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
-    respByte, err := client.Get(krakenBaseApi+"Ticker", http.WithQuery(map[string]string{"pair": strings.ToUpper(symbol)}))
+func (client *krakenClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
+    respByte, err := client.Get(ctx, client.baseApi+"Ticker", http.WithQuery(map[string]string{"pair": strings.ToUpper(symbol)}))
     if err := client.extractError(respByte); err != nil {
         return nil, fmt.Errorf("kraken get ticker: %w", err)
     }
@@ -81,37 +116,171 @@ func (client *krakenClient) GetSymbolPrice(symbol string) (*SymbolPrice, error)
     if !lastPriceV.Exists() {
         return nil, fmt.Errorf("kraken malformed ticker response, missing key %s", fmt.Sprintf("result.%s.c.0", strings.ToUpper(symbol)))
     }
-    lastPrice := lastPriceV.Float()
-
-    time.Sleep(time.Second) // API call rate limit
-    var (
-        now              = time.Now()
-        percentChange1h  = math.MaxFloat64
-        percentChange24h = math.MaxFloat64
-    )
-    price1hAgo, err := client.GetKlinePrice(symbol, now.Add(-61*time.Minute), 1)
+    lastPrice, err := decimal.NewFromString(lastPriceV.String())
     if err != nil {
-        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err)
-    } else if price1hAgo != 0 {
-        percentChange1h = (lastPrice - price1hAgo) / price1hAgo * 100
+        return nil, fmt.Errorf("kraken malformed ticker response: %w", err)
     }
-    price24hAgo, err := client.GetKlinePrice(symbol, now.Add(-24*time.Hour), 5)
-    if err != nil {
-        logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err)
-    } else if price24hAgo != 0 {
-        percentChange24h = (lastPrice - price24hAgo) / price24hAgo * 100
+
+    // Values of today and the last 24 hours, take the latter
+    tickerV := gjson.GetBytes(respByte, fmt.Sprintf("result.%s", strings.ToUpper(symbol)))
+    now := client.Now()
+    price1hAgo, err1h := priceAgo(ctx, client, symbol, now, time.Hour)
+    if err1h != nil {
+        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err1h)
+    }
+    price24hAgo, err24h := priceAgo(ctx, client, symbol, now, 24*time.Hour)
+    if err24h != nil {
+        logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err24h)
     }
 
     return &SymbolPrice{
         Symbol:           symbol,
-        Price:            lastPriceV.String(),
-        UpdateAt:         time.Now(),
+        Price:            lastPrice,
+        UpdateAt:         client.Now(),
         Source:           client.GetName(),
-        PercentChange1h:  percentChange1h,
-        PercentChange24h: percentChange24h,
+        Price1hAgo:       price1hAgo,
+        Price24hAgo:      price24hAgo,
+        PercentChange1h:  changeSince(lastPrice, price1hAgo, err1h),
+        PercentChange24h: changeSince(lastPrice, price24hAgo, err24h),
+        Volume24h:        parseNullDecimal(tickerV.Get("v.1").String()),
+        High24h:          parseNullDecimal(tickerV.Get("h.1").String()),
+        Low24h:           parseNullDecimal(tickerV.Get("l.1").String()),
+        Bid:              parseNullDecimal(tickerV.Get("b.0").String()),
+        Ask:              parseNullDecimal(tickerV.Get("a.0").String()),
+        QuoteCurrency:    client.quoteOf(ctx, symbol),
     }, nil
 }
 
+// The ticker doesn't tell the quote currency, take it from the market list, which is loaded only once
+func (client *krakenClient) quoteOf(ctx context.Context, symbol string) string {
+    pair, err := client.NormalizeSymbol(ctx, symbol)
+    if err != nil {
+        logrus.Debugf("%s - Failed to get quote currency of %s, error: %v", client.GetName(), symbol, err)
+        return ""
+    }
+    _, quote, _ := config.SplitPair(pair)
+    return quote
+}
+
+// Pairs are named differently in streams, eg. XXBTZUSD is XBT/USD, look them up by either name or altname
+func (client *krakenClient) getStreamNames(ctx context.Context, symbols []string) (map[string]string, error) {
+    upperSymbols := make([]string, len(symbols))
+    for i, symbol := range symbols {
+        upperSymbols[i] = strings.ToUpper(symbol)
+    }
+    respByte, err := client.Get(ctx, client.baseApi+"AssetPairs", http.WithQuery(map[string]string{"pair": strings.Join(upperSymbols, ",")}))
+    if err := client.extractError(respByte); err != nil {
+        return nil, fmt.Errorf("kraken get asset pairs: %w", err)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    queried := make(map[string]string, len(symbols)) // Stream name -> symbol
+    for i, upperSymbol := range upperSymbols {
+        gjson.GetBytes(respByte, "result").ForEach(func(name, pair gjson.Result) bool {
+            if name.String() == upperSymbol || pair.Get("altname").String() == upperSymbol {
+                queried[pair.Get("wsname").String()] = symbols[i]
+                return false
+            }
+            return true
+        })
+    }
+    if len(queried) != len(symbols) {
+        return nil, fmt.Errorf("kraken cannot find stream names of all pairs in %s", strings.Join(upperSymbols, ","))
+    }
+    return queried, nil
+}
+
+func (client *krakenClient) StreamSymbolPrices(ctx context.Context, symbols []string, out chan<- *SymbolPrice) error {
+    queried, err := client.getStreamNames(ctx, symbols)
+    if err != nil {
+        return err
+    }
+    conn, err := client.DialWebsocket(ctx, client.streamApi)
+    if err != nil {
+        return err
+    }
+    streamNames := make([]string, 0, len(queried))
+    for streamName := range queried {
+        streamNames = append(streamNames, streamName)
+    }
+    sort.Strings(streamNames)
+    subscription := map[string]interface{}{
+        "event":        "subscribe",
+        "pair":         streamNames,
+        "subscription": map[string]string{"name": "ticker"},
+    }
+    if err := conn.WriteJSON(subscription); err != nil {
+        return err
+    }
+
+    for {
+        _, message, err := conn.ReadMessage()
+        if err != nil {
+            return err
+        }
+        // Events are objects, eg. heartbeats and subscription status, and updates are arrays
+        messageV := gjson.ParseBytes(message)
+        if messageV.IsObject() {
+            if messageV.Get("status").String() == "error" {
+                return fmt.Errorf("kraken subscribe: %s", messageV.Get("errorMessage").String())
+            }
+            continue
+        }
+        // [channelID, ticker, "ticker", pair]
+        symbol, ok := queried[messageV.Get("3").String()]
+        if !ok || messageV.Get("2").String() != "ticker" {
+            logrus.Debugf("%s - Unexpected stream message: %s", client.GetName(), message)
+            continue
+        }
+        // Values of today and the last 24 hours, take the latter
+        tickerV := messageV.Get("1")
+        lastPrice := parseDecimal(tickerV.Get("c.0").String())
+        openPrice := parseDecimal(tickerV.Get("o.1").String())
+        err = sendPrice(ctx, out, &SymbolPrice{
+            Symbol:           symbol,
+            Price:            lastPrice,
+            UpdateAt:         client.Now(),
+            Source:           client.GetName(),
+            Price24hAgo:      openPrice,
+            PercentChange1h:  UnknownChange(ReasonNotSupported),
+            PercentChange24h: percentChange(lastPrice, openPrice),
+            Volume24h:        parseNullDecimal(tickerV.Get("v.1").String()),
+            High24h:          parseNullDecimal(tickerV.Get("h.1").String()),
+            Low24h:           parseNullDecimal(tickerV.Get("l.1").String()),
+            Bid:              parseNullDecimal(tickerV.Get("b.0").String()),
+            Ask:              parseNullDecimal(tickerV.Get("a.0").String()),
+        })
+        if err != nil {
+            return err
+        }
+    }
+}
+
+// Pairs are listed by their names, eg. XXBTZUSD, base and quote are taken from wsname, as base and quote are named like XXBT
+func (client *krakenClient) ListSymbols(ctx context.Context) ([]Market, error) {
+    respByte, err := client.Get(ctx, client.baseApi+"AssetPairs")
+    if err := client.extractError(respByte); err != nil {
+        return nil, fmt.Errorf("kraken get asset pairs: %w", err)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    var markets []Market
+    gjson.GetBytes(respByte, "result").ForEach(func(name, pair gjson.Result) bool {
+        // Dark pool pairs, eg. XXBTZUSD.d, have no wsname and no ticker
+        if wsName := pair.Get("wsname").String(); wsName != "" {
+            m := marketOf(wsName, "/", false)
+            m.Symbol = name.String()
+            markets = append(markets, unaliasMarket(client.GetName(), m))
+        }
+        return true
+    })
+    return markets, nil
+}
+
 func init() {
     Register(NewKrakenClient)
 }
@@ -1,47 +1,135 @@
 package exchange
 
 import (
+    "context"
+    "strings"
     "testing"
     "time"
+
+    "github.com/gorilla/websocket"
 )
 
 func TestKrakenClient(t *testing.T) {
 
-    var client = registry.getClient("kraken").(*krakenClient)
+    var client = newFixtureClient(t, "kraken", map[string]fixture{
+        "/0/public/Ticker?pair=XXBTZUSD":                            {file: "ticker.json"},
+        "/0/public/Ticker?pair=ABC123":                              {file: "unknown_pair.json"},
+        "/0/public/OHLC?pair=XXBTZUSD&since=1639551540&interval=1":  {file: "ohlc_1h.json"},
+        "/0/public/OHLC?pair=XXBTZUSD&since=1639467000&interval=30": {file: "ohlc_24h.json"},
+        "/0/public/OHLC?pair=FASFAS":                                {file: "unknown_pair.json"},
+        "/0/public/AssetPairs":                                      {file: "asset_pairs_all.json"},
+    }).(*krakenClient)
 
-    t.Run("GetKlinePrice", func(t *testing.T) {
-        _, err := client.GetKlinePrice("EOSETh", time.Now().Add(-61*time.Minute), 1)
+    t.Run("GetCandles", func(t *testing.T) {
+        start := fixtureTime.Add(-61 * time.Minute)
+        candles, err := client.GetCandles(context.Background(), "XXBTZUSD", time.Minute, start, start.Add(2*time.Minute))
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
+        if len(candles) != 2 || !candles[0].Time.Equal(time.Unix(1639551600, 0)) {
+            t.Fatalf("Unexpected candles %v", candles)
+        }
+        assertPrice(t, "Open", candles[0].Open, "45100")
+        assertPrice(t, "Close", candles[0].Close, "45120")
+        assertPrice(t, "Volume", candles[0].Volume, "1.23456789")
+
+        candles, err = client.GetCandles(context.Background(), "XXBTZUSD", time.Minute, start, start.Add(time.Minute))
+        if err != nil || len(candles) != 1 || !candles[0].Time.Equal(time.Unix(1639551600, 0)) {
+            t.Fatalf("Expecting candles trimmed to end, got %v, error: %v", candles, err)
+        }
     })
 
-    t.Run("GetKlinePrice of unknown symbol", func(t *testing.T) {
-        _, err := client.GetKlinePrice("fasfas", time.Now().Add(-61*time.Minute), 1)
+    t.Run("GetCandles of unknown symbol", func(t *testing.T) {
+        start := fixtureTime.Add(-61 * time.Minute)
+        _, err := client.GetCandles(context.Background(), "fasfas", time.Minute, start, start.Add(2*time.Minute))
 
         if err == nil {
             t.Fatalf("Expecting error when fetching unknown price, but get nil")
         }
-        t.Logf("Returned error is `%v`, expected?", err)
+        if !strings.Contains(err.Error(), "EQuery:Unknown asset pair") {
+            t.Fatalf("Expecting error message from response, got %v", err)
+        }
     })
 
     t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("EOSETh")
+        sp, err := client.GetSymbolPrice(context.Background(), "XXBTZUSD")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
-            t.Fatalf("Get an empty price?")
+        assertPrice(t, "Price", sp.Price, "46000.50000")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, (46000.5-45100)/45100*100)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, (46000.5-48000)/48000*100)
+        assertStatistics(t, sp, "2345.67890123", "48500", "45000", "46000", "46001")
+        if sp.QuoteCurrency != "USD" {
+            t.Fatalf("Expecting quote currency %q, got %q", "USD", sp.QuoteCurrency)
+        }
+        if !sp.UpdateAt.Equal(fixtureTime) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
         }
     })
 
+    t.Run("ListSymbols", func(t *testing.T) {
+        markets, err := client.ListSymbols(context.Background())
+
+        assertMarkets(t, markets, err, Market{"XETHXXBT", "ETH", "BTC"}, Market{"XXBTZUSD", "BTC", "USD"})
+    })
+
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("ABC123")
+        _, err := client.GetSymbolPrice(context.Background(), "ABC123")
 
         if err == nil {
             t.Fatalf("Should throws on invalid symbol")
         }
+        if !strings.Contains(err.Error(), "EQuery:Unknown asset pair") {
+            t.Fatalf("Expecting error message from response, got %v", err)
+        }
     })
+
+    t.Run("StreamSymbolPrices", func(t *testing.T) {
+        client := newStreamFixtureClient(t, "kraken", map[string]fixture{
+            "/0/public/AssetPairs?pair=XXBTZUSD": {file: "asset_pairs.json"},
+        }, func(conn *websocket.Conn) {
+            var subscription struct {
+                Pair []string
+            }
+            if err := conn.ReadJSON(&subscription); err != nil || len(subscription.Pair) != 1 || subscription.Pair[0] != "XBT/USD" {
+                t.Errorf("Expecting a subscription to XBT/USD, got %+v, error: %v", subscription, err)
+                return
+            }
+            conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"heartbeat"}`))
+            conn.WriteMessage(websocket.TextMessage, readFixture(t, "kraken", "stream_ticker.json"))
+            conn.ReadMessage() // Hold until the client is gone
+        })
+        sp := collectStreamed(t, client, []string{"XXBTZUSD"}, 1)[0]
+
+        if sp.Symbol != "XXBTZUSD" {
+            t.Fatalf("Expecting symbol as subscribed, got %s", sp.Symbol)
+        }
+        assertPrice(t, "Price", sp.Price, "46000.5")
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, 0.001086956522)
+        assertStatistics(t, sp, "2345.67890123", "48500", "45000", "46000", "46001")
+    })
+}
+
+func TestKrakenClient_klinesFailed(t *testing.T) {
+    client := newFixtureClient(t, "kraken", map[string]fixture{
+        "/0/public/Ticker?pair=XXBTZUSD": {file: "ticker.json"},
+        "/0/public/OHLC":                 {file: "unknown_pair.json", status: 500},
+        "/0/public/AssetPairs":           {file: "unknown_pair.json", status: 500},
+    })
+    sp, err := client.GetSymbolPrice(context.Background(), "XXBTZUSD")
+
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    for name, change := range map[string]Change{"PercentChange1h": sp.PercentChange1h, "PercentChange24h": sp.PercentChange24h} {
+        if change.Known || change.Reason != ReasonFetchFailed {
+            t.Fatalf("Expecting %s unknown as %s, got %+v", name, ReasonFetchFailed, change)
+        }
+    }
+    if sp.QuoteCurrency != "" {
+        t.Fatalf("Expecting quote currency unknown without markets, got %q", sp.QuoteCurrency)
+    }
 }
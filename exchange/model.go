@@ -1,14 +1,154 @@
 package exchange
 
 import (
+    "errors"
+    "strconv"
+    "strings"
     "time"
+
+    "github.com/shopspring/decimal"
+)
+
+// Reason tells why a value is unknown
+type Reason string
+
+const (
+    ReasonNotSupported Reason = "not supported"
+    ReasonFetchFailed  Reason = "fetch failed"
+    ReasonNoPriceAgo   Reason = "no price ago" // Exchanges have no trade or candle back then
 )
 
+// Change is a percentage change which may be unknown, check Known before using Percent
+type Change struct {
+    Percent float64
+    Known   bool
+    Reason  Reason // Why it's unknown
+}
+
+func KnownChange(percent float64) Change {
+    return Change{Percent: percent, Known: true}
+}
+
+func UnknownChange(reason Reason) Change {
+    return Change{Reason: reason}
+}
+
+// String returns the percentage with 2 decimals, or n/a if unknown
+func (c Change) String() string {
+    if !c.Known {
+        return "n/a"
+    }
+    return strconv.FormatFloat(c.Percent, 'f', 2, 64)
+}
+
 type SymbolPrice struct {
-    Symbol           string
-    Price            string
-    Source           string
-    UpdateAt         time.Time
-    PercentChange1h  float64
-    PercentChange24h float64
+    Symbol   string
+    Price    decimal.Decimal
+    Source   string
+    UpdateAt time.Time
+    // Prices 1 hour and 24 hours ago, zero if unknown, some exchanges only tell percentage changes
+    Price1hAgo       decimal.Decimal
+    Price24hAgo      decimal.Decimal
+    PercentChange1h  Change
+    PercentChange24h Change
+    // Prices and percentage changes of other windows shown, keyed by window, see PercentChange
+    PricesAgo      map[time.Duration]decimal.Decimal
+    PercentChanges map[time.Duration]Change
+    // 24 hours statistics and the top of order book, not Valid if unknown
+    Volume24h decimal.NullDecimal // In base currency
+    High24h   decimal.NullDecimal
+    Low24h    decimal.NullDecimal
+    Bid       decimal.NullDecimal
+    Ask       decimal.NullDecimal
+    // The currency price is quoted in, eg. USDT of BTC-USDT, empty if unknown
+    QuoteCurrency string
+}
+
+// PercentChange returns the percentage change over the window, it's unknown if the window is not fetched
+func (sp *SymbolPrice) PercentChange(window time.Duration) Change {
+    switch window {
+    case time.Hour:
+        return sp.PercentChange1h
+    case 24 * time.Hour:
+        return sp.PercentChange24h
+    }
+    if change, ok := sp.PercentChanges[window]; ok {
+        return change
+    }
+    return UnknownChange(ReasonNotSupported)
+}
+
+// Set the price window ago, zero if only the percentage change is known
+func (sp *SymbolPrice) setChange(window time.Duration, ago decimal.Decimal, change Change) {
+    if sp.PricesAgo == nil {
+        sp.PricesAgo = make(map[time.Duration]decimal.Decimal)
+        sp.PercentChanges = make(map[time.Duration]Change)
+    }
+    sp.PricesAgo[window] = ago
+    sp.PercentChanges[window] = change
+}
+
+// ErrStale is the error of symbols not fetched before the deadline of a refresh
+var ErrStale = errors.New("stale, no response in time")
+
+// SymbolResult is the outcome of querying a symbol, either Price or Err is set
+type SymbolResult struct {
+    Symbol string
+    Source string
+    Price  *SymbolPrice
+    Err    error
+    // Price is the last known one, kept after later queries failed, and it's getting too old
+    Stale bool
+    // Messages of alert rules fired on the price and not re-armed yet
+    Alerts []string
+    // Consecutive failed queries of the symbol up to this one, counted when refreshing
+    Failures int
+}
+
+// Parse optional fields of a response, a malformed one is just unknown
+func parseDecimal(s string) decimal.Decimal {
+    d, err := decimal.NewFromString(s)
+    if err != nil {
+        return decimal.Zero
+    }
+    return d
+}
+
+// Same as parseDecimal, but missing or malformed values are unknown instead of zero
+func parseNullDecimal(s string) decimal.NullDecimal {
+    d, err := decimal.NewFromString(s)
+    if err != nil {
+        return decimal.NullDecimal{}
+    }
+    return decimal.NewNullDecimal(d)
+}
+
+// Get quote currency from symbols like BTC-USDT, some exchanges put quote currency first, eg. USDT_BTC on Poloniex
+func quoteCurrency(symbol, sep string, quoteFirst bool) string {
+    parts := strings.Split(strings.ToUpper(symbol), sep)
+    if len(parts) != 2 {
+        return ""
+    }
+    if quoteFirst {
+        return parts[0]
+    }
+    return parts[1]
+}
+
+// Calculate percentage change from price ago to current price, it's unknown if price ago is unknown.
+// It's done in decimals, only the result is converted to float, so tiny prices won't lose precision.
+// Same as percentChange, but the change is unknown as fetch failed if getting the price ago failed
+func changeSince(current, ago decimal.Decimal, err error) Change {
+    if err != nil {
+        return UnknownChange(ReasonFetchFailed)
+    }
+    return percentChange(current, ago)
+}
+
+func percentChange(current, ago decimal.Decimal) Change {
+    if ago.IsZero() {
+        return UnknownChange(ReasonNoPriceAgo)
+    }
+    pct, _ := current.Sub(ago).Div(ago).Shift(2).Float64()
+    return KnownChange(pct)
 }
@@ -0,0 +1,29 @@
+package exchange
+
+import (
+    "testing"
+
+    "github.com/shopspring/decimal"
+)
+
+func TestPercentChange(t *testing.T) {
+    t.Run("tiny prices", func(t *testing.T) {
+        // 0.1 + 0.2 != 0.3 in floats, tokens priced like this would show a made-up change
+        current := decimal.RequireFromString("0.00000003")
+        ago := decimal.RequireFromString("0.00000001").Add(decimal.RequireFromString("0.00000002"))
+        if change := percentChange(current, ago); !change.Known || change.Percent != 0 {
+            t.Fatalf("Expecting no change, got %+v", change)
+        }
+        assertPercentChange(t, "percentChange", percentChange(decimal.RequireFromString("0.00000123"), decimal.RequireFromString("0.00000100")), 23)
+    })
+
+    t.Run("unknown price ago", func(t *testing.T) {
+        change := percentChange(decimal.RequireFromString("1.5"), decimal.Zero)
+        if change.Known || change.Reason != ReasonNoPriceAgo {
+            t.Fatalf("Expecting unknown change, got %+v", change)
+        }
+        if change.String() != "n/a" {
+            t.Fatalf("Expecting n/a, got %s", change)
+        }
+    })
+}
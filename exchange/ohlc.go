@@ -0,0 +1,115 @@
+package exchange
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "time"
+)
+
+// Candles are fetched in pages of this many, within limits of all exchanges, eg. Bitfinex returns 120 by default
+const candlesPerRequest = 100
+
+// Choose the interval of candles fetched from an exchange for candles of the given interval, which is the interval
+// itself if supported, or the coarsest one dividing it, eg. 1h candles are resampled into 3h ones
+func nativeIntervalFor(intervals []time.Duration, interval time.Duration) (time.Duration, bool) {
+    for i := len(intervals) - 1; i >= 0; i-- {
+        if intervals[i] <= interval && interval%intervals[i] == 0 {
+            return intervals[i], true
+        }
+    }
+    return 0, false
+}
+
+// Resample candles into coarser ones of the interval, opening at multiples of it in UTC,
+// weeks start on Mondays as the zero time does
+func resample(candles []Candle, interval time.Duration) []Candle {
+    var resampled []Candle
+    for _, c := range sortedCandles(candles) {
+        openAt := c.Time.Truncate(interval)
+        if n := len(resampled); n > 0 && resampled[n-1].Time.Equal(openAt) {
+            last := &resampled[n-1]
+            if c.High.GreaterThan(last.High) {
+                last.High = c.High
+            }
+            if c.Low.LessThan(last.Low) {
+                last.Low = c.Low
+            }
+            last.Close = c.Close
+            last.Volume = last.Volume.Add(c.Volume)
+            continue
+        }
+        c.Time = openAt
+        resampled = append(resampled, c)
+    }
+    return resampled
+}
+
+// Sort a copy of candles by time, dropping duplicates from overlapping pages
+func sortedCandles(candles []Candle) []Candle {
+    sorted := make([]Candle, len(candles))
+    copy(sorted, candles)
+    sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+    deduped := sorted[:0]
+    for _, c := range sorted {
+        if n := len(deduped); n > 0 && deduped[n-1].Time.Equal(c.Time) {
+            continue
+        }
+        deduped = append(deduped, c)
+    }
+    return deduped
+}
+
+// GetCandles returns the last n candles of the interval up to now, oldest first, the last one may be still open.
+// Intervals not supported by the exchange are resampled from finer ones.
+func (r *Registry) GetCandles(ctx context.Context, exchangeName, symbol string, interval time.Duration, n int, now time.Time) ([]Candle, error) {
+    client := r.getClient(exchangeName)
+    if client == nil {
+        return nil, fmt.Errorf("unknown exchange %s", exchangeName)
+    }
+    provider, ok := client.(CandleProvider)
+    if !ok {
+        return nil, fmt.Errorf("%s doesn't provide klines", client.GetName())
+    }
+    symbol, err := r.nativeSymbol(ctx, client, symbol)
+    if err != nil {
+        return nil, err
+    }
+    return lastCandles(ctx, provider, symbol, interval, n, now)
+}
+
+// Page through candles of the provider, resampling them if the interval is not supported
+func lastCandles(ctx context.Context, provider CandleProvider, symbol string, interval time.Duration, n int, now time.Time) ([]Candle, error) {
+    native, ok := nativeIntervalFor(provider.CandleIntervals(), interval)
+    if !ok {
+        return nil, fmt.Errorf("%s cannot make %s candles, supported intervals are %v", provider.GetName(), interval, provider.CandleIntervals())
+    }
+
+    start := now.Truncate(interval).Add(-time.Duration(n-1) * interval)
+    var candles []Candle
+    for pageStart := start; !pageStart.After(now); pageStart = pageStart.Add(candlesPerRequest * native) {
+        pageEnd := pageStart.Add(candlesPerRequest*native - time.Second)
+        if pageEnd.After(now) {
+            pageEnd = now
+        }
+        page, err := provider.GetCandles(ctx, symbol, native, pageStart, pageEnd)
+        if err != nil {
+            return nil, err
+        }
+        // Some exchanges return more than asked, eg. the latest ones regardless of start
+        for _, c := range page {
+            if !c.Time.Before(start) && !c.Time.After(now) {
+                candles = append(candles, c)
+            }
+        }
+    }
+    if native != interval {
+        candles = resample(candles, interval)
+    } else {
+        candles = sortedCandles(candles)
+    }
+    if len(candles) > n {
+        candles = candles[len(candles)-n:]
+    }
+    return candles, nil
+}
@@ -0,0 +1,101 @@
+package exchange
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/shopspring/decimal"
+)
+
+func TestNativeIntervalFor(t *testing.T) {
+    intervals := []time.Duration{time.Minute, 5 * time.Minute, time.Hour, 4 * time.Hour, 24 * time.Hour}
+    for _, tc := range []struct {
+        interval time.Duration
+        want     time.Duration // 0 if not possible
+    }{
+        {time.Hour, time.Hour},
+        {15 * time.Minute, 5 * time.Minute},
+        {3 * time.Hour, time.Hour},
+        {8 * time.Hour, 4 * time.Hour},
+        {7 * 24 * time.Hour, 24 * time.Hour},
+        {90 * time.Second, 0},
+    } {
+        native, ok := nativeIntervalFor(intervals, tc.interval)
+        if ok != (tc.want != 0) || native != tc.want {
+            t.Fatalf("Expecting %s candles for %s, got %s", tc.want, tc.interval, native)
+        }
+    }
+}
+
+func TestResample(t *testing.T) {
+    start := fixtureTime.Truncate(4 * time.Hour)
+    candle := func(hours int, open, high, low, close int64) Candle {
+        return Candle{Time: start.Add(time.Duration(hours) * time.Hour), Open: decimal.NewFromInt(open), High: decimal.NewFromInt(high),
+            Low: decimal.NewFromInt(low), Close: decimal.NewFromInt(close), Volume: decimal.NewFromInt(1)}
+    }
+    // Out of order with a duplicate and a missing hour, as pages of exchanges may overlap or skip
+    candles := []Candle{candle(1, 11, 15, 9, 12), candle(0, 10, 12, 8, 11), candle(3, 13, 13, 10, 12), candle(1, 11, 15, 9, 12), candle(4, 12, 14, 12, 14)}
+
+    resampled := resample(candles, 4*time.Hour)
+    if len(resampled) != 2 {
+        t.Fatalf("Expecting 2 candles, got %+v", resampled)
+    }
+    first := resampled[0]
+    if !first.Time.Equal(start) {
+        t.Fatalf("Expecting the first candle at %v, got %v", start, first.Time)
+    }
+    assertPrice(t, "open", first.Open, "10")
+    assertPrice(t, "high", first.High, "15")
+    assertPrice(t, "low", first.Low, "8")
+    assertPrice(t, "close", first.Close, "12")
+    assertPrice(t, "volume", first.Volume, "3")
+    assertPrice(t, "open of the second", resampled[1].Open, "12")
+    if candles[0].Time != start.Add(time.Hour) {
+        t.Fatalf("Candles of the caller should not be changed")
+    }
+
+    // Weekly candles start on Mondays
+    if week := resample(candles, 7*24*time.Hour); len(week) != 1 || week[0].Time.Weekday() != time.Monday {
+        t.Fatalf("Expecting a weekly candle starting on Monday, got %+v", week)
+    }
+}
+
+func TestLastCandles(t *testing.T) {
+    now := fixtureTime.Add(30 * time.Minute)
+    provider := &fakeCandleProvider{ExchangeClient: registry.getClient("binance"), intervals: []time.Duration{time.Minute, time.Hour}}
+
+    t.Run("paged", func(t *testing.T) {
+        provider.requested = nil
+        candles, err := lastCandles(context.Background(), provider, "BTCUSDT", time.Hour, 250, now)
+        if err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        if len(provider.requested) != 3 {
+            t.Fatalf("Expecting 3 pages of 1h candles, requested %v", provider.requested)
+        }
+        if len(candles) != 250 || !candles[249].Time.Equal(fixtureTime) || !candles[0].Time.Equal(fixtureTime.Add(-249*time.Hour)) {
+            t.Fatalf("Expecting 250 candles up to the open one, got %d from %v to %v", len(candles), candles[0].Time, candles[len(candles)-1].Time)
+        }
+    })
+
+    t.Run("resampled", func(t *testing.T) {
+        candles, err := lastCandles(context.Background(), provider, "BTCUSDT", 3*time.Hour, 5, now)
+        if err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        if len(candles) != 5 {
+            t.Fatalf("Expecting 5 candles, got %+v", candles)
+        }
+        last := candles[4]
+        if !last.Time.Equal(fixtureTime.Truncate(3 * time.Hour)) {
+            t.Fatalf("Expecting the last candle at %v, got %v", fixtureTime.Truncate(3*time.Hour), last.Time)
+        }
+        assertPrice(t, "open", last.Open, decimal.NewFromInt(last.Time.Unix()).String())
+        assertPrice(t, "close of the open candle", last.Close, decimal.NewFromInt(fixtureTime.Unix()+1).String())
+    })
+
+    if _, err := lastCandles(context.Background(), provider, "BTCUSDT", 30*time.Second, 5, now); err == nil {
+        t.Fatalf("Expecting error of unsupported intervals")
+    }
+}
@@ -1,47 +1,126 @@
 package exchange
 
 import (
+    "context"
+    "strings"
     "testing"
     "time"
+
+    "github.com/gorilla/websocket"
 )
 
 func TestOKExClient(t *testing.T) {
 
-    var client = registry.getClient("okex").(*okexClient)
+    var client = newFixtureClient(t, "okex", map[string]fixture{
+        "/api/spot/v3/instruments/BTC-USDT/ticker":                   {file: "ticker.json"},
+        "/api/spot/v3/instruments/ABC123/ticker":                     {file: "invalid_instrument.json", status: 400},
+        "/api/spot/v3/instruments/BTC-USDT/candles?granularity=60":   {file: "candles_60.json"},
+        "/api/spot/v3/instruments/BTC-USDT/candles?granularity=1800": {file: "candles_1800.json"},
+        "/api/spot/v3/instruments/abcedfg/candles":                   {file: "invalid_instrument.json", status: 400},
+        "/api/spot/v3/instruments/ticker":                            {file: "tickers.json"},
+    }).(*okexClient)
 
-    t.Run("GetKlinePrice", func(t *testing.T) {
-        _, err := client.GetKlinePrice("bTC_usdt", "60", time.Now().Add(-time.Hour), time.Now())
+    t.Run("GetCandles", func(t *testing.T) {
+        candles, err := client.GetCandles(context.Background(), "BTC-USDT", time.Minute, fixtureTime.Add(-time.Hour), fixtureTime)
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
+        if len(candles) != 2 || !candles[1].Time.Equal(fixtureTime.Add(-time.Hour)) {
+            t.Fatalf("Unexpected candles %v", candles)
+        }
+        assertPrice(t, "Open", candles[1].Open, "45600.5")
+        assertPrice(t, "Close", candles[1].Close, "45610")
+        assertPrice(t, "Volume", candles[1].Volume, "15.6")
     })
 
-    t.Run("GetKlinePrice of unknown symbol", func(t *testing.T) {
-        _, err := client.GetKlinePrice("abcedfg", "60", time.Now().Add(-time.Hour), time.Now())
+    t.Run("GetCandles of unknown symbol", func(t *testing.T) {
+        _, err := client.GetCandles(context.Background(), "abcedfg", time.Minute, fixtureTime.Add(-time.Hour), fixtureTime)
 
         if err == nil {
             t.Fatalf("Expecting error when fetching unknown price, but get nil")
         }
-        t.Logf("Returned error is %v, expected?", err)
+        if !strings.Contains(err.Error(), "Invalid instrument_id") {
+            t.Fatalf("Expecting error message from response, got %v", err)
+        }
     })
 
     t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("bTC_usdt")
+        sp, err := client.GetSymbolPrice(context.Background(), "BTC-USDT")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
-            t.Fatalf("Get an empty price?")
+        assertPrice(t, "Price", sp.Price, "46000.1")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, (46000.1-45600.5)/45600.5*100)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, (46000.1-48200)/48200*100)
+        assertStatistics(t, sp, "8765.4321", "47800", "45600", "46000.1", "46000.2")
+        if sp.QuoteCurrency != "USDT" {
+            t.Fatalf("Expecting quote currency %q, got %q", "USDT", sp.QuoteCurrency)
         }
+        if !sp.UpdateAt.Equal(time.Date(2021, 12, 15, 7, 59, 59, 123e6, time.UTC)) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
+        }
+    })
+
+    t.Run("ListSymbols", func(t *testing.T) {
+        markets, err := client.ListSymbols(context.Background())
+
+        assertMarkets(t, markets, err, Market{"BTC-USDT", "BTC", "USDT"}, Market{"OKB-USDT", "OKB", "USDT"})
     })
 
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("ABC123")
+        _, err := client.GetSymbolPrice(context.Background(), "ABC123")
 
         if err == nil {
             t.Fatalf("Should throws on invalid symbol")
         }
     })
+
+    t.Run("GetSymbolPrices", func(t *testing.T) {
+        results, err := client.GetSymbolPrices(context.Background(), []string{"BTC-USDT", "ABC123"})
+
+        if err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        if len(results) != 2 || results[1].Err == nil {
+            t.Fatalf("Expecting ABC123 not found, got %+v", results)
+        }
+        sp := results[0].Price
+        assertPrice(t, "Price", sp.Price, "46000.1")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, (46000.1-45600.5)/45600.5*100)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, (46000.1-47500)/47500*100)
+        assertStatistics(t, sp, "8765.4321", "47800", "45600", "46000.1", "46000.2")
+    })
+
+    t.Run("StreamSymbolPrices", func(t *testing.T) {
+        client := newStreamFixtureClient(t, "okex", nil, func(conn *websocket.Conn) {
+            var subscription struct {
+                Args []struct {
+                    InstID string
+                }
+            }
+            if err := conn.ReadJSON(&subscription); err != nil || len(subscription.Args) != 1 || subscription.Args[0].InstID != "BTC-USDT" {
+                t.Errorf("Expecting a subscription to BTC-USDT, got %+v, error: %v", subscription, err)
+                return
+            }
+            conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"subscribe","arg":{"channel":"tickers","instId":"BTC-USDT"}}`))
+            conn.WriteMessage(websocket.TextMessage, readFixture(t, "okex", "stream_tickers.json"))
+            conn.ReadMessage() // Hold until the client is gone
+        })
+        sp := collectStreamed(t, client, []string{"btc-usdt"}, 1)[0]
+
+        if sp.Symbol != "btc-usdt" {
+            t.Fatalf("Expecting symbol as subscribed, got %s", sp.Symbol)
+        }
+        assertPrice(t, "Price", sp.Price, "46000.1")
+        assertPrice(t, "Price24hAgo", sp.Price24hAgo, "47500")
+        assertStatistics(t, sp, "8765.4321", "47800", "45600", "46000.1", "46000.2")
+        if sp.QuoteCurrency != "USDT" {
+            t.Fatalf("Expecting quote currency %q, got %q", "USDT", sp.QuoteCurrency)
+        }
+        if !sp.UpdateAt.Equal(time.Date(2021, 12, 15, 7, 59, 59, 123e6, time.UTC)) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
+        }
+    })
 }
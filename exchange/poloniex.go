@@ -1,15 +1,16 @@
 package exchange
 
 import (
+    "context"
     "encoding/json"
     "errors"
-    "math"
     "strconv"
     "strings"
     "time"
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
+    "github.com/shopspring/decimal"
     "github.com/sirupsen/logrus"
 )
 
@@ -18,8 +19,11 @@ const poloniexBaseApi = "https://poloniex.com/"
 
 type poloniexClient struct {
     *http.Client
-    AccessKey string
-    SecretKey string
+    *marketIndex
+    baseApi    string
+    pastPrices *pastPriceCache
+    AccessKey  string
+    SecretKey  string
 }
 
 type poloniexCommonResponse struct {
@@ -27,17 +31,34 @@ type poloniexCommonResponse struct {
 }
 
 type poloniexTicker struct {
-    Last          float64 `json:",string"`
-    PercentChange float64 `json:",string"`
+    Last          decimal.Decimal
+    PercentChange decimal.Decimal
+    HighestBid    decimal.NullDecimal
+    LowestAsk     decimal.NullDecimal
+    High24hr      decimal.NullDecimal
+    Low24hr       decimal.NullDecimal
+    // Poloniex names the first currency of USDT_BTC base, so this one is in BTC
+    QuoteVolume decimal.NullDecimal
+    IsFrozen    string
 }
 
 type poloniexKline struct {
-    Date int64
-    Open float64
+    Date  int64
+    Open  decimal.Decimal
+    High  decimal.Decimal
+    Low   decimal.Decimal
+    Close decimal.Decimal
+    // Poloniex swaps base and quote, this one is in ETH of BTC_ETH
+    QuoteVolume decimal.Decimal
 }
 
 func NewPoloniexClient(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient {
-    return &poloniexClient{Client: httpClient}
+    client := &poloniexClient{Client: httpClient}
+    client.pastPrices = newHourAgoCache()
+    client.baseApi = getBaseApi(queries, client.GetName(), poloniexBaseApi)
+    limitRate(httpClient, queries, client.GetName(), client.baseApi, 6, 6) // 6 requests per second
+    client.marketIndex = newMarketIndex(client.GetName(), client.ListSymbols)
+    return client
 }
 
 func (client *poloniexClient) GetName() string {
@@ -55,27 +76,39 @@ func (client *poloniexClient) decodeResponse(respBytes []byte, result interface{
     return json.Unmarshal(respBytes, result)
 }
 
-func (client *poloniexClient) GetKlinePrice(symbol string, start time.Time, period int) (float64, error) {
-    end := start.Add(30 * time.Minute)
-    respBytes, err := client.Get(poloniexBaseApi+"public", http.WithQuery(map[string]string{
+func (client *poloniexClient) CandleIntervals() []time.Duration {
+    return []time.Duration{5 * time.Minute, 15 * time.Minute, 30 * time.Minute, 2 * time.Hour, 4 * time.Hour, 24 * time.Hour}
+}
+
+func (client *poloniexClient) GetCandles(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]Candle, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"public", http.WithQuery(map[string]string{
         "command":      "returnChartData",
         "currencyPair": strings.ToUpper(symbol),
         "start":        strconv.FormatInt(start.Unix(), 10),
         "end":          strconv.FormatInt(end.Unix(), 10),
-        "period":       strconv.Itoa(period),
+        "period":       strconv.Itoa(int(interval / time.Second)),
     }))
     if err != nil {
-        return 0, err
+        return nil, err
     }
 
     var respJSON []poloniexKline
     err = client.decodeResponse(respBytes, &respJSON)
     if err != nil {
-        return 0, err
+        return nil, err
     }
-    logrus.Debugf("%s - Kline for %s uses open price at %s", client.GetName(), start.Local(),
-        time.Unix(respJSON[0].Date, 0).Local())
-    return respJSON[0].Open, nil
+    candles := make([]Candle, 0, len(respJSON))
+    for _, kline := range respJSON {
+        candles = append(candles, Candle{
+            Time:   time.Unix(kline.Date, 0),
+            Open:   kline.Open,
+            High:   kline.High,
+            Low:    kline.Low,
+            Close:  kline.Close,
+            Volume: kline.QuoteVolume,
+        })
+    }
+    return candles, nil
 }
 
 func (client *poloniexClient) lookupSymbol(symbol string, tickers map[string]poloniexTicker) *poloniexTicker {
@@ -88,8 +121,8 @@ func (client *poloniexClient) lookupSymbol(symbol string, tickers map[string]pol
     return nil
 }
 
-func (client *poloniexClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
-    respBytes, err := client.Get(poloniexBaseApi+"public", http.WithQuery(map[string]string{"command": "returnTicker"}))
+func (client *poloniexClient) GetTickers(ctx context.Context) (map[string]poloniexTicker, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"public", http.WithQuery(map[string]string{"command": "returnTicker"}))
     if err != nil {
         return nil, err
     }
@@ -98,32 +131,81 @@ func (client *poloniexClient) GetSymbolPrice(symbol string) (*SymbolPrice, error
     if err := client.decodeResponse(respBytes, &tickers); err != nil {
         return nil, err
     }
+    return tickers, nil
+}
+
+func (client *poloniexClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
+    tickers, err := client.GetTickers(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return client.symbolPriceOf(ctx, symbol, tickers)
+}
+
+// returnTicker tells all symbols, with the percentage change of the last 24 hours, download it once for all of them.
+// The price 1 hour ago still takes a returnChartData request per symbol, but only once every 15 minutes, see hourAgoCachePeriod
+func (client *poloniexClient) GetSymbolPrices(ctx context.Context, symbols []string) ([]*SymbolResult, error) {
+    tickers, err := client.GetTickers(ctx)
+    if err != nil {
+        return nil, err
+    }
+    results := make([]*SymbolResult, len(symbols))
+    forEachConcurrently(len(symbols), func(i int) {
+        sp, err := client.symbolPriceOf(ctx, symbols[i], tickers)
+        results[i] = &SymbolResult{Symbol: symbols[i], Source: client.GetName(), Price: sp, Err: err}
+    })
+    return results, nil
+}
+
+func (client *poloniexClient) symbolPriceOf(ctx context.Context, symbol string, tickers map[string]poloniexTicker) (*SymbolPrice, error) {
     symbolTicker := client.lookupSymbol(symbol, tickers)
     if symbolTicker == nil {
         return nil, errors.New("symbol not found")
     }
 
-    var (
-        now             = time.Now()
-        percentChange1h = math.MaxFloat64
-    )
-    price1hAgo, err := client.GetKlinePrice(symbol, now.Add(-1*time.Hour), 300)
-    if err != nil {
-        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err)
-    } else if price1hAgo != 0 {
-        percentChange1h = (symbolTicker.Last - price1hAgo) / price1hAgo * 100
+    now := client.Now()
+    price1hAgo, err1h := client.pastPrices.get(symbol, now.Add(-time.Hour), func() (decimal.Decimal, error) {
+        return priceAgo(ctx, client, symbol, now, time.Hour)
+    })
+    if err1h != nil {
+        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err1h)
     }
+    // Only a percentage change is given for the last 24 hours
+    percentChange24h, _ := symbolTicker.PercentChange.Shift(2).Float64()
 
     return &SymbolPrice{
         Symbol:           symbol,
-        Price:            strconv.FormatFloat(symbolTicker.Last, 'f', -1, 64),
-        UpdateAt:         time.Now(),
+        Price:            symbolTicker.Last,
+        UpdateAt:         client.Now(),
         Source:           client.GetName(),
-        PercentChange1h:  percentChange1h,
-        PercentChange24h: symbolTicker.PercentChange * 100,
+        Price1hAgo:       price1hAgo,
+        PercentChange1h:  changeSince(symbolTicker.Last, price1hAgo, err1h),
+        PercentChange24h: KnownChange(percentChange24h),
+        Volume24h:        symbolTicker.QuoteVolume,
+        High24h:          symbolTicker.High24hr,
+        Low24h:           symbolTicker.Low24hr,
+        Bid:              symbolTicker.HighestBid,
+        Ask:              symbolTicker.LowestAsk,
+        QuoteCurrency:    quoteCurrency(symbol, "_", true),
     }, nil
 }
 
+// Currency pairs of returnTicker, eg. USDT_BTC, are quote first
+func (client *poloniexClient) ListSymbols(ctx context.Context) ([]Market, error) {
+    tickers, err := client.GetTickers(ctx)
+    if err != nil {
+        return nil, err
+    }
+    markets := make([]Market, 0, len(tickers))
+    for name, ticker := range tickers {
+        if ticker.IsFrozen == "1" {
+            continue
+        }
+        markets = append(markets, marketOf(name, "_", true))
+    }
+    return markets, nil
+}
+
 func init() {
     Register(NewPoloniexClient)
 }
@@ -1,44 +1,85 @@
 package exchange
 
 import (
+    "context"
     "testing"
     "time"
 )
 
 func TestPoloniexClient(t *testing.T) {
 
-    var client = registry.getClient("poloniex").(*poloniexClient)
+    var client = newFixtureClient(t, "poloniex", map[string]fixture{
+        "/public?command=returnTicker": {file: "ticker.json"},
+        "/public?command=returnChartData&currencyPair=BTC_ETH&start=1639551300&period=300": {file: "chart_data.json"},
+        "/public?command=returnChartData&currencyPair=ABCEDFG":                             {file: "invalid_pair.json"},
+    }).(*poloniexClient)
 
-    t.Run("GetKlinePrice", func(t *testing.T) {
-        _, err := client.GetKlinePrice("BTC_ETh", time.Now().Add(-1*time.Hour), 300)
+    t.Run("GetCandles", func(t *testing.T) {
+        start := fixtureTime.Add(-65 * time.Minute)
+        candles, err := client.GetCandles(context.Background(), "BTC_ETh", 5*time.Minute, start, start.Add(10*time.Minute))
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
+        if len(candles) != 1 || !candles[0].Time.Equal(fixtureTime.Add(-time.Hour)) {
+            t.Fatalf("Unexpected candles %v", candles)
+        }
+        assertPrice(t, "Open", candles[0].Open, "0.08")
+        assertPrice(t, "Close", candles[0].Close, "0.0803")
+        assertPrice(t, "Volume", candles[0].Volume, "15")
     })
 
-    t.Run("GetKlinePrice of unknown symbol", func(t *testing.T) {
-        _, err := client.GetKlinePrice("abcedfg", time.Now().Add(-1*time.Hour), 300)
+    t.Run("GetCandles of unknown symbol", func(t *testing.T) {
+        start := fixtureTime.Add(-65 * time.Minute)
+        _, err := client.GetCandles(context.Background(), "abcedfg", 5*time.Minute, start, start.Add(10*time.Minute))
 
         if err == nil {
             t.Fatalf("Expecting error when fetching unknown price, but get nil")
         }
-        t.Logf("Returned error is `%v`, expected?", err)
+        if err.Error() != "Invalid currency pair." {
+            t.Fatalf("Expecting error message from response, got %v", err)
+        }
     })
 
     t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("BTC_ETh")
+        sp, err := client.GetSymbolPrice(context.Background(), "BTC_ETh")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
-            t.Fatalf("Get an empty price?")
+        assertPrice(t, "Price", sp.Price, "0.0811")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, (0.0811-0.08)/0.08*100)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, 1.234567)
+        assertStatistics(t, sp, "1522.3456789", "0.082", "0.0795", "0.08109", "0.08111")
+        if sp.QuoteCurrency != "BTC" {
+            t.Fatalf("Expecting quote currency %q, got %q", "BTC", sp.QuoteCurrency)
         }
+        if !sp.UpdateAt.Equal(fixtureTime) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
+        }
+    })
+
+    t.Run("GetSymbolPrices", func(t *testing.T) {
+        results, err := client.GetSymbolPrices(context.Background(), []string{"BTC_ETH", "ABCEDFG"})
+
+        if err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        if len(results) != 2 || results[1].Err == nil {
+            t.Fatalf("Expecting ABCEDFG not found, got %+v", results)
+        }
+        assertPrice(t, "Price", results[0].Price.Price, "0.0811")
+        assertPercentChange(t, "PercentChange1h", results[0].Price.PercentChange1h, (0.0811-0.08)/0.08*100)
+    })
+
+    t.Run("ListSymbols", func(t *testing.T) {
+        markets, err := client.ListSymbols(context.Background())
+
+        assertMarkets(t, markets, err, Market{"BTC_ETH", "ETH", "BTC"}, Market{"USDT_BTC", "BTC", "USDT"})
     })
 
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("ABC123")
+        _, err := client.GetSymbolPrice(context.Background(), "ABC123")
 
         if err == nil {
             t.Fatalf("Should throws on invalid symbol")
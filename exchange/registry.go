@@ -1,8 +1,12 @@
 package exchange
 
 import (
+    "context"
+    "errors"
     "fmt"
     "net"
+    "net/url"
+    "os"
     "sort"
     "strings"
     "time"
@@ -14,7 +18,8 @@ import (
 
 type ExchangeClient interface {
     GetName() string
-    GetSymbolPrice(string) (*SymbolPrice, error)
+    // GetSymbolPrice should give up as soon as ctx is done
+    GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error)
 }
 
 type ExchangeClientProvider func(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient
@@ -29,11 +34,23 @@ type Registry struct {
     clients       map[string]ExchangeClient
     officialNames []string
     hasProxy      bool
+    // Windows of change columns other than 1h and 24h, looked up in candles
+    changeWindows []time.Duration
+    pastPrices    map[time.Duration]*pastPriceCache
+    // Or in prices saved locally, for exchanges without candles
+    history PriceHistory
+    // Market lists are cached here, not cached if empty
+    symbolsCacheDir string
+    now             func() time.Time
 }
 
 func NewRegistry(cfg *config.Config, httpClient *http.Client) *Registry {
     exchangeMap := cfg.GroupQueryByExchange()
-    r := &Registry{clients: make(map[string]ExchangeClient), hasProxy: cfg.Proxy != ""}
+    r := &Registry{clients: make(map[string]ExchangeClient), hasProxy: cfg.Proxy != "", changeWindows: cfg.ChangeWindows(), now: httpClient.Now}
+    r.pastPrices = make(map[time.Duration]*pastPriceCache, len(r.changeWindows))
+    for _, window := range r.changeWindows {
+        r.pastPrices[window] = newPastPriceCache(candlePeriodOf(window))
+    }
     for _, p := range providers {
         eClient := p(exchangeMap, httpClient)
         r.officialNames = append(r.officialNames, eClient.GetName())
@@ -42,36 +59,102 @@ func NewRegistry(cfg *config.Config, httpClient *http.Client) *Registry {
             panic(fmt.Errorf("%q already exists in exchange registry", upperName))
         }
         r.clients[upperName] = eClient
+        // Symbols are translated by market lists in the cache of the registry
+        if idx, ok := eClient.(interface {
+            setMarketLoader(load func(ctx context.Context) ([]Market, error))
+        }); ok {
+            name := eClient.GetName()
+            idx.setMarketLoader(func(ctx context.Context) ([]Market, error) { return r.ListSymbols(ctx, name, false) })
+        }
     }
     return r
 }
 
+// SetHistory looks up changes of exchanges without candles in prices saved by h
+func (r *Registry) SetHistory(h PriceHistory) {
+    r.history = h
+}
+
 func (r *Registry) GetAllNames() []string {
     sort.Strings(r.officialNames)
     return r.officialNames
 }
 
-func (r *Registry) GetSymbolPrices(priceQueries []*config.PriceQuery) []*SymbolPrice {
+// Get prices of all queried symbols, failed ones are kept as errors, results are in the same order as queried.
+// Symbols not fetched before ctx is done are cut off with ErrStale, so one slow exchange won't hold up others.
+func (r *Registry) GetSymbolPrices(ctx context.Context, priceQueries []*config.PriceQuery) []*SymbolResult {
+    waitingChanList := r.getAllPricesAsync(ctx, priceQueries)
+    results := make([]*SymbolResult, 0, len(waitingChanList))
+    for _, doneCh := range waitingChanList {
+        results = append(results, <-doneCh)
+    }
+    return results
+}
+
+// Start querying all symbols, return a waiting chan for each of them in the queried order
+func (r *Registry) getAllPricesAsync(ctx context.Context, priceQueries []*config.PriceQuery) []chan *SymbolResult {
     // Loop all priceQueries from config
-    waitingChanList := make([]chan *SymbolPrice, 0, len(priceQueries))
+    waitingChanList := make([]chan *SymbolResult, 0, len(priceQueries))
     for _, query := range priceQueries {
         client := r.getClient(query.Name)
         if client == nil {
-            logrus.Warnf("Unknown exchange %s, skipping", query.Name)
+            logrus.Warnf("Unknown exchange %s, run 'mt exchanges' to see supported ones", query.Name)
+            for _, symbol := range query.Symbols() {
+                doneCh := make(chan *SymbolResult, 1)
+                doneCh <- &SymbolResult{Symbol: symbol, Source: query.Name, Err: fmt.Errorf("unknown exchange %s", query.Name)}
+                waitingChanList = append(waitingChanList, doneCh)
+            }
             continue
         }
-        pendings := r.getPricesAsync(client, query.Tokens)
+        pendings := r.getQueriedPricesAsync(ctx, client, query.Symbols())
         waitingChanList = append(waitingChanList, pendings...)
     }
+    return waitingChanList
+}
 
-    symbolPriceList := make([]*SymbolPrice, 0, len(waitingChanList))
-    for _, doneCh := range waitingChanList {
-        sp := <-doneCh
-        if sp != nil {
-            symbolPriceList = append(symbolPriceList, sp)
+// Get the base api of an exchange, it can be overridden by environment variable MT_<EXCHANGE>_BASE_URL
+// or "base_url" in config, so requests can be redirected to a mirror or a local mock server.
+// Only scheme and host are replaced, path of the override is prepended to the one of defaultApi,
+// eg. "http://localhost:8080/kraken" turns "https://api.kraken.com/0/public/" into "http://localhost:8080/kraken/0/public/"
+func getBaseApi(queries map[string]*config.PriceQuery, exchangeName, defaultApi string) string {
+    override := os.Getenv("MT_" + strings.ToUpper(exchangeName) + "_BASE_URL")
+    if override == "" {
+        if query, ok := queries[strings.ToUpper(exchangeName)]; ok {
+            override = query.BaseURL
+        }
+    }
+    if override == "" {
+        return defaultApi
+    }
+
+    overrideURL, err := url.Parse(override)
+    if err != nil || overrideURL.Scheme == "" || overrideURL.Host == "" {
+        logrus.Warnf("Invalid base url %q for %s, using %s", override, exchangeName, defaultApi)
+        return defaultApi
+    }
+    defaultURL, err := url.Parse(defaultApi)
+    if err != nil {
+        panic(fmt.Errorf("invalid default base api %q of %s: %w", defaultApi, exchangeName, err))
+    }
+    defaultURL.Scheme = overrideURL.Scheme
+    defaultURL.Host = overrideURL.Host
+    defaultURL.Path = strings.TrimSuffix(overrideURL.Path, "/") + defaultURL.Path
+    logrus.Debugf("Using base api %s for %s", defaultURL, exchangeName)
+    return defaultURL.String()
+}
+
+// Throttle requests sent to baseApi, default rate (requests per second) and burst should follow the documented limits
+// of an exchange, they can be overridden by "rate_limit" and "rate_burst" in config
+func limitRate(httpClient *http.Client, queries map[string]*config.PriceQuery, exchangeName, baseApi string, rate float64, burst int) {
+    if query, ok := queries[strings.ToUpper(exchangeName)]; ok {
+        if query.RateLimit != 0 {
+            rate = query.RateLimit
+        }
+        if query.RateBurst != 0 {
+            burst = query.RateBurst
         }
     }
-    return symbolPriceList
+    httpClient.SetRateLimit(baseApi, rate, burst)
 }
 
 // Factory method to create exchange client
@@ -83,17 +166,39 @@ func (r *Registry) getClient(exchangeName string) ExchangeClient {
     return nil
 }
 
+// Tell why a request is cut off, a missed deadline means the symbol is stale in this refresh
+func cutOffError(ctx context.Context) error {
+    if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+        return ErrStale
+    }
+    return ctx.Err()
+}
+
 // Return a slice of waiting chans, each of them represents a pending request
-func (r *Registry) getPricesAsync(client ExchangeClient, symbols []string) []chan *SymbolPrice {
+func (r *Registry) getPricesAsync(ctx context.Context, client ExchangeClient, symbols []string) []chan *SymbolResult {
+    if batchClient, ok := client.(BatchExchangeClient); ok && len(symbols) > 1 {
+        return r.getBatchPricesAsync(ctx, batchClient, symbols)
+    }
+    return r.getSinglePricesAsync(ctx, client, symbols)
+}
+
+// Query symbols one by one
+func (r *Registry) getSinglePricesAsync(ctx context.Context, client ExchangeClient, symbols []string) []chan *SymbolResult {
     // Use slice to hold the waiting chans in order to keep requested order
-    waitingChans := make([]chan *SymbolPrice, 0, len(symbols))
+    waitingChans := make([]chan *SymbolResult, 0, len(symbols))
     for _, symbol := range symbols {
-        doneCh := make(chan *SymbolPrice, 1)
+        doneCh := make(chan *SymbolResult, 1)
         waitingChans = append(waitingChans, doneCh)
+        resultCh := make(chan *SymbolResult, 1) // Buffered, so a cut off request won't leak the goroutine
         go func(symbol string) {
             start := time.Now()
-            sp, err := client.GetSymbolPrice(symbol)
-            if err != nil {
+            sp, err := client.GetSymbolPrice(ctx, symbol)
+            if err == nil {
+                r.fillChanges(ctx, client, sp)
+            }
+            if err != nil && ctx.Err() != nil {
+                err = cutOffError(ctx) // Failed because of cancellation, not the exchange to blame
+            } else if err != nil {
                 logEntry := logrus.WithError(err)
                 e, ok := err.(net.Error)
                 if ok && e.Timeout() {
@@ -104,9 +209,16 @@ func (r *Registry) getPricesAsync(client ExchangeClient, symbols []string) []cha
                 if r.hasProxy && ok && e.Timeout() {
                     logrus.Info("Maybe you are blocked by a firewall, try using --proxy to go through a proxy?")
                 }
-                close(doneCh) // close channel to indicate an error has happened, any other good idea?
-            } else {
-                doneCh <- sp
+            }
+            resultCh <- &SymbolResult{Symbol: symbol, Source: client.GetName(), Price: sp, Err: err}
+        }(symbol)
+        // A client may not stop right after ctx is done, eg. in the middle of reading a response, so don't wait for it
+        go func(symbol string) {
+            select {
+            case result := <-resultCh:
+                doneCh <- result
+            case <-ctx.Done():
+                doneCh <- &SymbolResult{Symbol: symbol, Source: client.GetName(), Err: cutOffError(ctx)}
             }
         }(symbol)
     }
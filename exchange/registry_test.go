@@ -1,7 +1,11 @@
 package exchange
 
 import (
+    "context"
+    "errors"
+    "os"
     "testing"
+    "time"
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
@@ -10,8 +14,20 @@ import (
 var registry *Registry
 
 func init() {
-    cfg := config.Parse()
-    registry = NewRegistry(cfg, http.New(cfg))
+    cfg := &config.Config{}
+    httpClient, err := http.New(cfg)
+    if err != nil {
+        panic(err)
+    }
+    registry = NewRegistry(cfg, httpClient)
+}
+
+func tokensOf(symbols ...string) []config.Token {
+    tokens := make([]config.Token, len(symbols))
+    for i, symbol := range symbols {
+        tokens[i] = config.Token{Symbol: symbol}
+    }
+    return tokens
 }
 
 func TestRegistry_getClient(t *testing.T) {
@@ -30,3 +46,120 @@ func TestRegistry_getClient(t *testing.T) {
         }
     })
 }
+
+func TestRegistry_GetSymbolPrices(t *testing.T) {
+    registry := newFixtureRegistry(t, "binance", map[string]fixture{
+        "/api/v1/ticker/24hr?symbol=ETHBTC": {file: "ticker_24hr.json"},
+        "/api/v1/ticker/24hr?symbol=ABC123": {file: "invalid_symbol.json", status: 400},
+        "/api/v1/klines":                    {file: "klines.json"},
+        // Binance fails the whole batch with an invalid symbol, then they are queried one by one
+        "/api/v3/ticker/24hr": {file: "invalid_symbol.json", status: 400},
+    })
+
+    results := registry.GetSymbolPrices(context.Background(), []*config.PriceQuery{
+        {Name: "Binance", Tokens: tokensOf("ABC123", "ETHBTC")},
+        {Name: "Nowhere", Tokens: tokensOf("XYZ")},
+    })
+    if len(results) != 3 {
+        t.Fatalf("Expecting 3 results, got %d", len(results))
+    }
+
+    t.Run("keeps order", func(t *testing.T) {
+        for i, symbol := range []string{"ABC123", "ETHBTC", "XYZ"} {
+            if results[i].Symbol != symbol {
+                t.Fatalf("Expecting %s at %d, got %s", symbol, i, results[i].Symbol)
+            }
+        }
+    })
+
+    t.Run("failed symbol", func(t *testing.T) {
+        if results[0].Err == nil || results[0].Price != nil || results[0].Source != "Binance" {
+            t.Fatalf("Expecting an error from Binance, got %+v", results[0])
+        }
+    })
+
+    t.Run("succeeded symbol", func(t *testing.T) {
+        if results[1].Err != nil {
+            t.Fatalf("Unexpected error: %v", results[1].Err)
+        }
+        assertPrice(t, "Price", results[1].Price.Price, "0.08125")
+    })
+
+    t.Run("unknown exchange", func(t *testing.T) {
+        if results[2].Err == nil || results[2].Source != "Nowhere" {
+            t.Fatalf("Expecting an error of unknown exchange, got %+v", results[2])
+        }
+    })
+}
+
+func TestGetBaseApi(t *testing.T) {
+    queries := map[string]*config.PriceQuery{
+        "KRAKEN": {Name: "Kraken", BaseURL: "http://127.0.0.1:8080/mirror/"},
+    }
+
+    t.Run("default base api", func(t *testing.T) {
+        if got := getBaseApi(queries, "Binance", binanceBaseApi); got != binanceBaseApi {
+            t.Fatalf("Expecting %s, got %s", binanceBaseApi, got)
+        }
+    })
+
+    t.Run("override from config", func(t *testing.T) {
+        want := "http://127.0.0.1:8080/mirror/0/public/"
+        if got := getBaseApi(queries, "Kraken", krakenBaseApi); got != want {
+            t.Fatalf("Expecting %s, got %s", want, got)
+        }
+    })
+
+    t.Run("override from env", func(t *testing.T) {
+        os.Setenv("MT_KRAKEN_BASE_URL", "http://localhost:9090")
+        defer os.Unsetenv("MT_KRAKEN_BASE_URL")
+        want := "http://localhost:9090/0/public/"
+        if got := getBaseApi(queries, "Kraken", krakenBaseApi); got != want {
+            t.Fatalf("Expecting %s, got %s", want, got)
+        }
+    })
+
+    t.Run("invalid override", func(t *testing.T) {
+        os.Setenv("MT_BINANCE_BASE_URL", "localhost")
+        defer os.Unsetenv("MT_BINANCE_BASE_URL")
+        if got := getBaseApi(queries, "Binance", binanceBaseApi); got != binanceBaseApi {
+            t.Fatalf("Expecting %s, got %s", binanceBaseApi, got)
+        }
+    })
+}
+
+func TestRegistry_GetSymbolPrices_Deadline(t *testing.T) {
+    registry := newFixtureRegistry(t, "binance", map[string]fixture{
+        "/api/v1/ticker/24hr?symbol=ETHBTC": {file: "ticker_24hr.json", delay: time.Minute},
+        "/api/v1/klines":                    {file: "klines.json"},
+    })
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+    start := time.Now()
+    results := registry.GetSymbolPrices(ctx, []*config.PriceQuery{{Name: "Binance", Tokens: tokensOf("ETHBTC")}})
+    if elapsed := time.Since(start); elapsed > 10*time.Second {
+        t.Fatalf("Expecting slow exchanges to be cut off at the deadline, waited %s", elapsed)
+    }
+    if len(results) != 1 || !errors.Is(results[0].Err, ErrStale) {
+        t.Fatalf("Expecting a stale result, got %+v", results[0])
+    }
+}
+
+func TestRegistry_GetSymbolPrices_Batch(t *testing.T) {
+    // Symbols of an exchange are fetched in a batch, per symbol requests have no fixture and would fail the test
+    registry := newFixtureRegistry(t, "binance", map[string]fixture{
+        "/api/v3/ticker/24hr":          {file: "tickers_24hr.json"},
+        "/api/v3/ticker?windowSize=1h": {file: "tickers_1h.json"},
+    })
+
+    results := registry.GetSymbolPrices(context.Background(), []*config.PriceQuery{{Name: "Binance", Tokens: tokensOf("BNBUSDT", "ETHBTC")}})
+    if len(results) != 2 {
+        t.Fatalf("Expecting 2 results, got %d", len(results))
+    }
+    for i, symbol := range []string{"BNBUSDT", "ETHBTC"} {
+        if results[i].Err != nil || results[i].Price.Symbol != symbol {
+            t.Fatalf("Expecting %s at %d, got %+v", symbol, i, results[i])
+        }
+    }
+}
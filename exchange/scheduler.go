@@ -0,0 +1,181 @@
+package exchange
+
+import (
+    "context"
+    "strings"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/sirupsen/logrus"
+)
+
+// Scheduler refreshes all queried symbols on a fixed cadence, no matter how long exchanges take to respond.
+// The last known price of a symbol is kept when a later query fails, and marked stale once it's older than StaleAfter.
+type Scheduler struct {
+    Interval time.Duration
+    // Queries not finished within Deadline are cut off, a new cycle cuts off the previous one anyway
+    Deadline   time.Duration
+    StaleAfter time.Duration
+    // Render every time a result arrives, instead of once all results of a cycle have arrived
+    RenderOnArrival bool
+    // Subscribe to ticker streams of exchanges supporting it, polling goes on at Interval as a fallback
+    Stream bool
+    // Clock tells what time it is now, replace it to test staleness
+    Clock func() time.Time
+
+    registry *Registry
+    queries  []*config.PriceQuery
+    slots    []slot
+    slotsOf  map[string][]int // Keyed by source and symbol, to find slots of streamed prices
+    updates  chan update
+    pending  int // Results not arrived yet in the current cycle
+}
+
+// Everything we know about a queried symbol
+type slot struct {
+    result    *SymbolResult // Of the latest query, nil if not arrived yet
+    lastKnown *SymbolPrice
+    fetchedAt time.Time // When lastKnown arrived
+    failures  int       // In a row
+}
+
+type update struct {
+    slot   int
+    result *SymbolResult
+}
+
+func NewScheduler(cfg *config.Config, registry *Registry) *Scheduler {
+    symbolCount := 0
+    slotsOf := make(map[string][]int)
+    for _, query := range cfg.Queries {
+        for _, symbol := range query.Symbols() {
+            key := slotKey(query.Name, symbol)
+            slotsOf[key] = append(slotsOf[key], symbolCount)
+            symbolCount++
+        }
+    }
+    return &Scheduler{
+        Interval:   time.Duration(cfg.Refresh) * time.Second,
+        Deadline:   cfg.RefreshDeadline(),
+        StaleAfter: cfg.RefreshStaleAfter(),
+        Stream:     cfg.Stream,
+        Clock:      time.Now,
+        registry:   registry,
+        queries:    cfg.Queries,
+        slots:      make([]slot, symbolCount),
+        slotsOf:    slotsOf,
+        // Every cycle sends exactly one update per symbol, and is drained before the next one starts, so sends never block
+        updates: make(chan update, symbolCount),
+    }
+}
+
+// Run keeps refreshing until ctx is done, render is called with results of all arrived symbols in the queried order
+func (s *Scheduler) Run(ctx context.Context, render func([]*SymbolResult)) {
+    ticker := time.NewTicker(s.Interval)
+    defer ticker.Stop()
+    var streamed chan *SymbolPrice // Never receives if not streaming
+    if s.Stream {
+        streamed = make(chan *SymbolPrice, len(s.slots))
+        if polled := s.registry.streamAllPrices(ctx, s.queries, streamed); len(polled) > 0 {
+            logrus.Infof("%s cannot stream prices, polling on every %s", strings.Join(polled, ", "), s.Interval)
+        }
+    }
+    cancel := s.startCycle(ctx)
+    for {
+        select {
+        case <-ctx.Done():
+            cancel()
+            return
+        case sp := <-streamed:
+            if s.applyStreamed(sp) && s.RenderOnArrival {
+                render(s.Results())
+            }
+        case u := <-s.updates:
+            s.apply(u)
+            if s.RenderOnArrival || s.pending == 0 {
+                render(s.Results())
+            }
+        case <-ticker.C:
+            cancel()
+            if s.pending > 0 {
+                // Cut off ones arrive right after cancel
+                for s.pending > 0 {
+                    s.apply(<-s.updates)
+                }
+                render(s.Results())
+            } else if s.RenderOnArrival {
+                render(s.Results()) // Nothing arrived for a while, but some may have become stale
+            }
+            cancel = s.startCycle(ctx)
+        }
+    }
+}
+
+func (s *Scheduler) startCycle(ctx context.Context) context.CancelFunc {
+    deadline := s.Interval
+    if s.Deadline > 0 && s.Deadline < deadline {
+        deadline = s.Deadline
+    }
+    cycleCtx, cancel := context.WithTimeout(ctx, deadline)
+    waitingChanList := s.registry.getAllPricesAsync(cycleCtx, s.queries)
+    s.pending = len(waitingChanList)
+    for i, doneCh := range waitingChanList {
+        go func(i int, doneCh chan *SymbolResult) {
+            s.updates <- update{slot: i, result: <-doneCh}
+        }(i, doneCh)
+    }
+    return cancel
+}
+
+func (s *Scheduler) apply(u update) {
+    s.pending--
+    sl := &s.slots[u.slot]
+    sl.result = u.result
+    if u.result.Err == nil {
+        sl.lastKnown = u.result.Price
+        sl.fetchedAt = s.Clock()
+        sl.failures = 0
+    } else {
+        sl.failures++
+    }
+    u.result.Failures = sl.failures
+}
+
+// Return false if no one queried the streamed price
+func (s *Scheduler) applyStreamed(sp *SymbolPrice) bool {
+    indexes := s.slotsOf[slotKey(sp.Source, sp.Symbol)]
+    for _, i := range indexes {
+        sl := &s.slots[i]
+        sl.lastKnown = mergeStreamed(sp, sl.lastKnown)
+        sl.fetchedAt = s.Clock()
+        sl.failures = 0
+        sl.result = &SymbolResult{Symbol: sp.Symbol, Source: sp.Source, Price: sl.lastKnown}
+    }
+    return len(indexes) > 0
+}
+
+func slotKey(source, symbol string) string {
+    return strings.ToUpper(source) + "." + strings.ToUpper(symbol)
+}
+
+// Results tells the latest known state of all arrived symbols, a failed one falls back to its last known price
+func (s *Scheduler) Results() []*SymbolResult {
+    now := s.Clock()
+    results := make([]*SymbolResult, 0, len(s.slots))
+    for _, sl := range s.slots {
+        if sl.result == nil {
+            continue
+        }
+        result := sl.result
+        if result.Err != nil && sl.lastKnown != nil {
+            result = &SymbolResult{Symbol: result.Symbol, Source: result.Source, Price: sl.lastKnown, Failures: result.Failures}
+        }
+        if result.Err == nil && s.StaleAfter > 0 && now.Sub(sl.fetchedAt) > s.StaleAfter {
+            staleResult := *result
+            staleResult.Stale = true
+            result = &staleResult
+        }
+        results = append(results, result)
+    }
+    return results
+}
@@ -0,0 +1,112 @@
+package exchange
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/shopspring/decimal"
+)
+
+func TestScheduler_Results(t *testing.T) {
+    cfg := &config.Config{Refresh: 5, Queries: []*config.PriceQuery{{Name: "Binance", Tokens: tokensOf("ETHBTC")}}}
+    s := NewScheduler(cfg, registry)
+    now := fixtureTime
+    s.Clock = func() time.Time { return now }
+
+    if results := s.Results(); len(results) != 0 {
+        t.Fatalf("Expecting nothing before any arrival, got %d results", len(results))
+    }
+
+    price := &SymbolPrice{Symbol: "ETHBTC", Source: "Binance"}
+    s.apply(update{slot: 0, result: &SymbolResult{Symbol: "ETHBTC", Source: "Binance", Price: price}})
+    now = now.Add(time.Second)
+    s.apply(update{slot: 0, result: &SymbolResult{Symbol: "ETHBTC", Source: "Binance", Err: ErrStale}})
+
+    t.Run("keeps last known price", func(t *testing.T) {
+        results := s.Results()
+        if len(results) != 1 || results[0].Err != nil || results[0].Price != price {
+            t.Fatalf("Expecting the last known price, got %+v", results[0])
+        }
+        if results[0].Stale {
+            t.Fatalf("Expecting not stale within %s", s.StaleAfter)
+        }
+    })
+
+    t.Run("stale after", func(t *testing.T) {
+        now = fixtureTime.Add(s.StaleAfter + time.Second)
+        results := s.Results()
+        if !results[0].Stale || results[0].Price != price {
+            t.Fatalf("Expecting a stale last known price, got %+v", results[0])
+        }
+    })
+
+    t.Run("failures in a row", func(t *testing.T) {
+        s.apply(update{slot: 0, result: &SymbolResult{Symbol: "ETHBTC", Source: "Binance", Err: ErrStale}})
+        if failures := s.Results()[0].Failures; failures != 2 {
+            t.Fatalf("Expecting 2 failures in a row, got %d", failures)
+        }
+        s.apply(update{slot: 0, result: &SymbolResult{Symbol: "ETHBTC", Source: "Binance", Price: price}})
+        if failures := s.Results()[0].Failures; failures != 0 {
+            t.Fatalf("Expecting failures reset, got %d", failures)
+        }
+    })
+}
+
+func TestScheduler_Run(t *testing.T) {
+    registry := newFixtureRegistry(t, "binance", map[string]fixture{
+        "/api/v1/ticker/24hr?symbol=ETHBTC": {file: "ticker_24hr.json"},
+        "/api/v1/ticker/24hr?symbol=ABC123": {file: "ticker_24hr.json", delay: time.Minute},
+        "/api/v1/klines":                    {file: "klines.json"},
+    })
+    // One symbol per query, otherwise they are fetched in a batch
+    cfg := &config.Config{Refresh: 1, Queries: []*config.PriceQuery{{Name: "Binance", Tokens: tokensOf("ABC123")}, {Name: "Binance", Tokens: tokensOf("ETHBTC")}}}
+    s := NewScheduler(cfg, registry)
+    s.Interval = 200 * time.Millisecond
+    s.RenderOnArrival = true
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    renders := make(chan []*SymbolResult, 16)
+    go s.Run(ctx, func(results []*SymbolResult) { renders <- results })
+
+    t.Run("renders on arrival", func(t *testing.T) {
+        results := <-renders
+        if len(results) != 1 || results[0].Symbol != "ETHBTC" || results[0].Err != nil {
+            t.Fatalf("Expecting ETHBTC to be rendered before the slow one, got %+v", results)
+        }
+    })
+
+    t.Run("cuts off slow ones in the next cycle", func(t *testing.T) {
+        timeout := time.After(10 * time.Second)
+        for {
+            select {
+            case results := <-renders:
+                if len(results) == 2 && errors.Is(results[0].Err, ErrStale) {
+                    return
+                }
+            case <-timeout:
+                t.Fatalf("Expecting ABC123 to be cut off as stale")
+            }
+        }
+    })
+}
+
+func TestScheduler_applyStreamed(t *testing.T) {
+    cfg := &config.Config{Refresh: 5, Queries: []*config.PriceQuery{{Name: "Binance", Tokens: tokensOf("ETHBTC")}}}
+    s := NewScheduler(cfg, registry)
+
+    if s.applyStreamed(&SymbolPrice{Symbol: "BNBUSDT", Source: "Binance"}) {
+        t.Fatalf("Expecting BNBUSDT to be ignored as it's not queried")
+    }
+    if !s.applyStreamed(&SymbolPrice{Symbol: "ethbtc", Source: "binance", Price: decimal.RequireFromString("0.08")}) {
+        t.Fatalf("Expecting ETHBTC to be updated")
+    }
+    results := s.Results()
+    if len(results) != 1 || results[0].Err != nil {
+        t.Fatalf("Expecting a streamed result, got %+v", results)
+    }
+    assertPrice(t, "Price", results[0].Price.Price, "0.08")
+}
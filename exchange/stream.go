@@ -0,0 +1,186 @@
+package exchange
+
+import (
+    "context"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/sirupsen/logrus"
+)
+
+// StreamingExchangeClient is implemented by exchanges publishing ticker streams, so prices are pushed tick-by-tick
+// instead of being polled
+type StreamingExchangeClient interface {
+    ExchangeClient
+    // StreamSymbolPrices subscribes to tickers of symbols and sends every update to out,
+    // it returns when ctx is done or the connection breaks. Symbol of sent prices should be the same as subscribed.
+    StreamSymbolPrices(ctx context.Context, symbols []string, out chan<- *SymbolPrice) error
+}
+
+// Reconnect delay, doubled on every failure
+const (
+    minReconnectDelay = time.Second
+    maxReconnectDelay = time.Minute
+)
+
+// Get the stream api of an exchange, it follows the overridden base api, eg. "http://localhost:8080" turns
+// "wss://stream.binance.com:9443/stream" into "ws://localhost:8080/stream"
+func getStreamApi(queries map[string]*config.PriceQuery, exchangeName, defaultApi string) string {
+    streamApi := getBaseApi(queries, exchangeName, defaultApi)
+    streamURL, err := url.Parse(streamApi)
+    if err != nil {
+        return streamApi
+    }
+    switch streamURL.Scheme {
+    case "http":
+        streamURL.Scheme = "ws"
+    case "https":
+        streamURL.Scheme = "wss"
+    }
+    return streamURL.String()
+}
+
+// Stream prices of all queried symbols into out until ctx is done, return names of exchanges not streaming
+func (r *Registry) streamAllPrices(ctx context.Context, priceQueries []*config.PriceQuery, out chan<- *SymbolPrice) []string {
+    var polled []string
+    for _, query := range priceQueries {
+        client, ok := r.getClient(query.Name).(StreamingExchangeClient)
+        if !ok {
+            polled = append(polled, query.Name)
+            continue
+        }
+        // Translating may download the market list on first use, so do it in background, not to hold up other exchanges
+        go r.streamQueriedPrices(ctx, client, query.Symbols(), out)
+    }
+    return polled
+}
+
+// Pairs are subscribed by native symbols, and named back in streamed prices
+func (r *Registry) streamQueriedPrices(ctx context.Context, client StreamingExchangeClient, queried []string, out chan<- *SymbolPrice) {
+    var symbols []string
+    pairOf := make(map[string]string)
+    for _, symbol := range queried {
+        native, err := r.nativeSymbol(ctx, client, symbol)
+        if err != nil {
+            logrus.Warnf("%s - Failed to translate %s, not streaming it, error: %v", client.GetName(), symbol, err)
+            continue
+        }
+        if native != symbol {
+            pairOf[strings.ToUpper(native)] = symbol
+        }
+        symbols = append(symbols, native)
+    }
+    if len(symbols) == 0 {
+        return
+    }
+    if len(pairOf) > 0 {
+        named := make(chan *SymbolPrice)
+        go namePairs(ctx, named, out, pairOf)
+        out = named
+    }
+    r.streamPrices(ctx, client, symbols, out)
+}
+
+// Forward streamed prices from in to out, renaming native symbols to the queried pairs
+func namePairs(ctx context.Context, in <-chan *SymbolPrice, out chan<- *SymbolPrice, pairOf map[string]string) {
+    for {
+        select {
+        case sp := <-in:
+            if pair, ok := pairOf[strings.ToUpper(sp.Symbol)]; ok {
+                sp.Symbol = pair
+            }
+            if sendPrice(ctx, out, sp) != nil {
+                return
+            }
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+// Keep streaming until ctx is done, reconnect with exponential backoff if the connection breaks
+func (r *Registry) streamPrices(ctx context.Context, client StreamingExchangeClient, symbols []string, out chan<- *SymbolPrice) {
+    delay := minReconnectDelay
+    for {
+        start := time.Now()
+        connCtx, cancel := context.WithCancel(ctx)
+        err := client.StreamSymbolPrices(connCtx, symbols, out)
+        cancel()
+        if ctx.Err() != nil {
+            return
+        }
+        if time.Since(start) > maxReconnectDelay {
+            delay = minReconnectDelay // It worked for a while, not the same failure
+        }
+        logrus.Warnf("%s - Stream of %s is broken, reconnect in %s, error: %v", client.GetName(), strings.Join(symbols, ","), delay, err)
+        select {
+        case <-time.After(delay):
+        case <-ctx.Done():
+            return
+        }
+        if delay *= 2; delay > maxReconnectDelay {
+            delay = maxReconnectDelay
+        }
+    }
+}
+
+// Send a streamed price unless ctx is done, so a stream never blocks after its receiver is gone
+func sendPrice(ctx context.Context, out chan<- *SymbolPrice, sp *SymbolPrice) error {
+    select {
+    case out <- sp:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Tickers pushed by streams hardly tell prices 1 hour ago, fill in what we know from the last polling
+func mergeStreamed(sp, last *SymbolPrice) *SymbolPrice {
+    if last == nil {
+        return sp
+    }
+    merged := *sp
+    if merged.Price1hAgo.IsZero() && !merged.PercentChange1h.Known {
+        merged.Price1hAgo = last.Price1hAgo
+        merged.PercentChange1h = percentChange(merged.Price, merged.Price1hAgo)
+    }
+    if merged.Price24hAgo.IsZero() && !merged.PercentChange24h.Known {
+        merged.Price24hAgo = last.Price24hAgo
+        merged.PercentChange24h = percentChange(merged.Price, merged.Price24hAgo)
+    }
+    if len(last.PercentChanges) > 0 {
+        // Fresh maps, the ones of sp stay untouched
+        merged.PricesAgo, merged.PercentChanges = nil, nil
+        for window, change := range last.PercentChanges {
+            ago := last.PricesAgo[window]
+            if !ago.IsZero() {
+                change = percentChange(merged.Price, ago)
+            }
+            merged.setChange(window, ago, change)
+        }
+        for window, change := range sp.PercentChanges {
+            merged.setChange(window, sp.PricesAgo[window], change)
+        }
+    }
+    if !merged.Volume24h.Valid {
+        merged.Volume24h = last.Volume24h
+    }
+    if !merged.High24h.Valid {
+        merged.High24h = last.High24h
+    }
+    if !merged.Low24h.Valid {
+        merged.Low24h = last.Low24h
+    }
+    if !merged.Bid.Valid {
+        merged.Bid = last.Bid
+    }
+    if !merged.Ask.Valid {
+        merged.Ask = last.Ask
+    }
+    if merged.QuoteCurrency == "" {
+        merged.QuoteCurrency = last.QuoteCurrency
+    }
+    return &merged
+}
@@ -0,0 +1,101 @@
+package exchange
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/shopspring/decimal"
+)
+
+func TestGetStreamApi(t *testing.T) {
+    queries := map[string]*config.PriceQuery{"BINANCE": {Name: "Binance", BaseURL: "http://localhost:8080/mock"}}
+
+    if api := getStreamApi(queries, "Binance", binanceStreamApi); api != "ws://localhost:8080/mock/stream" {
+        t.Fatalf("Expecting stream api to follow base url, got %s", api)
+    }
+    if api := getStreamApi(nil, "Binance", binanceStreamApi); api != binanceStreamApi {
+        t.Fatalf("Expecting default stream api, got %s", api)
+    }
+}
+
+func TestMergeStreamed(t *testing.T) {
+    last := &SymbolPrice{
+        Symbol:        "BTCUSDT",
+        Price:         decimal.RequireFromString("100"),
+        Price1hAgo:    decimal.RequireFromString("80"),
+        Price24hAgo:   decimal.RequireFromString("50"),
+        Volume24h:     decimal.NewNullDecimal(decimal.RequireFromString("1234")),
+        QuoteCurrency: "USDT",
+    }
+    last.setChange(7*24*time.Hour, decimal.RequireFromString("40"), percentChange(last.Price, decimal.RequireFromString("40")))
+    last.setChange(30*24*time.Hour, decimal.Zero, KnownChange(-10)) // Only the percentage is known
+    streamed := &SymbolPrice{
+        Symbol:           "BTCUSDT",
+        Price:            decimal.RequireFromString("120"),
+        Price24hAgo:      decimal.RequireFromString("60"),
+        PercentChange1h:  UnknownChange(ReasonNotSupported),
+        PercentChange24h: percentChange(decimal.RequireFromString("120"), decimal.RequireFromString("60")),
+        Ask:              decimal.NewNullDecimal(decimal.RequireFromString("121")),
+        High24h:          decimal.NewNullDecimal(decimal.Zero), // Known, though zero
+    }
+
+    sp := mergeStreamed(streamed, last)
+    assertPrice(t, "Price", sp.Price, "120")
+    assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, 50)
+    assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, 100)
+    assertPercentChange(t, "PercentChange(7d)", sp.PercentChange(7*24*time.Hour), 200)
+    assertPercentChange(t, "PercentChange(30d)", sp.PercentChange(30*24*time.Hour), -10)
+    if streamed.PercentChanges != nil {
+        t.Fatalf("Streamed price should not be touched")
+    }
+    assertStatistics(t, sp, "1234", "0", "", "", "121")
+    if sp.QuoteCurrency != "USDT" {
+        t.Fatalf("Expecting quote currency %q, got %q", "USDT", sp.QuoteCurrency)
+    }
+    if mergeStreamed(streamed, nil) != streamed {
+        t.Fatalf("Expecting streamed price as is without a last known one")
+    }
+}
+
+// Fail on the first connection, then stream a price
+type flakyStreamClient struct {
+    connected int
+}
+
+func (c *flakyStreamClient) GetName() string {
+    return "Flaky"
+}
+
+func (c *flakyStreamClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
+    return nil, errors.New("not polled")
+}
+
+func (c *flakyStreamClient) StreamSymbolPrices(ctx context.Context, symbols []string, out chan<- *SymbolPrice) error {
+    c.connected++
+    if c.connected == 1 {
+        return errors.New("connection reset")
+    }
+    if err := sendPrice(ctx, out, &SymbolPrice{Symbol: symbols[0], Source: c.GetName()}); err != nil {
+        return err
+    }
+    <-ctx.Done()
+    return ctx.Err()
+}
+
+func TestRegistry_streamPrices(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    client := &flakyStreamClient{}
+    out := make(chan *SymbolPrice)
+    go registry.streamPrices(ctx, client, []string{"BTCUSDT"}, out)
+
+    if sp := <-out; sp.Symbol != "BTCUSDT" {
+        t.Fatalf("Expecting BTCUSDT streamed, got %s", sp.Symbol)
+    }
+    if client.connected != 2 {
+        t.Fatalf("Expecting to reconnect once, connected %d times", client.connected)
+    }
+}
@@ -0,0 +1,318 @@
+package exchange
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/sirupsen/logrus"
+)
+
+// Market lists are fetched again after this long, exchanges rarely list or delist pairs
+const symbolsCacheTTL = 24 * time.Hour
+
+// Market is a pair traded on an exchange, Symbol is how it's queried natively, eg. USDT-BTC of Bittrex,
+// Base and Quote are in commonly used names, eg. BTC instead of XBT of Kraken, see currencyAliases
+type Market struct {
+    Symbol string `json:"symbol"`
+    Base   string `json:"base"`
+    Quote  string `json:"quote"`
+}
+
+// SymbolLister is implemented by exchanges listing their markets, so users don't have to guess how symbols are written
+type SymbolLister interface {
+    ExchangeClient
+    // ListSymbols returns markets open for trading, in any order
+    ListSymbols(ctx context.Context) ([]Market, error)
+}
+
+// SymbolTranslator is implemented by exchanges translating pairs in the unified notation, eg. BTC/USD,
+// to and from their native symbols, eg. XXBTZUSD of Kraken, by looking them up in the market list
+type SymbolTranslator interface {
+    SymbolLister
+    // DenormalizeSymbol returns the native symbol of a pair
+    DenormalizeSymbol(ctx context.Context, pair string) (string, error)
+    // NormalizeSymbol returns the pair of a native symbol
+    NormalizeSymbol(ctx context.Context, symbol string) (string, error)
+}
+
+// Currencies named differently by exchanges, they are renamed to the commonly used ones in market lists
+var currencyAliases = map[string]map[string]string{
+    "Bitfinex": {"UST": "USDT"},
+    "Kraken":   {"XBT": "BTC", "XDG": "DOGE"},
+}
+
+// Rename currencies of a market to the commonly used ones
+func unaliasMarket(exchangeName string, m Market) Market {
+    aliases := currencyAliases[exchangeName]
+    if name, ok := aliases[m.Base]; ok {
+        m.Base = name
+    }
+    if name, ok := aliases[m.Quote]; ok {
+        m.Quote = name
+    }
+    return m
+}
+
+// Market list of an exchange kept in memory to translate symbols, embedded in clients to make them SymbolTranslators.
+// It's loaded on first use, and loaded again if that failed.
+type marketIndex struct {
+    exchangeName string
+    load         func(ctx context.Context) ([]Market, error)
+    mu           sync.Mutex
+    markets      []Market
+}
+
+func newMarketIndex(exchangeName string, load func(ctx context.Context) ([]Market, error)) *marketIndex {
+    return &marketIndex{exchangeName: exchangeName, load: load}
+}
+
+// Load markets in another way, eg. from the cache of the registry
+func (idx *marketIndex) setMarketLoader(load func(ctx context.Context) ([]Market, error)) {
+    idx.mu.Lock()
+    defer idx.mu.Unlock()
+    idx.load = load
+}
+
+func (idx *marketIndex) getMarkets(ctx context.Context) ([]Market, error) {
+    idx.mu.Lock()
+    defer idx.mu.Unlock()
+    if idx.markets == nil {
+        markets, err := idx.load(ctx)
+        if err != nil {
+            return nil, fmt.Errorf("failed to list markets: %w", err)
+        }
+        idx.markets = markets
+    }
+    return idx.markets, nil
+}
+
+func (idx *marketIndex) DenormalizeSymbol(ctx context.Context, pair string) (string, error) {
+    base, quote, err := config.SplitPair(pair)
+    if err != nil {
+        return "", err
+    }
+    markets, err := idx.getMarkets(ctx)
+    if err != nil {
+        return "", err
+    }
+    for _, m := range markets {
+        if strings.EqualFold(m.Base, base) && strings.EqualFold(m.Quote, quote) {
+            return m.Symbol, nil
+        }
+    }
+    return "", fmt.Errorf("no market of %s/%s on %s, find them with 'mt symbols %s'",
+        base, quote, idx.exchangeName, strings.ToLower(idx.exchangeName))
+}
+
+func (idx *marketIndex) NormalizeSymbol(ctx context.Context, symbol string) (string, error) {
+    markets, err := idx.getMarkets(ctx)
+    if err != nil {
+        return "", err
+    }
+    for _, m := range markets {
+        if strings.EqualFold(m.Symbol, symbol) && m.Base != "" && m.Quote != "" {
+            return m.Base + "/" + m.Quote, nil
+        }
+    }
+    return "", fmt.Errorf("no market %s on %s", symbol, idx.exchangeName)
+}
+
+// Market of a symbol joined by sep, eg. btc_usdt, base and quote are unknown if it's not joined so, see quoteCurrency
+func marketOf(symbol, sep string, quoteFirst bool) Market {
+    m := Market{Symbol: symbol}
+    parts := strings.Split(strings.ToUpper(symbol), sep)
+    if len(parts) != 2 {
+        return m
+    }
+    if quoteFirst {
+        m.Quote, m.Base = parts[0], parts[1]
+    } else {
+        m.Base, m.Quote = parts[0], parts[1]
+    }
+    return m
+}
+
+// Markets saved in the cache file of an exchange
+type cachedSymbols struct {
+    FetchedAt time.Time `json:"fetched_at"`
+    Markets   []Market  `json:"markets"`
+}
+
+// SetSymbolsCache keeps market lists in files of dir, so they are fetched once a day at most
+func (r *Registry) SetSymbolsCache(dir string) {
+    r.symbolsCacheDir = dir
+}
+
+func (r *Registry) symbolsCacheFile(exchangeName string) string {
+    return filepath.Join(r.symbolsCacheDir, "symbols-"+strings.ToLower(exchangeName)+".json")
+}
+
+func (r *Registry) readSymbolsCache(exchangeName string) (*cachedSymbols, error) {
+    data, err := ioutil.ReadFile(r.symbolsCacheFile(exchangeName))
+    if err != nil {
+        return nil, err
+    }
+    var cached cachedSymbols
+    if err := json.Unmarshal(data, &cached); err != nil {
+        return nil, fmt.Errorf("corrupted cache of %s markets: %w", exchangeName, err)
+    }
+    return &cached, nil
+}
+
+func (r *Registry) writeSymbolsCache(exchangeName string, cached *cachedSymbols) error {
+    if err := os.MkdirAll(r.symbolsCacheDir, 0755); err != nil {
+        return err
+    }
+    data, err := json.Marshal(cached)
+    if err != nil {
+        return err
+    }
+    // Written aside and renamed, so a concurrent run never reads half of it
+    tmp := r.symbolsCacheFile(exchangeName) + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, r.symbolsCacheFile(exchangeName))
+}
+
+// ListSymbols returns markets of an exchange sorted by symbol, from the cache if it's fresh, unless update is true.
+// The stale cache is used if the exchange fails.
+func (r *Registry) ListSymbols(ctx context.Context, exchangeName string, update bool) ([]Market, error) {
+    client := r.getClient(exchangeName)
+    if client == nil {
+        return nil, errors.New("unknown exchange, run 'mt exchanges' to see supported ones")
+    }
+    lister, ok := client.(SymbolLister)
+    if !ok {
+        return nil, fmt.Errorf("%s doesn't list its markets", client.GetName())
+    }
+
+    var cached *cachedSymbols
+    if r.symbolsCacheDir != "" {
+        var err error
+        if cached, err = r.readSymbolsCache(client.GetName()); err != nil && !os.IsNotExist(err) {
+            logrus.Debugf("%s - Failed to read cached markets, error: %v", client.GetName(), err)
+        }
+        if cached != nil && !update && r.now().Sub(cached.FetchedAt) < symbolsCacheTTL {
+            return cached.Markets, nil
+        }
+    }
+    markets, err := lister.ListSymbols(ctx)
+    if err != nil {
+        if cached != nil && ctx.Err() == nil {
+            logrus.Warnf("%s - Failed to update markets, using the ones cached at %s, error: %v",
+                client.GetName(), cached.FetchedAt.Local().Format(time.RFC3339), err)
+            return cached.Markets, nil
+        }
+        return nil, err
+    }
+    sort.Slice(markets, func(i, j int) bool { return markets[i].Symbol < markets[j].Symbol })
+    if r.symbolsCacheDir != "" {
+        if err := r.writeSymbolsCache(client.GetName(), &cachedSymbols{FetchedAt: r.now(), Markets: markets}); err != nil {
+            logrus.Warnf("%s - Failed to cache markets, error: %v", client.GetName(), err)
+        }
+    }
+    return markets, nil
+}
+
+// FilterMarkets keeps markets matching filter, which is either part of a symbol, eg. usdt,
+// or base and quote separated by a slash, either of them can be left out, eg. btc/usd, btc/ or /usdt
+func FilterMarkets(markets []Market, filter string) []Market {
+    filter = strings.ToUpper(strings.TrimSpace(filter))
+    if filter == "" {
+        return markets
+    }
+    var matched []Market
+    pair := marketOf(filter, "/", false)
+    for _, m := range markets {
+        if strings.Contains(filter, "/") {
+            if (pair.Base == "" || strings.EqualFold(m.Base, pair.Base)) && (pair.Quote == "" || strings.EqualFold(m.Quote, pair.Quote)) {
+                matched = append(matched, m)
+            }
+        } else if strings.Contains(strings.ToUpper(m.Symbol), filter) {
+            matched = append(matched, m)
+        }
+    }
+    return matched
+}
+
+// Native symbol of a queried one, pairs are translated by the exchange, others are kept as they are
+func (r *Registry) nativeSymbol(ctx context.Context, client ExchangeClient, symbol string) (string, error) {
+    if !config.IsPair(symbol) {
+        return symbol, nil
+    }
+    translator, ok := client.(SymbolTranslator)
+    if !ok {
+        return "", fmt.Errorf("%s doesn't translate pairs like %s, query it by the native symbol", client.GetName(), symbol)
+    }
+    return translator.DenormalizeSymbol(ctx, symbol)
+}
+
+// Same as getPricesAsync, but symbols may be pairs, which are queried by native symbols and named back in results
+func (r *Registry) getQueriedPricesAsync(ctx context.Context, client ExchangeClient, symbols []string) []chan *SymbolResult {
+    hasPairs := false
+    for _, symbol := range symbols {
+        hasPairs = hasPairs || config.IsPair(symbol)
+    }
+    if !hasPairs {
+        return r.getPricesAsync(ctx, client, symbols)
+    }
+
+    resultChs := make([]chan *SymbolResult, len(symbols))
+    for i := range symbols {
+        resultChs[i] = make(chan *SymbolResult, 1)
+    }
+    // Translating may download the market list on first use, so do it in background, not to hold up other exchanges
+    go func() {
+        natives := make([]string, len(symbols))
+        errs := make([]error, len(symbols))
+        var queried []string
+        for i, symbol := range symbols {
+            if natives[i], errs[i] = r.nativeSymbol(ctx, client, symbol); errs[i] != nil {
+                logrus.Warnf("%s - Failed to translate %s, error: %v", client.GetName(), symbol, errs[i])
+                continue
+            }
+            queried = append(queried, natives[i])
+        }
+        pendings := r.getPricesAsync(ctx, client, queried)
+        for i, symbol := range symbols {
+            if errs[i] != nil {
+                resultChs[i] <- &SymbolResult{Symbol: symbol, Source: client.GetName(), Err: errs[i]}
+                continue
+            }
+            go func(resultCh, pending chan *SymbolResult, symbol string) {
+                result := <-pending
+                result.Symbol = symbol
+                if result.Price != nil {
+                    result.Price.Symbol = symbol
+                }
+                resultCh <- result
+            }(resultChs[i], pendings[0], symbol)
+            pendings = pendings[1:]
+        }
+    }()
+
+    waitingChans := make([]chan *SymbolResult, len(symbols))
+    for i, symbol := range symbols {
+        waitingChans[i] = make(chan *SymbolResult, 1)
+        go func(doneCh, resultCh chan *SymbolResult, symbol string) {
+            select {
+            case result := <-resultCh:
+                doneCh <- result
+            case <-ctx.Done():
+                doneCh <- &SymbolResult{Symbol: symbol, Source: client.GetName(), Err: cutOffError(ctx)}
+            }
+        }(waitingChans[i], resultChs[i], symbol)
+    }
+    return waitingChans
+}
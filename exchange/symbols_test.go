@@ -0,0 +1,187 @@
+package exchange
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+)
+
+func TestRegistry_ListSymbols(t *testing.T) {
+    r := newFixtureRegistry(t, "binance", map[string]fixture{"/api/v3/exchangeInfo": {file: "exchange_info.json"}})
+    r.SetSymbolsCache(t.TempDir())
+    fetched := []Market{{"BNBUSDT", "BNB", "USDT"}, {"ETHBTC", "ETH", "BTC"}} // Sorted
+
+    markets, err := r.ListSymbols(context.Background(), "binance", false)
+    if len(markets) != 2 || markets[0].Symbol != "BNBUSDT" {
+        t.Fatalf("Expecting markets sorted by symbol, got %v", markets)
+    }
+    assertMarkets(t, markets, err, fetched...)
+
+    t.Run("cached", func(t *testing.T) {
+        cached := []Market{{"LTCBTC", "LTC", "BTC"}}
+        if err := r.writeSymbolsCache("Binance", &cachedSymbols{FetchedAt: fixtureTime.Add(-time.Hour), Markets: cached}); err != nil {
+            t.Fatalf("Failed to write cache: %v", err)
+        }
+        markets, err := r.ListSymbols(context.Background(), "BINANCE", false)
+        assertMarkets(t, markets, err, cached...)
+
+        markets, err = r.ListSymbols(context.Background(), "binance", true)
+        assertMarkets(t, markets, err, fetched...)
+    })
+
+    t.Run("expired", func(t *testing.T) {
+        r.now = func() time.Time { return fixtureTime.Add(symbolsCacheTTL) }
+        defer func() { r.now = func() time.Time { return fixtureTime } }()
+        if err := r.writeSymbolsCache("Binance", &cachedSymbols{FetchedAt: fixtureTime, Markets: []Market{{"LTCBTC", "LTC", "BTC"}}}); err != nil {
+            t.Fatalf("Failed to write cache: %v", err)
+        }
+        markets, err := r.ListSymbols(context.Background(), "binance", false)
+        assertMarkets(t, markets, err, fetched...)
+    })
+
+    t.Run("not listed", func(t *testing.T) {
+        if _, err := r.ListSymbols(context.Background(), "coinmarketcap", false); err == nil {
+            t.Fatalf("Expecting error of exchanges not listing markets")
+        }
+        if _, err := r.ListSymbols(context.Background(), "abc", false); err == nil {
+            t.Fatalf("Expecting error of unknown exchanges")
+        }
+    })
+}
+
+func TestRegistry_ListSymbols_stale(t *testing.T) {
+    r := newFixtureRegistry(t, "binance", map[string]fixture{"/api/v3/exchangeInfo": {file: "invalid_symbol.json", status: 500}})
+    r.SetSymbolsCache(t.TempDir())
+    if _, err := r.ListSymbols(context.Background(), "binance", false); err == nil {
+        t.Fatalf("Expecting error without cache")
+    }
+
+    stale := []Market{{"LTCBTC", "LTC", "BTC"}}
+    if err := r.writeSymbolsCache("Binance", &cachedSymbols{FetchedAt: fixtureTime.Add(-7 * 24 * time.Hour), Markets: stale}); err != nil {
+        t.Fatalf("Failed to write cache: %v", err)
+    }
+    markets, err := r.ListSymbols(context.Background(), "binance", true)
+    assertMarkets(t, markets, err, stale...)
+}
+
+func TestMarketIndex(t *testing.T) {
+    kraken := newFixtureClient(t, "kraken", map[string]fixture{"/0/public/AssetPairs": {file: "asset_pairs_all.json"}}).(SymbolTranslator)
+    bittrex := newFixtureClient(t, "bittrex", map[string]fixture{"/api/v1.1/public/getmarkets": {file: "markets.json"}}).(SymbolTranslator)
+    for _, tc := range []struct {
+        client SymbolTranslator
+        pair   string
+        want   string // Empty if not found
+    }{
+        {kraken, "BTC/USD", "XXBTZUSD"},
+        {kraken, "eth/btc", "XETHXXBT"},
+        {kraken, "XBT/USD", ""}, // Aliases are renamed
+        {bittrex, "BTC/USDT", "USDT-BTC"},
+        {bittrex, "USDT/BTC", ""},
+        {bittrex, "BTC/", ""},
+    } {
+        native, err := tc.client.DenormalizeSymbol(context.Background(), tc.pair)
+        if tc.want == "" {
+            if err == nil {
+                t.Fatalf("Expecting no market of %s on %s, got %s", tc.pair, tc.client.GetName(), native)
+            }
+            continue
+        }
+        if err != nil || native != tc.want {
+            t.Fatalf("Expecting %s of %s on %s, got %s, error: %v", tc.want, tc.pair, tc.client.GetName(), native, err)
+        }
+        pair, err := tc.client.NormalizeSymbol(context.Background(), strings.ToLower(native))
+        if err != nil || !strings.EqualFold(pair, tc.pair) {
+            t.Fatalf("Expecting %s of %s on %s, got %s, error: %v", tc.pair, native, tc.client.GetName(), pair, err)
+        }
+    }
+}
+
+func TestRegistry_GetSymbolPrices_pairs(t *testing.T) {
+    r := newFixtureRegistry(t, "kraken", map[string]fixture{
+        "/0/public/AssetPairs":                                      {file: "asset_pairs_all.json"},
+        "/0/public/Ticker?pair=XXBTZUSD":                            {file: "ticker.json"},
+        "/0/public/OHLC?pair=XXBTZUSD&since=1639551540&interval=1":  {file: "ohlc_1h.json"},
+        "/0/public/OHLC?pair=XXBTZUSD&since=1639467000&interval=30": {file: "ohlc_24h.json"},
+    })
+    results := r.GetSymbolPrices(context.Background(), []*config.PriceQuery{
+        {Name: "kraken", Tokens: []config.Token{{Symbol: "BTC/USD"}, {Symbol: "BTC/EUR"}}},
+        {Name: "coinmarketcap", Tokens: []config.Token{{Symbol: "BTC/USD"}}},
+    })
+
+    if len(results) != 3 {
+        t.Fatalf("Expecting 3 results, got %d", len(results))
+    }
+    if sp := results[0].Price; results[0].Err != nil || results[0].Symbol != "BTC/USD" || sp.Symbol != "BTC/USD" {
+        t.Fatalf("Expecting price named as queried, got %+v", results[0])
+    }
+    assertPrice(t, "Price", results[0].Price.Price, "46000.50000")
+    assertPercentChange(t, "PercentChange1h", results[0].Price.PercentChange1h, (46000.5-45100)/45100*100)
+    for _, result := range results[1:] {
+        if result.Err == nil || result.Symbol != "BTC/EUR" && result.Symbol != "BTC/USD" {
+            t.Fatalf("Expecting error of pairs not translated, got %+v", result)
+        }
+    }
+}
+
+func TestRegistry_getQueriedPricesAsync_slowMarkets(t *testing.T) {
+    r := newFixtureRegistry(t, "kraken", map[string]fixture{
+        "/0/public/AssetPairs": {file: "asset_pairs_all.json", delay: time.Minute},
+    })
+    ctx, cancel := context.WithCancel(context.Background())
+    start := time.Now()
+    waitingChans := r.getQueriedPricesAsync(ctx, r.getClient("kraken"), []string{"BTC/USD"})
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Fatalf("Expecting pairs translated in background, waited %s", elapsed)
+    }
+    cancel()
+    if result := <-waitingChans[0]; result.Symbol != "BTC/USD" || !errors.Is(result.Err, context.Canceled) {
+        t.Fatalf("Expecting a canceled result of BTC/USD, got %+v", result)
+    }
+}
+
+func TestNamePairs(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    in, out := make(chan *SymbolPrice), make(chan *SymbolPrice)
+    go namePairs(ctx, in, out, map[string]string{"XXBTZUSD": "BTC/USD"})
+
+    for _, tc := range []struct{ streamed, want string }{{"xxbtzusd", "BTC/USD"}, {"XETHXXBT", "XETHXXBT"}} {
+        in <- &SymbolPrice{Symbol: tc.streamed}
+        if sp := <-out; sp.Symbol != tc.want {
+            t.Fatalf("Expecting %s streamed as %s, got %s", tc.streamed, tc.want, sp.Symbol)
+        }
+    }
+}
+
+func TestFilterMarkets(t *testing.T) {
+    markets := []Market{{"BTCUSDT", "BTC", "USDT"}, {"ETHBTC", "ETH", "BTC"}, {"ETHUSDT", "ETH", "USDT"}, {"XBTUSD", "XBT", "USD"}}
+    for _, tc := range []struct {
+        filter string
+        want   []string
+    }{
+        {"", []string{"BTCUSDT", "ETHBTC", "ETHUSDT", "XBTUSD"}},
+        {"btc", []string{"BTCUSDT", "ETHBTC"}},
+        {"usd", []string{"BTCUSDT", "ETHUSDT", "XBTUSD"}},
+        {"eth/", []string{"ETHBTC", "ETHUSDT"}},
+        {"/usd", []string{"XBTUSD"}},
+        {"btc/usdt", []string{"BTCUSDT"}},
+        {"doge", nil},
+    } {
+        var got []string
+        for _, m := range FilterMarkets(markets, tc.filter) {
+            got = append(got, m.Symbol)
+        }
+        if len(got) != len(tc.want) {
+            t.Fatalf("Expecting %v filtered by %q, got %v", tc.want, tc.filter, got)
+        }
+        for i := range got {
+            if got[i] != tc.want[i] {
+                t.Fatalf("Expecting %v filtered by %q, got %v", tc.want, tc.filter, got)
+            }
+        }
+    }
+}
@@ -1,15 +1,17 @@
 package exchange
 
 import (
+    "context"
     "encoding/json"
     "errors"
-    "math"
+    "fmt"
     "strconv"
     "strings"
     "time"
 
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/http"
+    "github.com/shopspring/decimal"
     "github.com/sirupsen/logrus"
 )
 
@@ -20,6 +22,8 @@ const zbBaseApi = "http://api.zb.com/data/v1/"
 
 type zbClient struct {
     *http.Client
+    *marketIndex
+    baseApi string
 }
 
 type zbCommonResponse struct {
@@ -31,13 +35,18 @@ type zbTickerResponse struct {
     zbCommonResponse
     Date   int64 `json:",string"`
     Ticker struct {
-        Last float64 `json:",string"`
+        Last decimal.Decimal
+        High decimal.NullDecimal
+        Low  decimal.NullDecimal
+        Buy  decimal.NullDecimal
+        Sell decimal.NullDecimal
+        Vol  decimal.NullDecimal
     }
 }
 
 type zbKlineResponse struct {
     zbCommonResponse
-    Data [][]float64
+    Data [][]decimal.Decimal
 }
 
 func (resp *zbTickerResponse) getCommonResponse() zbCommonResponse {
@@ -54,7 +63,11 @@ type zbCommonResponseProvider interface {
 }
 
 func NewZBClient(queries map[string]*config.PriceQuery, httpClient *http.Client) ExchangeClient {
-    return &zbClient{Client: httpClient}
+    client := &zbClient{Client: httpClient}
+    client.baseApi = getBaseApi(queries, client.GetName(), zbBaseApi)
+    limitRate(httpClient, queries, client.GetName(), client.baseApi, 1, 1) // ZB limits 1 req/sec for Kline
+    client.marketIndex = newMarketIndex(client.GetName(), client.ListSymbols)
+    return client
 }
 
 func (client *zbClient) GetName() string {
@@ -77,29 +90,59 @@ func (client *zbClient) decodeResponse(respByte []byte, respJSON zbCommonRespons
     return nil
 }
 
-func (client *zbClient) GetKlinePrice(symbol, period string, size int) (float64, error) {
-    symbol = strings.ToLower(symbol)
-    respBytes, err := client.Get(zbBaseApi+"kline", http.WithQuery(map[string]string{
-        "market": symbol,
-        "type":   period,
+var zbCandleIntervals = map[time.Duration]string{
+    time.Minute: "1min", 3 * time.Minute: "3min", 5 * time.Minute: "5min", 15 * time.Minute: "15min", 30 * time.Minute: "30min",
+    time.Hour: "1hour", 2 * time.Hour: "2hour", 4 * time.Hour: "4hour", 6 * time.Hour: "6hour", 12 * time.Hour: "12hour",
+    24 * time.Hour: "1day", 3 * 24 * time.Hour: "3day", 7 * 24 * time.Hour: "1week",
+}
+
+// ZB returns up to 1000 latest candles
+const zbMaxCandles = 1000
+
+func (client *zbClient) CandleIntervals() []time.Duration {
+    return sortedIntervals(zbCandleIntervals)
+}
+
+// ZB has no start time, enough latest candles are fetched to reach start
+func (client *zbClient) GetCandles(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]Candle, error) {
+    size := int(client.Now().Sub(start)/interval) + 1
+    if size > zbMaxCandles {
+        size = zbMaxCandles
+    }
+    respBytes, err := client.Get(ctx, client.baseApi+"kline", http.WithQuery(map[string]string{
+        "market": strings.ToLower(symbol),
+        "type":   zbCandleIntervals[interval],
         "size":   strconv.Itoa(size),
     }))
     if err != nil {
-        return 0, err
+        return nil, err
     }
 
     var respJSON zbKlineResponse
     err = client.decodeResponse(respBytes, &respJSON)
     if err != nil {
-        return 0, err
+        return nil, err
+    }
+    candles := make([]Candle, 0, len(respJSON.Data))
+    for _, kline := range respJSON.Data {
+        // timestamp, open, high, low, close, volume
+        if len(kline) < 6 {
+            return nil, fmt.Errorf("%s - malformed kline %v", client.GetName(), kline)
+        }
+        candles = append(candles, Candle{
+            Time:   time.Unix(0, kline[0].IntPart()*int64(time.Millisecond)),
+            Open:   kline[1],
+            High:   kline[2],
+            Low:    kline[3],
+            Close:  kline[4],
+            Volume: kline[5],
+        })
     }
-    logrus.Debugf("%s - Kline for %s*%v uses price at %s", client.GetName(), period, size,
-        time.Unix(int64(respJSON.Data[0][0])/1000, 0))
-    return respJSON.Data[0][1], nil
+    return candles, nil
 }
 
-func (client *zbClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
-    respBytes, err := client.Get(zbBaseApi+"ticker", http.WithQuery(map[string]string{"market": strings.ToLower(symbol)}))
+func (client *zbClient) GetSymbolPrice(ctx context.Context, symbol string) (*SymbolPrice, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"ticker", http.WithQuery(map[string]string{"market": strings.ToLower(symbol)}))
     if err != nil {
         return nil, err
     }
@@ -110,32 +153,56 @@ func (client *zbClient) GetSymbolPrice(symbol string) (*SymbolPrice, error) {
         return nil, err
     }
 
-    var percentChange1h, percentChange24h = math.MaxFloat64, math.MaxFloat64
-    price1hAgo, err := client.GetKlinePrice(symbol, "1min", 60)
-    if err != nil {
-        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err)
-    } else if price1hAgo != 0 {
-        percentChange1h = (respJSON.Ticker.Last - price1hAgo) / price1hAgo * 100
+    now := client.Now()
+    price1hAgo, err1h := priceAgo(ctx, client, symbol, now, time.Hour)
+    if err1h != nil {
+        logrus.Warnf("%s - Failed to get price 1 hour ago, error: %v\n", client.GetName(), err1h)
     }
 
-    time.Sleep(time.Second)                                       // ZB limits 1 req/sec for Kline
-    price24hAgo, err := client.GetKlinePrice(symbol, "3min", 489) // Why not 480?
-    if err != nil {
-        logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err)
-    } else if price24hAgo != 0 {
-        percentChange24h = (respJSON.Ticker.Last - price24hAgo) / price24hAgo * 100
+    price24hAgo, err24h := priceAgo(ctx, client, symbol, now, 24*time.Hour)
+    if err24h != nil {
+        logrus.Warnf("%s - Failed to get price 24 hours ago, error: %v\n", client.GetName(), err24h)
     }
 
     return &SymbolPrice{
         Symbol:           symbol,
-        Price:            strconv.FormatFloat(respJSON.Ticker.Last, 'f', -1, 64),
+        Price:            respJSON.Ticker.Last,
         UpdateAt:         time.Unix(respJSON.Date/1000, 0),
         Source:           client.GetName(),
-        PercentChange1h:  percentChange1h,
-        PercentChange24h: percentChange24h,
+        Price1hAgo:       price1hAgo,
+        Price24hAgo:      price24hAgo,
+        PercentChange1h:  changeSince(respJSON.Ticker.Last, price1hAgo, err1h),
+        PercentChange24h: changeSince(respJSON.Ticker.Last, price24hAgo, err24h),
+        Volume24h:        respJSON.Ticker.Vol,
+        High24h:          respJSON.Ticker.High,
+        Low24h:           respJSON.Ticker.Low,
+        Bid:              respJSON.Ticker.Buy,
+        Ask:              respJSON.Ticker.Sell,
+        QuoteCurrency:    quoteCurrency(symbol, "_", false),
     }, nil
 }
 
+func (client *zbClient) ListSymbols(ctx context.Context) ([]Market, error) {
+    respBytes, err := client.Get(ctx, client.baseApi+"markets")
+    if err != nil {
+        return nil, err
+    }
+
+    // Keyed by market, eg. btc_usdt, values are precisions
+    var respJSON map[string]json.RawMessage
+    if err := json.Unmarshal(respBytes, &respJSON); err != nil {
+        return nil, err
+    }
+    if msg, ok := respJSON["error"]; ok {
+        return nil, fmt.Errorf("zb get markets: %s", msg)
+    }
+    markets := make([]Market, 0, len(respJSON))
+    for name := range respJSON {
+        markets = append(markets, marketOf(name, "_", false))
+    }
+    return markets, nil
+}
+
 func init() {
     Register(NewZBClient)
 }
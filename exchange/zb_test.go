@@ -1,45 +1,73 @@
 package exchange
 
 import (
+    "context"
     "testing"
     "time"
 )
 
 func TestZBClient(t *testing.T) {
 
-    var client = registry.getClient("zb").(*zbClient)
+    var client = newFixtureClient(t, "zb", map[string]fixture{
+        "/data/v1/ticker?market=zb_qc":                   {file: "ticker.json"},
+        "/data/v1/ticker?market=abc123":                  {file: "invalid_market.json"},
+        "/data/v1/kline?market=zb_qc&type=1min&size=62":  {file: "kline_1min.json"},
+        "/data/v1/kline?market=zb_qc&type=30min&size=50": {file: "kline_30min.json"},
+        "/data/v1/kline?market=abcedfg":                  {file: "invalid_market.json"},
+        "/data/v1/markets":                               {file: "markets.json"},
+    }).(*zbClient)
 
-    t.Run("GetKlinePrice", func(t *testing.T) {
-        _, err := client.GetKlinePrice("bTC_usdt", "1min", 60)
+    t.Run("GetCandles", func(t *testing.T) {
+        candles, err := client.GetCandles(context.Background(), "ZB_qc", time.Minute, fixtureTime.Add(-61*time.Minute), fixtureTime)
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
+        if len(candles) != 2 || !candles[0].Time.Equal(fixtureTime.Add(-time.Hour)) {
+            t.Fatalf("Unexpected candles %v", candles)
+        }
+        assertPrice(t, "Open", candles[0].Open, "1.2")
+        assertPrice(t, "Close", candles[0].Close, "1.205")
+        assertPrice(t, "Volume", candles[0].Volume, "1234.5")
     })
 
-    t.Run("GetKlinePrice of unknown symbol", func(t *testing.T) {
-        time.Sleep(time.Second * 1)
-        _, err := client.GetKlinePrice("abcedfg", "1min", 60)
+    t.Run("GetCandles of unknown symbol", func(t *testing.T) {
+        _, err := client.GetCandles(context.Background(), "abcedfg", time.Minute, fixtureTime.Add(-61*time.Minute), fixtureTime)
 
         if err == nil {
             t.Fatalf("Expecting error when fetching unknown price, but get nil")
         }
-        t.Logf("Returned error is `%v`, expected?", err)
+        if err.Error() != "Invalid market" {
+            t.Fatalf("Expecting error message from response, got %v", err)
+        }
     })
 
     t.Run("GetSymbolPrice", func(t *testing.T) {
-        sp, err := client.GetSymbolPrice("bTC_usdt")
+        sp, err := client.GetSymbolPrice(context.Background(), "ZB_qc")
 
         if err != nil {
             t.Fatalf("Unexpected error: %v", err)
         }
-        if sp.Price == "" {
-            t.Fatalf("Get an empty price?")
+        assertPrice(t, "Price", sp.Price, "1.2345")
+        assertPercentChange(t, "PercentChange1h", sp.PercentChange1h, (1.2345-1.2)/1.2*100)
+        assertPercentChange(t, "PercentChange24h", sp.PercentChange24h, (1.2345-1.5)/1.5*100)
+        assertStatistics(t, sp, "123456.78", "1.3", "1.1", "1.2344", "1.2346")
+        if sp.QuoteCurrency != "QC" {
+            t.Fatalf("Expecting quote currency %q, got %q", "QC", sp.QuoteCurrency)
+        }
+        if !sp.UpdateAt.Equal(time.Unix(1639555199, 0)) {
+            t.Fatalf("Unexpected UpdateAt %v", sp.UpdateAt)
         }
     })
 
+    t.Run("ListSymbols", func(t *testing.T) {
+        markets, err := client.ListSymbols(context.Background())
+
+        assertMarkets(t, markets, err, Market{"zb_qc", "ZB", "QC"})
+    })
+
     t.Run("GetUnexistSymbolPrice", func(t *testing.T) {
-        _, err := client.GetSymbolPrice("ABC123")
+        _, err := client.GetSymbolPrice(context.Background(), "ABC123")
 
         if err == nil {
             t.Fatalf("Should throws on invalid symbol")
@@ -4,13 +4,17 @@ go 1.16
 
 require (
 	github.com/fatih/color v1.13.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/gosuri/uilive v0.0.4
 	github.com/mattn/go-colorable v0.1.12
 	github.com/mattn/go-runewidth v0.0.8 // indirect
+	github.com/mitchellh/mapstructure v1.4.3
 	github.com/olekukonko/tablewriter v0.0.0-20180506121414-d4647c9c7a84
-	github.com/preichenberger/go-coinbasepro/v2 v2.1.0
+	github.com/shopspring/decimal v1.3.1
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.10.0
 	github.com/tidwall/gjson v1.12.1
+	go.etcd.io/bbolt v1.3.6
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/polyrabbit/my-token/history"
+    "github.com/polyrabbit/my-token/writer"
+    "github.com/spf13/pflag"
+)
+
+var historyCommand = &command{
+    name:    "history",
+    args:    "[Exchange1.Token1 ...]",
+    summary: "Print prices saved in the history file",
+    help:    "Pairs in the config file are printed if none is given, a file being saved to by watch cannot be read until it quits.",
+    flags: func(fs *pflag.FlagSet) {
+        fs.String("since", "24h", "Print prices saved within this window, eg. 30m, 12h, 7d")
+        config.AddColumnFlags(fs)
+        config.AddOutputFlag(fs)
+        config.AddHistoryFlag(fs, "Database file prices are saved to")
+        config.AddConfigFlags(fs)
+    },
+    run: runHistory,
+}
+
+// Saved prices are rendered as if they were just fetched, oldest first
+func runHistory(ctx context.Context, fs *pflag.FlagSet) error {
+    since, _ := fs.GetString("since")
+    window, err := config.ParseWindow(since)
+    if err != nil {
+        return fmt.Errorf("invalid since: %w", err)
+    }
+    cfg, err := config.Load(fs)
+    if err != nil {
+        return err
+    }
+    if err := cfg.SetQueries(fs.Args()); err != nil {
+        return err
+    }
+    if cfg.History.Path == "" {
+        return errors.New("specify the history file with --history-file, or history.path in the config file")
+    }
+    if len(cfg.Queries) == 0 {
+        return fmt.Errorf("specify symbols to print, eg. %s history binance.BTCUSDT --since 7d", program)
+    }
+    store, err := history.Open(cfg.History)
+    if err != nil {
+        return fmt.Errorf("failed to open history %s: %w", cfg.History.Path, err)
+    }
+    defer store.Close()
+
+    now := time.Now()
+    var results []*exchange.SymbolResult
+    for _, query := range cfg.Queries {
+        for _, symbol := range query.Symbols() {
+            records, err := store.Query(query.Name, symbol, now.Add(-window), now)
+            if err != nil {
+                return err
+            }
+            for _, record := range records {
+                results = append(results, &exchange.SymbolResult{Symbol: symbol, Source: query.Name, Price: record.SymbolPrice()})
+            }
+        }
+    }
+    priceWriter, err := writer.New(cfg)
+    if err != nil {
+        return err
+    }
+    if err := priceWriter.Start(); err != nil {
+        return fmt.Errorf("failed to start %s writer: %w", cfg.Output, err)
+    }
+    priceWriter.Render(results)
+    return priceWriter.Stop()
+}
@@ -0,0 +1,250 @@
+// Package history saves every fetched price to a local database file, so prices in the past can be looked up
+// without asking exchanges for klines
+package history
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/shopspring/decimal"
+    bolt "go.etcd.io/bbolt"
+)
+
+// Old prices are deleted at most this often
+const pruneInterval = time.Hour
+
+// Prices are kept in a bucket per symbol, nested in this one
+var pricesBucket = []byte("prices")
+
+// Record is a saved price of a symbol, changes are nil if unknown
+type Record struct {
+    Source           string              `json:"-"` // Known from the bucket
+    Symbol           string              `json:"-"`
+    Time             time.Time           `json:"-"` // Known from the key, which is the update time of the price
+    Price            decimal.Decimal     `json:"price"`
+    QuoteCurrency    string              `json:"quote,omitempty"`
+    Volume24h        decimal.NullDecimal `json:"volume_24h"`
+    PercentChange1h  *float64            `json:"percent_change_1h"`
+    PercentChange24h *float64            `json:"percent_change_24h"`
+}
+
+func recordOf(sp *exchange.SymbolPrice) Record {
+    r := Record{Source: sp.Source, Symbol: sp.Symbol, Time: sp.UpdateAt, Price: sp.Price, QuoteCurrency: sp.QuoteCurrency, Volume24h: sp.Volume24h}
+    if sp.PercentChange1h.Known {
+        r.PercentChange1h = &sp.PercentChange1h.Percent
+    }
+    if sp.PercentChange24h.Known {
+        r.PercentChange24h = &sp.PercentChange24h.Percent
+    }
+    return r
+}
+
+// SymbolPrice turns the record back into a price, so it can be rendered by writers
+func (r Record) SymbolPrice() *exchange.SymbolPrice {
+    sp := &exchange.SymbolPrice{Symbol: r.Symbol, Source: r.Source, Price: r.Price, UpdateAt: r.Time, QuoteCurrency: r.QuoteCurrency, Volume24h: r.Volume24h}
+    if r.PercentChange1h != nil {
+        sp.PercentChange1h = exchange.KnownChange(*r.PercentChange1h)
+    }
+    if r.PercentChange24h != nil {
+        sp.PercentChange24h = exchange.KnownChange(*r.PercentChange24h)
+    }
+    return sp
+}
+
+// Store is a bbolt database of prices, a file can be opened by one process at a time
+type Store struct {
+    // Clock tells what time it is now, replace it to test retention
+    Clock func() time.Time
+
+    db        *bolt.DB
+    retention time.Duration
+    mu        sync.Mutex
+    prunedAt  time.Time
+    saved     map[string]time.Time // Update time of the last saved price of a symbol, so unchanged ones are skipped
+}
+
+// Open the database file in config, creating it if not exist
+func Open(cfg config.HistoryConfig) (*Store, error) {
+    s := &Store{Clock: time.Now, saved: make(map[string]time.Time)}
+    if cfg.Retention != "" {
+        retention, err := config.ParseWindow(cfg.Retention)
+        if err != nil {
+            return nil, fmt.Errorf("invalid retention: %w", err)
+        }
+        s.retention = retention
+    }
+    db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: time.Second})
+    if errors.Is(err, bolt.ErrTimeout) {
+        return nil, fmt.Errorf("%s is opened by another process", cfg.Path)
+    }
+    if err != nil {
+        return nil, err
+    }
+    s.db = db
+    return s, nil
+}
+
+func (s *Store) Close() error {
+    return s.db.Close()
+}
+
+// Symbols are case-insensitive, as in queries
+func bucketName(source, symbol string) []byte {
+    return []byte(strings.ToUpper(source) + "." + strings.ToUpper(symbol))
+}
+
+// Keys are big-endian nanoseconds, so they sort in time order
+func timeKey(t time.Time) []byte {
+    key := make([]byte, 8)
+    binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+    return key
+}
+
+func keyTime(key []byte) time.Time {
+    return time.Unix(0, int64(binary.BigEndian.Uint64(key)))
+}
+
+// Save prices of results, failed and stale ones are skipped, so are the ones already saved.
+// Prices older than the retention are deleted once in a while.
+func (s *Store) Save(results []*exchange.SymbolResult) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    var records []Record
+    for _, result := range results {
+        if result.Err != nil || result.Stale || result.Price.UpdateAt.IsZero() {
+            continue
+        }
+        // Keyed the same as looked up by the registry, symbols of prices may be normalized by exchanges
+        name := string(bucketName(result.Source, result.Price.Symbol))
+        if s.saved[name].Equal(result.Price.UpdateAt) {
+            continue
+        }
+        s.saved[name] = result.Price.UpdateAt
+        record := recordOf(result.Price)
+        record.Source = result.Source
+        records = append(records, record)
+    }
+    if len(records) > 0 {
+        err := s.db.Update(func(tx *bolt.Tx) error {
+            prices, err := tx.CreateBucketIfNotExists(pricesBucket)
+            if err != nil {
+                return err
+            }
+            for _, record := range records {
+                bucket, err := prices.CreateBucketIfNotExists(bucketName(record.Source, record.Symbol))
+                if err != nil {
+                    return err
+                }
+                value, err := json.Marshal(record)
+                if err != nil {
+                    return err
+                }
+                if err := bucket.Put(timeKey(record.Time), value); err != nil {
+                    return err
+                }
+            }
+            return nil
+        })
+        if err != nil {
+            return err
+        }
+    }
+    if now := s.Clock(); s.retention > 0 && now.Sub(s.prunedAt) >= pruneInterval {
+        s.prunedAt = now
+        return s.Prune(now.Add(-s.retention))
+    }
+    return nil
+}
+
+// Prune deletes prices updated before the given time
+func (s *Store) Prune(before time.Time) error {
+    return s.db.Update(func(tx *bolt.Tx) error {
+        prices := tx.Bucket(pricesBucket)
+        if prices == nil {
+            return nil
+        }
+        end := timeKey(before)
+        return prices.ForEach(func(name, _ []byte) error {
+            c := prices.Bucket(name).Cursor()
+            for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.First() {
+                if err := c.Delete(); err != nil {
+                    return err
+                }
+            }
+            return nil
+        })
+    })
+}
+
+// Query returns saved prices of a symbol updated in [from, to], oldest first
+func (s *Store) Query(source, symbol string, from, to time.Time) ([]Record, error) {
+    var records []Record
+    err := s.db.View(func(tx *bolt.Tx) error {
+        bucket := symbolBucket(tx, source, symbol)
+        if bucket == nil {
+            return nil
+        }
+        c, end := bucket.Cursor(), timeKey(to)
+        for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k, end) <= 0; k, v = c.Next() {
+            record, err := decodeRecord(source, symbol, k, v)
+            if err != nil {
+                return err
+            }
+            records = append(records, record)
+        }
+        return nil
+    })
+    return records, err
+}
+
+// PriceAt returns the price of a symbol at the given time, which is the last one saved no later than that,
+// and no earlier than tolerance before that
+func (s *Store) PriceAt(source, symbol string, at time.Time, tolerance time.Duration) (decimal.Decimal, error) {
+    var price decimal.Decimal
+    err := s.db.View(func(tx *bolt.Tx) error {
+        notFound := fmt.Errorf("no price of %s saved within %s before %v", symbol, tolerance, at.Local())
+        bucket := symbolBucket(tx, source, symbol)
+        if bucket == nil {
+            return notFound
+        }
+        c, key := bucket.Cursor(), timeKey(at)
+        k, v := c.Seek(key)
+        if k == nil {
+            k, v = c.Last()
+        } else if !bytes.Equal(k, key) {
+            k, v = c.Prev()
+        }
+        if k == nil || keyTime(k).Before(at.Add(-tolerance)) {
+            return notFound
+        }
+        record, err := decodeRecord(source, symbol, k, v)
+        price = record.Price
+        return err
+    })
+    return price, err
+}
+
+func symbolBucket(tx *bolt.Tx, source, symbol string) *bolt.Bucket {
+    prices := tx.Bucket(pricesBucket)
+    if prices == nil {
+        return nil
+    }
+    return prices.Bucket(bucketName(source, symbol))
+}
+
+func decodeRecord(source, symbol string, k, v []byte) (Record, error) {
+    var record Record
+    if err := json.Unmarshal(v, &record); err != nil {
+        return record, fmt.Errorf("corrupted price of %s at %v: %w", symbol, keyTime(k), err)
+    }
+    record.Source, record.Symbol, record.Time = source, symbol, keyTime(k)
+    return record, nil
+}
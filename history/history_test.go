@@ -0,0 +1,101 @@
+package history
+
+import (
+    "errors"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/shopspring/decimal"
+)
+
+var testTime = time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC)
+
+func openTestStore(t *testing.T, retention string) *Store {
+    s, err := Open(config.HistoryConfig{Path: filepath.Join(t.TempDir(), "history.db"), Retention: retention})
+    if err != nil {
+        t.Fatalf("Failed to open history: %v", err)
+    }
+    t.Cleanup(func() { s.Close() })
+    s.Clock = func() time.Time { return testTime }
+    return s
+}
+
+// A price of BTCUSDT on Binance updated minutes after testTime
+func resultAt(minutes int, price int64) *exchange.SymbolResult {
+    sp := &exchange.SymbolPrice{Symbol: "BTCUSDT", Source: "Binance", Price: decimal.NewFromInt(price),
+        UpdateAt: testTime.Add(time.Duration(minutes) * time.Minute), PercentChange1h: exchange.KnownChange(1.5)}
+    return &exchange.SymbolResult{Symbol: "btcusdt", Source: "Binance", Price: sp}
+}
+
+func TestStore(t *testing.T) {
+    s := openTestStore(t, "")
+    failed := &exchange.SymbolResult{Symbol: "ETHBTC", Source: "Binance", Err: errors.New("timeout")}
+    stale := resultAt(1, 99)
+    stale.Stale = true
+    for _, results := range [][]*exchange.SymbolResult{
+        {resultAt(0, 100), failed},
+        {resultAt(0, 100), stale}, // Saved already
+        {resultAt(10, 110)},
+        {resultAt(20, 120)},
+    } {
+        if err := s.Save(results); err != nil {
+            t.Fatalf("Failed to save: %v", err)
+        }
+    }
+
+    t.Run("query", func(t *testing.T) {
+        records, err := s.Query("BINANCE", "btcusdt", testTime, testTime.Add(10*time.Minute))
+        if err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        if len(records) != 2 || !records[1].Price.Equal(decimal.NewFromInt(110)) || !records[1].Time.Equal(testTime.Add(10*time.Minute)) {
+            t.Fatalf("Expecting 2 prices in range, got %+v", records)
+        }
+        if r := records[0]; r.Symbol != "btcusdt" || *r.PercentChange1h != 1.5 || r.PercentChange24h != nil {
+            t.Fatalf("Unexpected record %+v", r)
+        }
+        if sp := records[1].SymbolPrice(); !sp.PercentChange1h.Known || sp.PercentChange24h.Known || !sp.UpdateAt.Equal(records[1].Time) {
+            t.Fatalf("Unexpected price of record %+v", sp)
+        }
+        if records, _ := s.Query("Binance", "ETHBTC", testTime, testTime.Add(time.Hour)); len(records) != 0 {
+            t.Fatalf("Expecting nothing of failed queries, got %+v", records)
+        }
+    })
+
+    t.Run("price at", func(t *testing.T) {
+        for _, tc := range []struct {
+            minutes int
+            want    int64 // 0 if not found
+        }{{10, 110}, {15, 110}, {19, 110}, {25, 120}, {-1, 0}, {31, 0}} {
+            price, err := s.PriceAt("Binance", "BTCUSDT", testTime.Add(time.Duration(tc.minutes)*time.Minute), 10*time.Minute)
+            if tc.want == 0 {
+                if err == nil {
+                    t.Fatalf("Expecting no price at %d minutes, got %s", tc.minutes, price)
+                }
+                continue
+            }
+            if err != nil || !price.Equal(decimal.NewFromInt(tc.want)) {
+                t.Fatalf("Expecting %d at %d minutes, got %s, error: %v", tc.want, tc.minutes, price, err)
+            }
+        }
+    })
+}
+
+func TestStore_retention(t *testing.T) {
+    s := openTestStore(t, "15m")
+    s.Clock = func() time.Time { return testTime.Add(30 * time.Minute) }
+    if err := s.Save([]*exchange.SymbolResult{resultAt(0, 100), resultAt(20, 120)}); err != nil {
+        t.Fatalf("Failed to save: %v", err)
+    }
+    records, _ := s.Query("Binance", "BTCUSDT", testTime, testTime.Add(time.Hour))
+    if len(records) != 1 || !records[0].Price.Equal(decimal.NewFromInt(120)) {
+        t.Fatalf("Expecting prices older than 15m deleted, got %+v", records)
+    }
+
+    if _, err := Open(config.HistoryConfig{Path: filepath.Join(t.TempDir(), "history.db"), Retention: "forever"}); err == nil {
+        t.Fatalf("Expecting error of invalid retention")
+    }
+}
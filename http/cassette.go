@@ -0,0 +1,219 @@
+package http
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "sync"
+    "time"
+)
+
+// Headers carrying credentials are never written into cassettes, as they are meant to be shared
+var redactedHeaders = []string{"Authorization", "X-CMC_PRO_API_KEY", "CB-ACCESS-KEY", "CB-ACCESS-SIGN", "CB-ACCESS-PASSPHRASE"}
+
+// Interaction is a request and the response it got
+type Interaction struct {
+    Method         string      `json:"method"`
+    URL            string      `json:"url"`
+    RequestHeader  http.Header `json:"request_header"`
+    Status         int         `json:"status"`
+    ResponseHeader http.Header `json:"response_header"`
+    Body           string      `json:"body"`
+}
+
+// Cassette holds all interactions of a recorded session, it's saved as lines of JSON, the first one is the
+// header with RecordedAt, followed by one line per interaction, so recording only appends to the file
+type Cassette struct {
+    RecordedAt   time.Time      `json:"recorded_at"`
+    Interactions []*Interaction `json:"interactions,omitempty"`
+}
+
+// Interactions are read from both the lines following the header, and the header itself,
+// which is where they were kept in cassettes saved as a single JSON object
+func LoadCassette(fpath string) (*Cassette, error) {
+    f, err := os.Open(fpath)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var cassette Cassette
+    decoder := json.NewDecoder(f)
+    if err := decoder.Decode(&cassette); err != nil {
+        return nil, fmt.Errorf("malformed cassette %s: %w", fpath, err)
+    }
+    for {
+        var interaction Interaction
+        if err := decoder.Decode(&interaction); err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, fmt.Errorf("malformed cassette %s: %w", fpath, err)
+        }
+        cassette.Interactions = append(cassette.Interactions, &interaction)
+    }
+    return &cassette, nil
+}
+
+func (c *Cassette) Save(fpath string) error {
+    f, err := os.Create(fpath)
+    if err != nil {
+        return err
+    }
+    encoder := json.NewEncoder(f)
+    if err := encoder.Encode(&Cassette{RecordedAt: c.RecordedAt}); err != nil {
+        f.Close()
+        return err
+    }
+    for _, interaction := range c.Interactions {
+        if err := encoder.Encode(interaction); err != nil {
+            f.Close()
+            return err
+        }
+    }
+    return f.Close()
+}
+
+// recorder sends requests through the next transport and appends every response to a cassette file,
+// each one is a complete line, so an interrupted session still leaves a valid cassette
+type recorder struct {
+    next    http.RoundTripper
+    fpath   string
+    mu      sync.Mutex
+    encoder *json.Encoder
+}
+
+func newRecorder(next http.RoundTripper, fpath string, now time.Time) (*recorder, error) {
+    f, err := os.Create(fpath)
+    if err != nil {
+        return nil, err
+    }
+    encoder := json.NewEncoder(f)
+    if err := encoder.Encode(&Cassette{RecordedAt: now}); err != nil {
+        f.Close()
+        return nil, err
+    }
+    // Left open until the process exits, every line is written right away as the encoder doesn't buffer
+    return &recorder{next: next, fpath: fpath, encoder: encoder}, nil
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+    resp, err := r.next.RoundTrip(req)
+    if err != nil {
+        return resp, err
+    }
+    body, err := ioutil.ReadAll(resp.Body)
+    resp.Body.Close()
+    if err != nil {
+        return nil, err
+    }
+    resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+    reqHeader := req.Header.Clone()
+    for _, h := range redactedHeaders {
+        if reqHeader.Get(h) != "" {
+            reqHeader.Set(h, "REDACTED")
+        }
+    }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    err = r.encoder.Encode(&Interaction{
+        Method:         req.Method,
+        URL:            req.URL.String(),
+        RequestHeader:  reqHeader,
+        Status:         resp.StatusCode,
+        ResponseHeader: resp.Header,
+        Body:           string(body),
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to save cassette %s: %w", r.fpath, err)
+    }
+    return resp, nil
+}
+
+// replayer answers requests from a cassette without touching the network
+type replayer struct {
+    mu       sync.Mutex
+    cassette *Cassette
+    used     map[*Interaction]bool
+}
+
+func newReplayer(cassette *Cassette) *replayer {
+    return &replayer{cassette: cassette, used: make(map[*Interaction]bool)}
+}
+
+// Find the interaction of the request, URLs in a replayed session hardly match exactly as they contain
+// timestamps, so fall back to the one with the same path and most matched query parameters.
+// Interactions are consumed in the recorded order, the last recorded one is reused after all are consumed.
+func (r *replayer) match(req *http.Request) *Interaction {
+    var (
+        best, bestUnused           *Interaction
+        bestScore, bestUnusedScore = -1, -1
+    )
+    for _, interaction := range r.cassette.Interactions {
+        if interaction.Method != req.Method {
+            continue
+        }
+        score := matchScore(interaction.URL, req)
+        if score < 0 {
+            continue
+        }
+        if score >= bestScore {
+            best, bestScore = interaction, score
+        }
+        if !r.used[interaction] && score > bestUnusedScore {
+            bestUnused, bestUnusedScore = interaction, score
+        }
+    }
+    if bestUnused != nil && bestUnusedScore == bestScore {
+        return bestUnused
+    }
+    return best
+}
+
+// Return -1 if paths differ, otherwise the number of matched query parameters, an exact match beats everything
+func matchScore(recordedURL string, req *http.Request) int {
+    if recordedURL == req.URL.String() {
+        return 1 << 16
+    }
+    recorded, err := req.URL.Parse(recordedURL)
+    if err != nil || recorded.Host != req.URL.Host || recorded.Path != req.URL.Path {
+        return -1
+    }
+    score := 0
+    query := req.URL.Query()
+    for k, v := range recorded.Query() {
+        if query.Get(k) == v[0] {
+            score++
+        }
+    }
+    return score
+}
+
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+    r.mu.Lock()
+    interaction := r.match(req)
+    if interaction != nil {
+        r.used[interaction] = true
+    }
+    r.mu.Unlock()
+    if interaction == nil {
+        return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL)
+    }
+
+    return &http.Response{
+        Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+        StatusCode:    interaction.Status,
+        Proto:         "HTTP/1.1",
+        ProtoMajor:    1,
+        ProtoMinor:    1,
+        Header:        interaction.ResponseHeader.Clone(),
+        Body:          ioutil.NopCloser(bytes.NewReader([]byte(interaction.Body))),
+        ContentLength: int64(len(interaction.Body)),
+        Request:       req,
+    }, nil
+}
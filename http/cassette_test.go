@@ -0,0 +1,166 @@
+package http
+
+import (
+    "context"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+)
+
+func newClient(t *testing.T, cfg *config.Config) *Client {
+    client, err := New(cfg)
+    if err != nil {
+        t.Fatalf("Failed to create client: %v", err)
+    }
+    return client
+}
+
+func TestRecordAndReplay(t *testing.T) {
+    requests := 0
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        requests++
+        if req.URL.Path == "/missing" {
+            http.Error(w, `{"msg": "not found"}`, http.StatusNotFound)
+            return
+        }
+        fmt.Fprintf(w, `{"symbol": %q, "request": %d}`, req.URL.Query().Get("symbol"), requests)
+    }))
+    defer server.Close()
+
+    cassetteFile := filepath.Join(t.TempDir(), "cassette.json")
+    recordClient := newClient(t, &config.Config{Record: cassetteFile})
+    for _, rawURL := range []string{
+        server.URL + "/ticker?symbol=BTC&startTime=1000",
+        server.URL + "/ticker?symbol=ETH&startTime=1000",
+        server.URL + "/ticker?symbol=BTC&startTime=2000",
+    } {
+        if _, err := recordClient.Get(context.Background(), rawURL, WithHeader(map[string]string{"X-CMC_PRO_API_KEY": "secret"})); err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+    }
+    if _, err := recordClient.Get(context.Background(), server.URL+"/missing"); err == nil {
+        t.Fatalf("Expecting error on 404")
+    }
+
+    cassette, err := LoadCassette(cassetteFile)
+    if err != nil {
+        t.Fatalf("Failed to load cassette: %v", err)
+    }
+    if len(cassette.Interactions) != 4 {
+        t.Fatalf("Expecting 4 recorded interactions, got %d", len(cassette.Interactions))
+    }
+    if key := cassette.Interactions[0].RequestHeader.Get("X-CMC_PRO_API_KEY"); key != "REDACTED" {
+        t.Fatalf("API key should be redacted, got %q", key)
+    }
+    content, _ := ioutil.ReadFile(cassetteFile)
+    if lines := strings.Count(string(content), "\n"); lines != 5 {
+        t.Fatalf("Expecting a header followed by one line per interaction, got %d lines", lines)
+    }
+
+    server.Close()
+    replayClient := newClient(t, &config.Config{Replay: cassetteFile})
+    if !replayClient.Now().Equal(cassette.RecordedAt) {
+        t.Fatalf("Clock should be frozen at %v, got %v", cassette.RecordedAt, replayClient.Now())
+    }
+
+    t.Run("replay in recorded order", func(t *testing.T) {
+        for i, want := range []string{`{"symbol": "BTC", "request": 1}`, `{"symbol": "BTC", "request": 3}`, `{"symbol": "BTC", "request": 3}`} {
+            body, err := replayClient.Get(context.Background(), server.URL+"/ticker?symbol=BTC&startTime=3000")
+            if err != nil {
+                t.Fatalf("Unexpected error: %v", err)
+            }
+            if string(body) != want {
+                t.Fatalf("#%d: expecting %s, got %s", i, want, body)
+            }
+        }
+    })
+
+    t.Run("replay exact match", func(t *testing.T) {
+        body, err := replayClient.Get(context.Background(), server.URL+"/ticker?symbol=ETH&startTime=1000")
+        if err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        if want := `{"symbol": "ETH", "request": 2}`; string(body) != want {
+            t.Fatalf("Expecting %s, got %s", want, body)
+        }
+    })
+
+    t.Run("replay error response", func(t *testing.T) {
+        _, err := replayClient.Get(context.Background(), server.URL+"/missing")
+        if _, ok := err.(*ResponseError); !ok {
+            t.Fatalf("Expecting a ResponseError, got %v", err)
+        }
+    })
+
+    t.Run("replay unknown request", func(t *testing.T) {
+        if _, err := replayClient.Get(context.Background(), server.URL+"/unknown"); err == nil {
+            t.Fatalf("Expecting error on unrecorded request")
+        }
+    })
+}
+
+func TestReplayClockIsFrozen(t *testing.T) {
+    cassetteFile := filepath.Join(t.TempDir(), "cassette.json")
+    recordedAt := time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC)
+    if err := (&Cassette{RecordedAt: recordedAt}).Save(cassetteFile); err != nil {
+        t.Fatalf("Failed to save cassette: %v", err)
+    }
+    client := newClient(t, &config.Config{Replay: cassetteFile})
+    time.Sleep(10 * time.Millisecond)
+    if !client.Now().Equal(recordedAt) {
+        t.Fatalf("Expecting %v, got %v", recordedAt, client.Now())
+    }
+}
+
+func TestNew_badCassette(t *testing.T) {
+    missing := filepath.Join(t.TempDir(), "missing", "cassette.json")
+    if _, err := New(&config.Config{Replay: missing}); err == nil {
+        t.Fatalf("Expecting error replaying a missing cassette")
+    }
+    if _, err := New(&config.Config{Record: missing}); err == nil {
+        t.Fatalf("Expecting error recording to a missing directory")
+    }
+}
+
+func TestClient_Plain(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        w.Write([]byte(`{}`))
+    }))
+    defer server.Close()
+
+    cassetteFile := filepath.Join(t.TempDir(), "cassette.json")
+    plain := newClient(t, &config.Config{Timeout: 3, Record: cassetteFile}).Plain()
+    if plain.Timeout != 3*time.Second {
+        t.Fatalf("Expecting the timeout kept, got %s", plain.Timeout)
+    }
+    resp, err := plain.Post(server.URL, "application/json", strings.NewReader(`{}`))
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    resp.Body.Close()
+    if cassette, err := LoadCassette(cassetteFile); err != nil || len(cassette.Interactions) != 0 {
+        t.Fatalf("Expecting nothing recorded, got %+v, error %v", cassette, err)
+    }
+}
+
+func TestLoadCassette_singleObject(t *testing.T) {
+    cassetteFile := filepath.Join(t.TempDir(), "cassette.json")
+    content := `{"recorded_at": "2021-12-15T08:00:00Z", "interactions": [{"method": "GET", "url": "https://api.binance.com/api/v3/ping", "status": 200, "body": "{}"}]}`
+    if err := ioutil.WriteFile(cassetteFile, []byte(content), 0644); err != nil {
+        t.Fatalf("Failed to write cassette: %v", err)
+    }
+    cassette, err := LoadCassette(cassetteFile)
+    if err != nil {
+        t.Fatalf("Failed to load cassette: %v", err)
+    }
+    if len(cassette.Interactions) != 1 || cassette.Interactions[0].URL != "https://api.binance.com/api/v3/ping" {
+        t.Fatalf("Expecting the interaction kept in the cassette, got %+v", cassette.Interactions)
+    }
+}
@@ -1,6 +1,8 @@
 package http
 
 import (
+    "context"
+    "fmt"
     "io/ioutil"
     "net/http"
     "net/url"
@@ -12,48 +14,115 @@ import (
 
 type Client struct {
     StdClient *http.Client
+    // Clock tells what time it is now, replace it to replay responses recorded at another time
+    Clock   func() time.Time
+    retrier *retrier
+    limiter *rateLimiter
+    // Responses come from a cassette, no throttling nor websockets then
+    replaying bool
+    proxy     func(*http.Request) (*url.URL, error)
+    plain     *http.Client
 }
 
-func New(cfg *config.Config) *Client {
+func New(cfg *config.Config) (*Client, error) {
     // Thread safe
-    stdClient := http.DefaultClient
+    stdClient := &http.Client{}
     timeout := cfg.Timeout
     if timeout != 0 {
         logrus.Debugf("HTTP request timeout is set to %d seconds", timeout)
-        stdClient = &http.Client{
-            Timeout: time.Duration(timeout) * time.Second,
-        }
+        stdClient.Timeout = time.Duration(timeout) * time.Second
     }
 
+    var transport http.RoundTripper = http.DefaultTransport
+    proxy := http.ProxyFromEnvironment // The same as http.DefaultTransport
     rawProxyURL := cfg.Proxy
     if rawProxyURL != "" {
         proxyURL, err := url.Parse(rawProxyURL)
         if err != nil {
             logrus.Warnf("Failed to parse proxy URL: %s, error: %v, using system proxy", rawProxyURL, err)
         } else {
-            transport := &http.Transport{
-                Proxy: http.ProxyURL(proxyURL),
+            proxy = http.ProxyURL(proxyURL)
+            transport = &http.Transport{
+                Proxy: proxy,
             }
             logrus.Debugf("Using proxy %s", rawProxyURL)
-            stdClient.Transport = transport
         }
     }
-    return &Client{stdClient}
+
+    client := &Client{StdClient: stdClient, Clock: time.Now, retrier: newRetrier(cfg.Retry), limiter: newRateLimiter(), proxy: proxy}
+    client.plain = &http.Client{Timeout: stdClient.Timeout, Transport: transport}
+    if cfg.Replay != "" {
+        cassette, err := LoadCassette(cfg.Replay)
+        if err != nil {
+            return nil, fmt.Errorf("failed to replay: %w", err)
+        }
+        logrus.Infof("Replaying session recorded at %s from %s", cassette.RecordedAt.Local().Format(time.RFC3339), cfg.Replay)
+        // Freeze the clock, so prices are computed exactly as they were recorded
+        client.Clock = func() time.Time { return cassette.RecordedAt }
+        transport = newReplayer(cassette)
+        client.retrier.Count = 0 // Replayed failures fail again
+        client.replaying = true
+    } else if cfg.Record != "" {
+        recorder, err := newRecorder(transport, cfg.Record, client.Now())
+        if err != nil {
+            return nil, fmt.Errorf("failed to record: %w", err)
+        }
+        logrus.Infof("Recording session to %s", cfg.Record)
+        transport = recorder
+    }
+    stdClient.Transport = transport
+    return client, nil
+}
+
+// Plain returns a client of the same timeout and proxy, but never recorded, replayed or throttled,
+// for requests not to exchanges, eg. webhooks
+func (c *Client) Plain() *http.Client {
+    return c.plain
+}
+
+// Now returns the current time according to Clock
+func (c *Client) Now() time.Time {
+    return c.Clock()
 }
 
-func (c *Client) Get(rawURL string, opts ...RequestOption) ([]byte, error) {
+// Get sends a GET request, retrying on transient errors until ctx is done
+func (c *Client) Get(ctx context.Context, rawURL string, opts ...RequestOption) ([]byte, error) {
     option := defaultRequestOptions
     for _, o := range opts {
         o(&option)
     }
-
     rawURL = option.AppendQuery(rawURL)
-    req, err := http.NewRequest("GET", rawURL, nil)
+
+    for retry := 0; ; retry++ {
+        respBytes, err := c.get(ctx, rawURL, &option)
+        if err == nil {
+            return respBytes, nil
+        }
+        delay, ok := c.retrier.backoff(retry, err)
+        if !ok {
+            return respBytes, err
+        }
+        logrus.WithError(err).Debugf("Retry %s in %s (%d/%d)", rawURL, delay, retry+1, c.retrier.Count)
+        timer := time.NewTimer(delay)
+        select {
+        case <-timer.C:
+        case <-ctx.Done():
+            timer.Stop()
+            return respBytes, err
+        }
+    }
+}
+
+func (c *Client) get(ctx context.Context, rawURL string, option *RequestOptions) ([]byte, error) {
+    req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
     if err != nil {
         return nil, err
     }
     option.SetHeader(req.Header)
 
+    if err := c.limiter.wait(ctx, req.URL.Host); err != nil {
+        return nil, err
+    }
     resp, err := c.StdClient.Do(req)
     if err != nil {
         return nil, err
@@ -65,18 +134,24 @@ func (c *Client) Get(rawURL string, opts ...RequestOption) ([]byte, error) {
     }
     if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
         // Most non-200 responses have valid json body
-        return respBytes, &ResponseError{resp.Status, respBytes}
+        return respBytes, &ResponseError{resp.Status, resp.StatusCode, resp.Header, respBytes}
     }
     return respBytes, err
 }
 
 type ResponseError struct {
-    Status string
-    Body   []byte
+    Status     string
+    StatusCode int
+    Header     http.Header
+    Body       []byte
 }
 
 func (e *ResponseError) Error() string {
-    return "HTTP " + e.Status + ", body " + string(e.Body[:200])
+    body := e.Body
+    if len(body) > 200 {
+        body = body[:200]
+    }
+    return "HTTP " + e.Status + ", body " + string(body)
 }
 
 type RequestOption func(*RequestOptions)
@@ -0,0 +1,114 @@
+package http
+
+import (
+    "context"
+    "net/url"
+    "sync"
+    "time"
+
+    "github.com/sirupsen/logrus"
+)
+
+// A token bucket refilled at rate tokens per second, holding at most burst tokens
+type tokenBucket struct {
+    mu     sync.Mutex
+    rate   float64
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+    if burst < 1 {
+        burst = 1
+    }
+    return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Take a token, return how long to wait until it's available. Tokens can go negative,
+// so concurrent callers are queued up instead of being released all at once.
+func (b *tokenBucket) reserve() time.Duration {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    now := time.Now()
+    b.tokens += now.Sub(b.last).Seconds() * b.rate
+    if b.tokens > b.burst {
+        b.tokens = b.burst
+    }
+    b.last = now
+    b.tokens--
+    if b.tokens >= 0 {
+        return 0
+    }
+    return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Give back the token of a reservation not used, so callers queued later are not delayed by it
+func (b *tokenBucket) cancel() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.tokens++
+    if b.tokens > b.burst {
+        b.tokens = b.burst
+    }
+}
+
+// rateLimiter throttles requests per host, it's waited on before sending a request, so the wait
+// is bounded by the caller's context only, not counted in the timeout of the request
+type rateLimiter struct {
+    mu      sync.RWMutex
+    buckets map[string]*tokenBucket
+}
+
+func newRateLimiter() *rateLimiter {
+    return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+func (l *rateLimiter) setLimit(host string, rate float64, burst int) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if rate <= 0 {
+        delete(l.buckets, host)
+        return
+    }
+    l.buckets[host] = newTokenBucket(rate, burst)
+}
+
+// Block until a request to host is allowed, or ctx is done
+func (l *rateLimiter) wait(ctx context.Context, host string) error {
+    l.mu.RLock()
+    bucket := l.buckets[host]
+    l.mu.RUnlock()
+    if bucket == nil {
+        return nil
+    }
+    wait := bucket.reserve()
+    if wait <= 0 {
+        return nil
+    }
+    logrus.Debugf("Throttle request to %s for %s", host, wait)
+    timer := time.NewTimer(wait)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        bucket.cancel()
+        return ctx.Err()
+    }
+}
+
+// SetRateLimit throttles requests to the host of rawURL to rate requests per second, allowing bursts of burst requests,
+// a non-positive rate removes the limit
+func (c *Client) SetRateLimit(rawURL string, rate float64, burst int) {
+    if c.replaying {
+        return // Replayed at full speed
+    }
+    parsedURL, err := url.Parse(rawURL)
+    if err != nil {
+        logrus.Warnf("Failed to parse URL %s to set rate limit, error: %v", rawURL, err)
+        return
+    }
+    logrus.Debugf("Rate limit of %s is set to %v requests per second, burst %d", parsedURL.Host, rate, burst)
+    c.limiter.setLimit(parsedURL.Host, rate, burst)
+}
@@ -0,0 +1,77 @@
+package http
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+)
+
+func TestTokenBucket(t *testing.T) {
+    bucket := newTokenBucket(10, 2)
+    for i, want := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+        wait := bucket.reserve()
+        if wait < want-10*time.Millisecond || wait > want+10*time.Millisecond {
+            t.Fatalf("#%d: expecting to wait %s, got %s", i, want, wait)
+        }
+    }
+
+    bucket.cancel()
+    if wait := bucket.reserve(); wait < 190*time.Millisecond || wait > 210*time.Millisecond {
+        t.Fatalf("Expecting the cancelled token given back, got a wait of %s", wait)
+    }
+}
+
+func TestSetRateLimit(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        w.Write([]byte(`{}`))
+    }))
+    defer server.Close()
+
+    client := newClient(t, &config.Config{})
+    client.StdClient.Timeout = 100 * time.Millisecond // Waiting for the limit doesn't count
+    client.SetRateLimit(server.URL+"/api/", 20, 1)
+
+    start := time.Now()
+    var wg sync.WaitGroup
+    for i := 0; i < 5; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if _, err := client.Get(context.Background(), server.URL+"/api/ticker"); err != nil {
+                t.Errorf("Unexpected error: %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+    // The first one goes immediately, the other 4 wait 50ms one after another
+    if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+        t.Fatalf("Requests are not throttled, 5 requests took %s", elapsed)
+    }
+
+    t.Run("cancel while waiting", func(t *testing.T) {
+        client.SetRateLimit(server.URL, 0.1, 1)
+        client.Get(context.Background(), server.URL)
+
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+        defer cancel()
+        if _, err := client.Get(ctx, server.URL); err != context.DeadlineExceeded {
+            t.Fatalf("Expecting deadline exceeded when context is done, got %v", err)
+        }
+    })
+
+    t.Run("remove limit", func(t *testing.T) {
+        client.SetRateLimit(server.URL, -1, 0)
+        start := time.Now()
+        for i := 0; i < 5; i++ {
+            client.Get(context.Background(), server.URL)
+        }
+        if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+            t.Fatalf("Requests should not be throttled, took %s", elapsed)
+        }
+    })
+}
@@ -0,0 +1,83 @@
+package http
+
+import (
+    "errors"
+    "io"
+    "math/rand"
+    "net"
+    "net/http"
+    "strconv"
+    "sync"
+    "syscall"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+)
+
+type retrier struct {
+    config.RetryConfig
+    mu   sync.Mutex
+    rand *rand.Rand
+}
+
+func newRetrier(cfg config.RetryConfig) *retrier {
+    return &retrier{RetryConfig: cfg, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Only transient errors are worth a retry, others will fail again
+func isRetryable(err error) bool {
+    var respErr *ResponseError
+    if errors.As(err, &respErr) {
+        return respErr.StatusCode == http.StatusTooManyRequests || respErr.StatusCode >= 500
+    }
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() {
+        return true
+    }
+    return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// Parse Retry-After header, in either delay-seconds or HTTP-date form, return 0 if absent
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+    value := header.Get("Retry-After")
+    if value == "" {
+        return 0
+    }
+    if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+        return time.Duration(seconds) * time.Second
+    }
+    if date, err := http.ParseTime(value); err == nil && date.After(now) {
+        return date.Sub(now)
+    }
+    return 0
+}
+
+// Return how long to wait before the given retry (starting from 0), and false if we should give up
+func (r *retrier) backoff(retry int, err error) (time.Duration, bool) {
+    if retry >= r.Count || !isRetryable(err) {
+        return 0, false
+    }
+
+    var respErr *ResponseError
+    if errors.As(err, &respErr) {
+        if wait := parseRetryAfter(respErr.Header, time.Now()); wait > 0 {
+            // The server tells us when to come back, no point in retrying earlier, nor waiting that long
+            return wait, r.MaxDelay == 0 || wait <= r.MaxDelay
+        }
+    }
+
+    delay := r.BaseDelay
+    for i := 0; i < retry && (r.MaxDelay == 0 || delay < r.MaxDelay); i++ {
+        delay *= 2
+    }
+    if r.Jitter > 0 {
+        r.mu.Lock()
+        spread := 1 + r.Jitter*(2*r.rand.Float64()-1)
+        r.mu.Unlock()
+        delay = time.Duration(float64(delay) * spread)
+    }
+    if r.MaxDelay > 0 && delay > r.MaxDelay {
+        delay = r.MaxDelay
+    }
+    return delay, true
+}
@@ -0,0 +1,132 @@
+package http
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+)
+
+func TestGetWithRetry(t *testing.T) {
+    cfg := &config.Config{Retry: config.RetryConfig{Count: 2, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, Jitter: 0.5}}
+
+    newServer := func(statuses ...int) (*httptest.Server, *int) {
+        attempts := new(int)
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+            status := statuses[len(statuses)-1]
+            if *attempts < len(statuses) {
+                status = statuses[*attempts]
+            }
+            *attempts++
+            if status == http.StatusTooManyRequests {
+                w.Header().Set("Retry-After", "60")
+            }
+            w.WriteHeader(status)
+            w.Write([]byte(`{}`))
+        }))
+        t.Cleanup(server.Close)
+        return server, attempts
+    }
+
+    t.Run("retry on 5xx", func(t *testing.T) {
+        server, attempts := newServer(503, 502, 200)
+        if _, err := newClient(t, cfg).Get(context.Background(), server.URL); err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        if *attempts != 3 {
+            t.Fatalf("Expecting 3 attempts, got %d", *attempts)
+        }
+    })
+
+    t.Run("give up after max retries", func(t *testing.T) {
+        server, attempts := newServer(500)
+        if _, err := newClient(t, cfg).Get(context.Background(), server.URL); err == nil {
+            t.Fatalf("Expecting error after retries")
+        }
+        if *attempts != 3 {
+            t.Fatalf("Expecting 3 attempts, got %d", *attempts)
+        }
+    })
+
+    t.Run("no retry on 4xx", func(t *testing.T) {
+        server, attempts := newServer(400)
+        if _, err := newClient(t, cfg).Get(context.Background(), server.URL); err == nil {
+            t.Fatalf("Expecting error on 400")
+        }
+        if *attempts != 1 {
+            t.Fatalf("Expecting 1 attempt, got %d", *attempts)
+        }
+    })
+
+    t.Run("no retry if Retry-After exceeds max delay", func(t *testing.T) {
+        server, attempts := newServer(429)
+        if _, err := newClient(t, cfg).Get(context.Background(), server.URL); err == nil {
+            t.Fatalf("Expecting error on 429")
+        }
+        if *attempts != 1 {
+            t.Fatalf("Expecting 1 attempt, got %d", *attempts)
+        }
+    })
+
+    t.Run("stop retrying when context is done", func(t *testing.T) {
+        server, attempts := newServer(500)
+        slowCfg := *cfg
+        slowCfg.Retry.BaseDelay, slowCfg.Retry.MaxDelay = time.Minute, time.Minute
+        ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+        defer cancel()
+        start := time.Now()
+        if _, err := newClient(t, &slowCfg).Get(ctx, server.URL); err == nil {
+            t.Fatalf("Expecting error on 500")
+        }
+        if elapsed := time.Since(start); elapsed > 5*time.Second {
+            t.Fatalf("Expecting to return once context is done, took %s", elapsed)
+        }
+        if *attempts != 1 {
+            t.Fatalf("Expecting 1 attempt, got %d", *attempts)
+        }
+    })
+}
+
+func TestBackoff(t *testing.T) {
+    r := newRetrier(config.RetryConfig{Count: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.2})
+    err := &ResponseError{StatusCode: 503, Header: http.Header{}}
+
+    for retry, want := range []time.Duration{100, 200, 400, 800, 1000} {
+        want *= time.Millisecond
+        delay, ok := r.backoff(retry, err)
+        if !ok {
+            t.Fatalf("Retry #%d should be allowed", retry)
+        }
+        if delay < want*8/10 || delay > want*12/10 || delay > time.Second {
+            t.Fatalf("Retry #%d: expecting about %s, got %s", retry, want, delay)
+        }
+    }
+    if _, ok := r.backoff(5, err); ok {
+        t.Fatalf("Should give up after 5 retries")
+    }
+
+    err.Header.Set("Retry-After", "1")
+    if delay, ok := r.backoff(0, err); !ok || delay != time.Second {
+        t.Fatalf("Should honor Retry-After, got %s", delay)
+    }
+}
+
+func TestParseRetryAfter(t *testing.T) {
+    now := time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC)
+    for value, want := range map[string]time.Duration{
+        "":                              0,
+        "3":                             3 * time.Second,
+        "Wed, 15 Dec 2021 08:00:30 GMT": 30 * time.Second,
+        "Wed, 15 Dec 2021 07:00:00 GMT": 0,
+        "soon":                          0,
+    } {
+        header := http.Header{}
+        header.Set("Retry-After", value)
+        if got := parseRetryAfter(header, now); got != want {
+            t.Errorf("Retry-After %q: expecting %s, got %s", value, want, got)
+        }
+    }
+}
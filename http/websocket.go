@@ -0,0 +1,29 @@
+package http
+
+import (
+    "context"
+    "errors"
+    "net/http"
+
+    "github.com/gorilla/websocket"
+)
+
+// DialWebsocket connects to a websocket through the same proxy as HTTP requests,
+// the connection is closed once ctx is done, so blocked reads return
+func (c *Client) DialWebsocket(ctx context.Context, rawURL string) (*websocket.Conn, error) {
+    if c.replaying {
+        return nil, errors.New("websocket is not supported when replaying")
+    }
+    dialer := websocket.Dialer{Proxy: c.proxy, HandshakeTimeout: c.StdClient.Timeout}
+    header := http.Header{}
+    defaultRequestOptions.SetHeader(header)
+    conn, _, err := dialer.DialContext(ctx, rawURL, header)
+    if err != nil {
+        return nil, err
+    }
+    go func() {
+        <-ctx.Done()
+        conn.Close()
+    }()
+    return conn, nil
+}
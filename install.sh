@@ -56,7 +56,7 @@ execute() {
   fi
   install "${srcdir}/${binexe}" "${BINDIR}/"
   log_info "installed to ${BINDIR}/${binexe}"
-  log_info "run \"${BINDIR}/${binexe} --example-config-file\" to generate an example config file"
+  log_info "run \"${BINDIR}/${binexe} config init\" to generate an example config file"
 }
 is_supported_platform() {
   platform=$1
@@ -1,24 +1,104 @@
 package main
 
 import (
+    "context"
     "encoding/json"
+    "errors"
+    "fmt"
     "os"
+    "os/signal"
+    "path/filepath"
     "strings"
-    "time"
+    "syscall"
 
     "github.com/fatih/color"
     "github.com/mattn/go-colorable"
     "github.com/polyrabbit/my-token/config"
-    "github.com/polyrabbit/my-token/exchange"
     "github.com/polyrabbit/my-token/http"
-    "github.com/polyrabbit/my-token/writer"
     "github.com/sirupsen/logrus"
-    "github.com/spf13/viper"
+    "github.com/spf13/pflag"
 )
 
-func checkForUpdate(httpClient *http.Client) {
+// Name of the executable in usages, eg. mt
+var program = filepath.Base(os.Args[0])
+
+// A subcommand, eg. "mt ohlc binance.BTCUSDT", flags are parsed before running it
+type command struct {
+    name    string
+    args    string // Arguments in usage, eg. "[Exchange1.Token1 ...]"
+    summary string
+    help    string // Printed after options, optional
+    flags   func(fs *pflag.FlagSet)
+    run     func(ctx context.Context, fs *pflag.FlagSet) error
+}
+
+// Commands in the order of the usage, the first one runs if none is given, so "mt binance.BTCUSDT" still works
+var commands []*command
+
+func init() {
+    commands = []*command{watchCommand, ohlcCommand, historyCommand, exchangesCommand, symbolsCommand, configCommand, versionCommand, helpCommand}
+}
+
+func lookupCommand(name string) *command {
+    for _, cmd := range commands {
+        if cmd.name == name {
+            return cmd
+        }
+    }
+    return nil
+}
+
+func printUsage() {
+    fmt.Fprintf(os.Stderr, "\nUsage: %s [command] [Options] [arguments]\n", program)
+    fmt.Fprintln(os.Stderr, "\nTrack token prices of your favorite exchanges in the terminal")
+    fmt.Fprintln(os.Stderr, "\nCommands:")
+    for _, cmd := range commands {
+        fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+    }
+    fmt.Fprintf(os.Stderr, "\nRun \"%s help <command>\" for options of a command, "+
+        "watch is run if none is given, eg. \"%s binance.BTCUSDT\"\n", program, program)
+    fmt.Fprintln(os.Stderr, "\nFind help/updates from here - https://github.com/polyrabbit/my-token")
+}
+
+func printCommandUsage(cmd *command, fs *pflag.FlagSet) {
+    fmt.Fprintf(os.Stderr, "\nUsage: %s %s [Options] %s\n", program, cmd.name, cmd.args)
+    fmt.Fprintf(os.Stderr, "\n%s\n", cmd.summary)
+    if fs.HasAvailableFlags() {
+        fmt.Fprintln(os.Stderr, "\nOptions:")
+        fmt.Fprint(os.Stderr, fs.FlagUsages())
+    }
+    if cmd.help != "" {
+        fmt.Fprintf(os.Stderr, "\n%s\n", cmd.help)
+    }
+}
+
+// Run the command in args, flags of it are parsed here, so usage errors are reported the same way
+func run(ctx context.Context, args []string) error {
+    if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+        printUsage()
+        return nil
+    }
+    cmd := commands[0]
+    if len(args) > 0 && lookupCommand(args[0]) != nil {
+        cmd, args = lookupCommand(args[0]), args[1:]
+    }
+    fs := config.NewFlagSet(cmd.name)
+    if cmd.flags != nil {
+        cmd.flags(fs)
+    }
+    if err := fs.Parse(args); err != nil {
+        if errors.Is(err, pflag.ErrHelp) {
+            printCommandUsage(cmd, fs)
+            return nil
+        }
+        return fmt.Errorf("%v, see '%s help %s'", err, program, cmd.name)
+    }
+    return cmd.run(ctx, fs)
+}
+
+func checkForUpdate(ctx context.Context, httpClient *http.Client) {
     const releaseURL = "https://api.github.com/repos/polyrabbit/my-token/releases/latest"
-    respBytes, err := httpClient.Get(releaseURL)
+    respBytes, err := httpClient.Get(ctx, releaseURL)
     if err != nil {
         logrus.Debugf("Failed to fetch Github release page, error %v", err)
         return
@@ -42,29 +122,19 @@ func checkForUpdate(httpClient *http.Client) {
 }
 
 func main() {
-    cfg := config.Parse()
-    httpClient := http.New(cfg)
-    registry := exchange.NewRegistry(cfg, httpClient)
-    if viper.GetBool("list-exchanges") {
-        config.ListExchangesAndExit(registry.GetAllNames())
-    }
-    go checkForUpdate(httpClient)
-
-    if cfg.Refresh != 0 {
-        logrus.Infof("Auto refresh on every %d seconds", cfg.Refresh)
-    }
+    // Set log format
+    logrus.SetFormatter(&logrus.TextFormatter{
+        FullTimestamp:   true,
+        TimestampFormat: "15:04:05",
+    })
+    logrus.SetOutput(colorable.NewColorableStderr()) // For Windows
 
-    tableWriter := writer.NewTableWriter(cfg)
-    logrus.SetOutput(tableWriter)
-    defer logrus.SetOutput(colorable.NewColorableStderr())
-
-    for {
-        symbolPriceList := registry.GetSymbolPrices(cfg.Queries)
-        tableWriter.Render(symbolPriceList)
-        if cfg.Refresh == 0 {
-            break
-        }
-        // Use sleep here so I can stall as much as I can to avoid exceeding API limit
-        time.Sleep(time.Duration(cfg.Refresh) * time.Second)
+    // Stop in-flight requests on Ctrl-C, so we can restore the terminal and quit cleanly
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    err := run(ctx, os.Args[1:])
+    stop()
+    if err != nil {
+        logrus.Errorln(err)
+        os.Exit(1)
     }
 }
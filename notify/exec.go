@@ -0,0 +1,67 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "os/exec"
+    "runtime"
+    "strings"
+    "time"
+
+    "github.com/polyrabbit/my-token/alert"
+    "github.com/polyrabbit/my-token/config"
+)
+
+// execNotifier runs a shell command on every alert, which is told in environment variables
+// MT_SOURCE, MT_SYMBOL, MT_PRICE, MT_MESSAGE and MT_TIME, eg. notify-send "$MT_SYMBOL" "$MT_MESSAGE"
+type execNotifier struct {
+    command string
+}
+
+func NewExecNotifier(cfg *config.NotifierConfig, _ *http.Client) (Notifier, error) {
+    if cfg.Command == "" {
+        return nil, errors.New("command is required")
+    }
+    return &execNotifier{command: cfg.Command}, nil
+}
+
+func (n *execNotifier) Name() string {
+    return "exec"
+}
+
+func (n *execNotifier) Notify(ctx context.Context, a alert.Alert) error {
+    var cmd *exec.Cmd
+    if runtime.GOOS == "windows" {
+        cmd = exec.CommandContext(ctx, "cmd", "/C", n.command)
+    } else {
+        cmd = exec.CommandContext(ctx, "sh", "-c", n.command)
+    }
+    price := ""
+    if !a.Price.IsZero() {
+        price = a.Price.String()
+    }
+    cmd.Env = append(os.Environ(),
+        "MT_SOURCE="+a.Source,
+        "MT_SYMBOL="+a.Symbol,
+        "MT_PRICE="+price,
+        "MT_MESSAGE="+a.Message,
+        "MT_TIME="+a.At.Format(time.RFC3339),
+    )
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    if err := cmd.Run(); err != nil {
+        if msg := strings.TrimSpace(stderr.String()); msg != "" {
+            return fmt.Errorf("%w: %s", err, msg)
+        }
+        return err
+    }
+    return nil
+}
+
+func init() {
+    Register("exec", NewExecNotifier)
+}
@@ -0,0 +1,37 @@
+package notify
+
+import (
+    "context"
+    "io/ioutil"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "testing"
+
+    "github.com/polyrabbit/my-token/config"
+)
+
+func TestExecNotifier(t *testing.T) {
+    if runtime.GOOS == "windows" {
+        t.Skip("Commands are written for sh")
+    }
+    out := filepath.Join(t.TempDir(), "alert.txt")
+
+    n, _ := NewExecNotifier(&config.NotifierConfig{Command: `echo "$MT_SOURCE|$MT_SYMBOL|$MT_PRICE|$MT_MESSAGE|$MT_TIME" > ` + out}, nil)
+    if err := n.Notify(context.Background(), testAlert); err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    got, err := ioutil.ReadFile(out)
+    if err != nil {
+        t.Fatalf("Failed to read output of the command: %v", err)
+    }
+    want := `Binance|BTCUSDT|50001.5|BTCUSDT rose above "50000", at 50001.5|2021-12-15T08:00:00Z` + "\n"
+    if string(got) != want {
+        t.Fatalf("Expecting %q, got %q", want, got)
+    }
+
+    n, _ = NewExecNotifier(&config.NotifierConfig{Command: "echo no such receiver >&2; exit 3"}, nil)
+    if err := n.Notify(context.Background(), testAlert); err == nil || !strings.Contains(err.Error(), "exit status 3: no such receiver") {
+        t.Fatalf("Expecting the exit status and stderr in error, got %v", err)
+    }
+}
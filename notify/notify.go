@@ -0,0 +1,86 @@
+// Package notify sends fired alerts out of the terminal, so big moves are not missed when no one is watching
+package notify
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/polyrabbit/my-token/alert"
+    "github.com/polyrabbit/my-token/config"
+    "github.com/sirupsen/logrus"
+)
+
+// Give up on a notifier not done within this long
+const sendTimeout = 30 * time.Second
+
+// Notifier sends an alert somewhere, it should be safe for concurrent use
+type Notifier interface {
+    Name() string
+    Notify(ctx context.Context, a alert.Alert) error
+}
+
+// NotifierProvider creates a notifier of its type, returning an error if required options are missing,
+// httpClient has the timeout and proxy of exchanges
+type NotifierProvider func(cfg *config.NotifierConfig, httpClient *http.Client) (Notifier, error)
+
+var providers = make(map[string]NotifierProvider)
+
+// Register a notifier type, the name is case-insensitive
+func Register(name string, p NotifierProvider) {
+    name = strings.ToLower(name)
+    if _, exist := providers[name]; exist {
+        panic(fmt.Errorf("%q already exists in notifier registry", name))
+    }
+    providers[name] = p
+}
+
+func GetAllNames() []string {
+    names := make([]string, 0, len(providers))
+    for name := range providers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// New creates notifiers in config
+func New(cfgs []*config.NotifierConfig, httpClient *http.Client) ([]Notifier, error) {
+    notifiers := make([]Notifier, 0, len(cfgs))
+    for i, cfg := range cfgs {
+        p, ok := providers[strings.ToLower(cfg.Type)]
+        if !ok {
+            return nil, fmt.Errorf("unknown notifier type %q of notifier #%d, expecting one of %s", cfg.Type, i+1, strings.Join(GetAllNames(), ", "))
+        }
+        n, err := p(cfg, httpClient)
+        if err != nil {
+            return nil, fmt.Errorf("%s notifier #%d: %w", cfg.Type, i+1, err)
+        }
+        notifiers = append(notifiers, n)
+    }
+    return notifiers, nil
+}
+
+// Send every alert through every notifier concurrently, and wait for all of them. Failures are logged,
+// there's no one to return them to.
+func Send(ctx context.Context, notifiers []Notifier, alerts []alert.Alert) {
+    ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+    defer cancel()
+    var wg sync.WaitGroup
+    for _, n := range notifiers {
+        for _, a := range alerts {
+            wg.Add(1)
+            go func(n Notifier, a alert.Alert) {
+                defer wg.Done()
+                if err := n.Notify(ctx, a); err != nil {
+                    logrus.Warnf("Failed to send alert of %s through %s, error: %v", a.Symbol, n.Name(), err)
+                }
+            }(n, a)
+        }
+    }
+    wg.Wait()
+}
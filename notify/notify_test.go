@@ -0,0 +1,76 @@
+package notify
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/polyrabbit/my-token/alert"
+    "github.com/polyrabbit/my-token/config"
+    "github.com/shopspring/decimal"
+)
+
+var testAlert = alert.Alert{
+    Source:  "Binance",
+    Symbol:  "BTCUSDT",
+    Price:   decimal.RequireFromString("50001.5"),
+    Message: `BTCUSDT rose above "50000", at 50001.5`,
+    At:      time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC),
+}
+
+func TestNew(t *testing.T) {
+    notifiers, err := New([]*config.NotifierConfig{{Type: "Webhook", URL: "http://localhost"}, {Type: "exec", Command: "true"}}, http.DefaultClient)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(notifiers) != 2 || notifiers[0].Name() != "webhook" || notifiers[1].Name() != "exec" {
+        t.Fatalf("Unexpected notifiers %v", notifiers)
+    }
+
+    for _, cfg := range []*config.NotifierConfig{
+        {Type: "pager"},
+        {Type: "webhook"},
+        {Type: "webhook", URL: "http://localhost", Body: "{{.Message"},
+        {Type: "exec"},
+        {Type: "smtp", Host: "localhost", From: "mt@localhost"},
+    } {
+        if _, err := New([]*config.NotifierConfig{cfg}, http.DefaultClient); err == nil {
+            t.Fatalf("Expecting error of %+v", cfg)
+        }
+    }
+    _, err = New([]*config.NotifierConfig{{Type: "pager"}}, http.DefaultClient)
+    if !strings.Contains(err.Error(), "exec, smtp, webhook") {
+        t.Fatalf("Expecting supported types in error, got %v", err)
+    }
+}
+
+type fakeNotifier struct {
+    mu   sync.Mutex
+    sent []string
+    err  error
+}
+
+func (n *fakeNotifier) Name() string {
+    return "fake"
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, a alert.Alert) error {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    n.sent = append(n.sent, a.Symbol)
+    return n.err
+}
+
+func TestSend(t *testing.T) {
+    ok, failing := &fakeNotifier{}, &fakeNotifier{err: errors.New("unreachable")}
+    other := testAlert
+    other.Symbol = "ETHUSDT"
+    Send(context.Background(), []Notifier{failing, ok}, []alert.Alert{testAlert, other})
+    if len(ok.sent) != 2 || len(failing.sent) != 2 {
+        t.Fatalf("Expecting every alert sent through every notifier, got %v and %v", ok.sent, failing.sent)
+    }
+}
@@ -0,0 +1,122 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "mime"
+    "net"
+    "net/http"
+    "net/smtp"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/polyrabbit/my-token/alert"
+    "github.com/polyrabbit/my-token/config"
+)
+
+// Submission port with STARTTLS, 465 is also supported with implicit TLS
+const defaultSMTPPort = 587
+
+// smtpNotifier mails alerts, the connection is upgraded with STARTTLS if the server supports it.
+// Credentials are only sent over TLS or to localhost, see smtp.PlainAuth.
+type smtpNotifier struct {
+    host     string
+    port     int
+    username string
+    password string
+    from     string
+    to       []string
+}
+
+func NewSMTPNotifier(cfg *config.NotifierConfig, _ *http.Client) (Notifier, error) {
+    if cfg.Host == "" || cfg.From == "" || len(cfg.To) == 0 {
+        return nil, errors.New("host, from and to are required")
+    }
+    port := cfg.Port
+    if port == 0 {
+        port = defaultSMTPPort
+    }
+    return &smtpNotifier{host: cfg.Host, port: port, username: cfg.Username, password: cfg.Password, from: cfg.From, to: cfg.To}, nil
+}
+
+func (n *smtpNotifier) Name() string {
+    return "smtp"
+}
+
+// net/smtp doesn't take a context, so the connection is dialed here, and given the deadline of ctx in Notify
+func (n *smtpNotifier) dial(ctx context.Context) (net.Conn, error) {
+    addr := net.JoinHostPort(n.host, strconv.Itoa(n.port))
+    if n.port == 465 {
+        return (&tls.Dialer{Config: &tls.Config{ServerName: n.host}}).DialContext(ctx, "tcp", addr)
+    }
+    return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+}
+
+func (n *smtpNotifier) Notify(ctx context.Context, a alert.Alert) error {
+    conn, err := n.dial(ctx)
+    if err != nil {
+        return err
+    }
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
+    c, err := smtp.NewClient(conn, n.host)
+    if err != nil {
+        conn.Close()
+        return err
+    }
+    defer c.Close()
+
+    if ok, _ := c.Extension("STARTTLS"); ok {
+        if err := c.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+            return err
+        }
+    }
+    if n.username != "" {
+        if err := c.Auth(smtp.PlainAuth("", n.username, n.password, n.host)); err != nil {
+            return err
+        }
+    }
+    if err := c.Mail(n.from); err != nil {
+        return err
+    }
+    for _, to := range n.to {
+        if err := c.Rcpt(to); err != nil {
+            return err
+        }
+    }
+    w, err := c.Data()
+    if err != nil {
+        return err
+    }
+    if _, err := w.Write(n.message(a)); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return err
+    }
+    return c.Quit()
+}
+
+func (n *smtpNotifier) message(a alert.Alert) []byte {
+    var buf bytes.Buffer
+    fmt.Fprintf(&buf, "From: %s\r\n", n.from)
+    fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(n.to, ", "))
+    fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[my-token] "+a.Message))
+    fmt.Fprintf(&buf, "Date: %s\r\n", a.At.Format(time.RFC1123Z))
+    buf.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+    fmt.Fprintf(&buf, "%s\r\n\r\nExchange: %s\r\nSymbol: %s\r\n", a.Message, a.Source, a.Symbol)
+    if !a.Price.IsZero() {
+        fmt.Fprintf(&buf, "Price: %s\r\n", a.Price)
+    }
+    fmt.Fprintf(&buf, "Time: %s\r\n", a.At.Local().Format(time.RFC3339))
+    return buf.Bytes()
+}
+
+func init() {
+    Register("smtp", NewSMTPNotifier)
+}
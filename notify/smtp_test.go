@@ -0,0 +1,118 @@
+package notify
+
+import (
+    "bufio"
+    "context"
+    "encoding/base64"
+    "net"
+    "strings"
+    "testing"
+
+    "github.com/polyrabbit/my-token/config"
+)
+
+// A local stand-in of an SMTP server, accepting one mail with AUTH PLAIN and no TLS
+type fakeSMTPServer struct {
+    listener net.Listener
+    commands []string
+    data     string
+    done     chan struct{}
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("Failed to listen: %v", err)
+    }
+    s := &fakeSMTPServer{listener: listener, done: make(chan struct{})}
+    t.Cleanup(func() { listener.Close() })
+    go s.serve()
+    return s
+}
+
+func (s *fakeSMTPServer) port() int {
+    return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve() {
+    defer close(s.done)
+    conn, err := s.listener.Accept()
+    if err != nil {
+        return
+    }
+    defer conn.Close()
+    r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+    reply := func(lines ...string) {
+        for _, line := range lines {
+            w.WriteString(line + "\r\n")
+        }
+        w.Flush()
+    }
+    reply("220 localhost ESMTP")
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return
+        }
+        command := strings.TrimRight(line, "\r\n")
+        s.commands = append(s.commands, command)
+        switch verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0]); verb {
+        case "EHLO":
+            reply("250-localhost", "250 AUTH PLAIN")
+        case "AUTH":
+            reply("235 2.7.0 Authentication successful")
+        case "DATA":
+            reply("354 End data with <CR><LF>.<CR><LF>")
+            var data strings.Builder
+            for {
+                line, err := r.ReadString('\n')
+                if err != nil || line == ".\r\n" {
+                    break
+                }
+                data.WriteString(line)
+            }
+            s.data = data.String()
+            reply("250 2.0.0 Ok: queued")
+        case "QUIT":
+            reply("221 2.0.0 Bye")
+            return
+        default:
+            reply("250 2.1.0 Ok")
+        }
+    }
+}
+
+func TestSMTPNotifier(t *testing.T) {
+    server := newFakeSMTPServer(t)
+    n, err := NewSMTPNotifier(&config.NotifierConfig{
+        Host:     "127.0.0.1",
+        Port:     server.port(),
+        Username: "mt",
+        Password: "secret",
+        From:     "mt@localhost",
+        To:       []string{"me@localhost", "you@localhost"},
+    }, nil)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if err := n.Notify(context.Background(), testAlert); err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    <-server.done
+
+    auth := "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00mt\x00secret"))
+    want := []string{"EHLO localhost", auth, "MAIL FROM:<mt@localhost>", "RCPT TO:<me@localhost>", "RCPT TO:<you@localhost>", "DATA", "QUIT"}
+    if strings.Join(server.commands, "\n") != strings.Join(want, "\n") {
+        t.Fatalf("Expecting commands %q, got %q", want, server.commands)
+    }
+    for _, part := range []string{
+        "To: me@localhost, you@localhost\r\n",
+        "Subject: [my-token] BTCUSDT rose above \"50000\", at 50001.5\r\n",
+        "Date: Wed, 15 Dec 2021 08:00:00 +0000\r\n",
+        "\r\n\r\nBTCUSDT rose above \"50000\", at 50001.5\r\n\r\nExchange: Binance\r\nSymbol: BTCUSDT\r\nPrice: 50001.5\r\n",
+    } {
+        if !strings.Contains(server.data, part) {
+            t.Fatalf("Expecting %q in the mail, got %q", part, server.data)
+        }
+    }
+}
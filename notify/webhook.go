@@ -0,0 +1,84 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "strings"
+    "text/template"
+
+    "github.com/polyrabbit/my-token/alert"
+    "github.com/polyrabbit/my-token/config"
+)
+
+// Works with Slack and Mattermost, which take "text", and anything reading the rest
+const defaultWebhookBody = `{"text":{{json .Message}},"source":{{json .Source}},"symbol":{{json .Symbol}},` +
+    `"price":{{json .Price}},"time":{{json .At}}}`
+
+// webhookNotifier posts alerts to a URL, the body is rendered from a template of the alert,
+// eg. `{"content": {{json .Message}}}` for Discord
+type webhookNotifier struct {
+    client  *http.Client
+    url     string
+    body    *template.Template
+    headers map[string]string
+}
+
+func NewWebhookNotifier(cfg *config.NotifierConfig, httpClient *http.Client) (Notifier, error) {
+    if cfg.URL == "" {
+        return nil, errors.New("url is required")
+    }
+    body := cfg.Body
+    if body == "" {
+        body = defaultWebhookBody
+    }
+    tmpl, err := template.New("body").Funcs(template.FuncMap{"json": toJSON}).Parse(body)
+    if err != nil {
+        return nil, fmt.Errorf("invalid body template: %w", err)
+    }
+    return &webhookNotifier{client: httpClient, url: cfg.URL, body: tmpl, headers: cfg.Headers}, nil
+}
+
+// Quote values in templates, so messages with quotes don't break the JSON
+func toJSON(v interface{}) (string, error) {
+    b, err := json.Marshal(v)
+    return string(b), err
+}
+
+func (n *webhookNotifier) Name() string {
+    return "webhook"
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, a alert.Alert) error {
+    var body bytes.Buffer
+    if err := n.body.Execute(&body, a); err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, &body)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    for key, value := range n.headers {
+        req.Header.Set(key, value)
+    }
+    resp, err := n.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("webhook responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+    }
+    return nil
+}
+
+func init() {
+    Register("webhook", NewWebhookNotifier)
+}
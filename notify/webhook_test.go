@@ -0,0 +1,74 @@
+package notify
+
+import (
+    "context"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/polyrabbit/my-token/config"
+)
+
+// Start a local receiver answering with status, and return what it received
+func newWebhookServer(t *testing.T, status int) (*httptest.Server, *http.Request, *string) {
+    var (
+        received http.Request
+        body     string
+    )
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        b, _ := ioutil.ReadAll(req.Body)
+        received, body = *req, string(b)
+        w.WriteHeader(status)
+        w.Write([]byte("invalid_payload\n"))
+    }))
+    t.Cleanup(server.Close)
+    return server, &received, &body
+}
+
+func TestWebhookNotifier(t *testing.T) {
+    t.Run("default body", func(t *testing.T) {
+        server, req, body := newWebhookServer(t, http.StatusOK)
+        n, _ := NewWebhookNotifier(&config.NotifierConfig{URL: server.URL + "/hooks/xyz"}, server.Client())
+        if err := n.Notify(context.Background(), testAlert); err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        want := `{"text":"BTCUSDT rose above \"50000\", at 50001.5","source":"Binance","symbol":"BTCUSDT",` +
+            `"price":"50001.5","time":"2021-12-15T08:00:00Z"}`
+        if *body != want {
+            t.Fatalf("Expecting body %s, got %s", want, *body)
+        }
+        if req.Method != http.MethodPost || req.URL.Path != "/hooks/xyz" || req.Header.Get("Content-Type") != "application/json" {
+            t.Fatalf("Unexpected request %s %s %v", req.Method, req.URL, req.Header)
+        }
+    })
+
+    t.Run("templated body", func(t *testing.T) {
+        server, req, body := newWebhookServer(t, http.StatusNoContent)
+        n, _ := NewWebhookNotifier(&config.NotifierConfig{
+            URL:     server.URL,
+            Body:    `{"content": {{json .Message}}, "username": "mt {{.Source}}"}`,
+            Headers: map[string]string{"authorization": "Bearer xyz"},
+        }, server.Client())
+        if err := n.Notify(context.Background(), testAlert); err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        want := `{"content": "BTCUSDT rose above \"50000\", at 50001.5", "username": "mt Binance"}`
+        if *body != want {
+            t.Fatalf("Expecting body %s, got %s", want, *body)
+        }
+        if req.Header.Get("Authorization") != "Bearer xyz" {
+            t.Fatalf("Expecting configured headers, got %v", req.Header)
+        }
+    })
+
+    t.Run("rejected", func(t *testing.T) {
+        server, _, _ := newWebhookServer(t, http.StatusBadRequest)
+        n, _ := NewWebhookNotifier(&config.NotifierConfig{URL: server.URL}, server.Client())
+        err := n.Notify(context.Background(), testAlert)
+        if err == nil || !strings.Contains(err.Error(), "400 Bad Request: invalid_payload") {
+            t.Fatalf("Expecting the response in error, got %v", err)
+        }
+    })
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/polyrabbit/my-token/http"
+    "github.com/polyrabbit/my-token/writer"
+    "github.com/sirupsen/logrus"
+    "github.com/spf13/pflag"
+)
+
+var ohlcCommand = &command{
+    name:    "ohlc",
+    args:    "[Exchange1.Token1 ...]",
+    summary: "Print candles of exchanges having klines",
+    help: "Intervals not offered by an exchange are resampled from finer candles, " +
+        "pairs in the config file are printed if none is given.",
+    flags: func(fs *pflag.FlagSet) {
+        config.AddOHLCFlags(fs)
+        config.AddOutputFlag(fs)
+        config.AddConfigFlags(fs)
+        config.AddHTTPFlags(fs)
+    },
+    run: runOHLC,
+}
+
+// Print the last candles of every queried symbol, symbols failed are logged and skipped
+func runOHLC(ctx context.Context, fs *pflag.FlagSet) error {
+    cfg, err := config.Load(fs)
+    if err != nil {
+        return err
+    }
+    if err := cfg.SetQueries(fs.Args()); err != nil {
+        return err
+    }
+    if len(cfg.Queries) == 0 {
+        return fmt.Errorf("specify symbols of candles, eg. %s ohlc binance.BTCUSDT --interval 1h --last 48", program)
+    }
+    interval, _ := config.ParseWindow(cfg.OHLC.Interval) // Validated in loading
+    httpClient, err := http.New(cfg)
+    if err != nil {
+        return err
+    }
+    registry := exchange.NewRegistry(cfg, httpClient)
+    setSymbolsCache(registry)
+
+    var (
+        series []writer.CandleSeries
+        failed int
+        total  int
+    )
+    now := httpClient.Now()
+    for _, query := range cfg.Queries {
+        for _, symbol := range query.Symbols() {
+            total++
+            candles, err := registry.GetCandles(ctx, query.Name, symbol, interval, cfg.OHLC.Last, now)
+            if ctx.Err() != nil {
+                return nil // Interrupted
+            }
+            if err != nil {
+                logrus.Warnf("%s - Failed to get candles of %s, error: %v", query.Name, symbol, err)
+                failed++
+                continue
+            }
+            series = append(series, writer.CandleSeries{Source: query.Name, Symbol: symbol, Interval: cfg.OHLC.Interval, Candles: candles})
+        }
+    }
+    if err := writer.RenderCandles(os.Stdout, cfg.Output, series); err != nil {
+        return err
+    }
+    if failed > 0 {
+        return fmt.Errorf("failed to get candles of %d out of %d symbols", failed, total)
+    }
+    return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "path/filepath"
+    "text/tabwriter"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/polyrabbit/my-token/http"
+    "github.com/sirupsen/logrus"
+    "github.com/spf13/pflag"
+)
+
+var symbolsCommand = &command{
+    name:    "symbols",
+    args:    "<exchange> [filter]",
+    summary: "Search symbols traded on an exchange",
+    help: "Filter:\n" +
+        "  Part of symbols, eg. \"usdt\", or base and quote currencies separated by a slash, either can be left out, " +
+        "eg. \"btc/usdt\", \"eth/\" or \"/usdt\". All markets are printed if not given.\n" +
+        "  Market lists are cached for a day in the user cache directory.",
+    flags: func(fs *pflag.FlagSet) {
+        fs.Bool("update", false, "Fetch the market list again, even if it's cached")
+        config.AddConfigFlags(fs)
+        config.AddHTTPFlags(fs)
+    },
+    run: runSymbols,
+}
+
+// Print matched markets as the symbol followed by base/quote, so they can be pasted into queries
+func runSymbols(ctx context.Context, fs *pflag.FlagSet) error {
+    if fs.NArg() == 0 || fs.NArg() > 2 {
+        return fmt.Errorf("specify an exchange, and optionally a filter, eg. %s symbols binance btc/usdt", program)
+    }
+    update, _ := fs.GetBool("update")
+    cfg, err := config.Load(fs)
+    if err != nil {
+        return err
+    }
+    httpClient, err := http.New(cfg)
+    if err != nil {
+        return err
+    }
+    registry := exchange.NewRegistry(cfg, httpClient)
+    setSymbolsCache(registry)
+
+    markets, err := registry.ListSymbols(ctx, fs.Arg(0), update)
+    if err != nil {
+        return fmt.Errorf("failed to list symbols of %s: %w", fs.Arg(0), err)
+    }
+    matched := exchange.FilterMarkets(markets, fs.Arg(1))
+    if len(matched) == 0 {
+        return fmt.Errorf("none of %d symbols on %s matches '%s'", len(markets), fs.Arg(0), fs.Arg(1))
+    }
+    w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    for _, m := range matched {
+        pair := ""
+        if m.Base != "" || m.Quote != "" {
+            pair = m.Base + "/" + m.Quote
+        }
+        fmt.Fprintf(w, "%s\t%s\n", m.Symbol, pair)
+    }
+    return w.Flush()
+}
+
+// Market lists are cached in the user cache directory, they are also used to translate pairs, eg. BTC/USDT
+func setSymbolsCache(registry *exchange.Registry) {
+    cacheDir, err := os.UserCacheDir()
+    if err != nil {
+        logrus.Debugf("Market lists are not cached, error: %v", err)
+        return
+    }
+    registry.SetSymbolsCache(filepath.Join(cacheDir, "my-token"))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "sync"
+
+    "github.com/polyrabbit/my-token/alert"
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/polyrabbit/my-token/history"
+    "github.com/polyrabbit/my-token/http"
+    "github.com/polyrabbit/my-token/notify"
+    "github.com/polyrabbit/my-token/writer"
+    "github.com/sirupsen/logrus"
+    "github.com/spf13/pflag"
+)
+
+var watchCommand = &command{
+    name:    "watch",
+    args:    "[Exchange1.Token1 Exchange2.Token2.<api_key> ...]",
+    summary: "Track token prices of your favorite exchanges, the default command",
+    help: "Space-separated exchange.token pairs:\n" +
+        "  Specify which exchange and token pair to query, different exchanges use different forms to express tokens/trading pairs, " +
+        "find them with \"symbols\" (eg. \"Bitfinex.BTCUSDT\"), or write base/quote pairs translated by exchanges (eg. \"Kraken.BTC/USD\"). Optionally you can set api_key in the third place.\n" +
+        "  Pairs in the config file are tracked if none is given.",
+    flags: func(fs *pflag.FlagSet) {
+        config.AddWatchFlags(fs)
+        config.AddColumnFlags(fs)
+        config.AddOutputFlag(fs)
+        config.AddHistoryFlag(fs, "Save every fetched price to the specified database file, "+
+            "\nso change windows are computed from it on exchanges without klines")
+        config.AddConfigFlags(fs)
+        config.AddHTTPFlags(fs)
+        // Replaced by commands
+        fs.BoolP("list-exchanges", "l", false, "List supported exchanges")
+        fs.MarkDeprecated("list-exchanges", fmt.Sprintf("use \"%s exchanges\" instead", program))
+        fs.String("example-config-file", "", "Generate example config file to the specified file path")
+        fs.Lookup("example-config-file").NoOptDefVal = "-"
+        fs.MarkDeprecated("example-config-file", fmt.Sprintf("use \"%s config init <path>\" instead", program))
+        fs.BoolP("Version", "v", false, "Show Version number")
+        fs.MarkDeprecated("Version", fmt.Sprintf("use \"%s version\" instead", program))
+    },
+    run: runWatch,
+}
+
+func runWatch(ctx context.Context, fs *pflag.FlagSet) error {
+    if list, _ := fs.GetBool("list-exchanges"); list {
+        return exchangesCommand.run(ctx, fs)
+    }
+    if path, _ := fs.GetString("example-config-file"); path != "" {
+        return writeExampleConfig(path)
+    }
+    if version, _ := fs.GetBool("Version"); version {
+        return versionCommand.run(ctx, fs)
+    }
+
+    cfg, err := config.Load(fs)
+    if err != nil {
+        return err
+    }
+    if err := cfg.SetQueries(fs.Args()); err != nil {
+        return err
+    }
+    if len(cfg.Queries) == 0 {
+        printUsage()
+        return nil
+    }
+    httpClient, err := http.New(cfg)
+    if err != nil {
+        return err
+    }
+    registry := exchange.NewRegistry(cfg, httpClient)
+    setSymbolsCache(registry)
+    if cfg.Replay == "" {
+        go checkForUpdate(ctx, httpClient)
+    }
+
+    if cfg.Refresh != 0 {
+        logrus.Infof("Auto refresh on every %d seconds, giving up on exchanges not responding in %s", cfg.Refresh, cfg.RefreshDeadline())
+    }
+
+    priceWriter, err := writer.New(cfg)
+    if err != nil {
+        return err
+    }
+    alerts, err := alert.NewEngine(cfg.Alerts)
+    if err != nil {
+        return err
+    }
+    notifiers, err := notify.New(cfg.Notifiers, httpClient.Plain())
+    if err != nil {
+        return err
+    }
+    if len(notifiers) > 0 && alerts == nil {
+        logrus.Warnln("Notifiers are configured without any alert rule, nothing will be sent")
+    }
+    var store *history.Store
+    if cfg.History.Path != "" {
+        if store, err = history.Open(cfg.History); err != nil {
+            return fmt.Errorf("failed to open history %s: %w", cfg.History.Path, err)
+        }
+        defer store.Close()
+        registry.SetHistory(store)
+    }
+    if err := priceWriter.Start(); err != nil {
+        return fmt.Errorf("failed to start %s writer: %w", cfg.Output, err)
+    }
+    defer priceWriter.Stop()
+    var notifying sync.WaitGroup
+    defer notifying.Wait() // Before the writer stops, so failures are logged the same way
+
+    render := func(results []*exchange.SymbolResult) {
+        if alerts != nil {
+            fired := alerts.Evaluate(results)
+            for _, a := range fired {
+                logrus.Warnf("%s - Alert: %s", a.Source, a.Message)
+            }
+            if len(fired) > 0 && writer.IsInPlace(priceWriter) {
+                fmt.Fprint(os.Stderr, "\a") // Ring the terminal bell
+            }
+            if len(fired) > 0 && len(notifiers) > 0 {
+                notifying.Add(1)
+                go func() {
+                    defer notifying.Done()
+                    notify.Send(ctx, notifiers, fired)
+                }()
+            }
+        }
+        if store != nil {
+            if err := store.Save(results); err != nil {
+                logrus.Warnf("Failed to save prices to history, error: %v", err)
+            }
+        }
+        priceWriter.Render(results)
+    }
+
+    if cfg.Refresh == 0 {
+        refreshCtx, cancel := ctx, context.CancelFunc(func() {})
+        if deadline := cfg.RefreshDeadline(); deadline > 0 {
+            refreshCtx, cancel = context.WithTimeout(ctx, deadline)
+        }
+        defer cancel()
+        results := registry.GetSymbolPrices(refreshCtx, cfg.Queries)
+        if ctx.Err() != nil {
+            return nil // Interrupted, results are incomplete
+        }
+        render(results)
+        return nil
+    }
+
+    // Cycles start on a fixed cadence, a slow exchange only delays its own rows
+    scheduler := exchange.NewScheduler(cfg, registry)
+    scheduler.RenderOnArrival = writer.IsInPlace(priceWriter)
+    scheduler.Run(ctx, render)
+    return nil
+}
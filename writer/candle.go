@@ -0,0 +1,141 @@
+package writer
+
+import (
+    "bytes"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/fatih/color"
+    "github.com/mattn/go-colorable"
+    "github.com/olekukonko/tablewriter"
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+)
+
+// CandleSeries are candles of a symbol, printed by the ohlc command
+type CandleSeries struct {
+    Source   string
+    Symbol   string
+    Interval string // As given, eg. 1h
+    Candles  []exchange.Candle
+}
+
+var candleColumns = []string{"Time", "Open", "High", "Low", "Close", "Volume"}
+
+// Formatted fields of a candle, in the order of candleColumns, the volume is empty if unknown
+func candleFields(c exchange.Candle, openAt string) []string {
+    return []string{openAt, formatPrice(c.Open), formatPrice(c.High), formatPrice(c.Low),
+        formatPrice(c.Close), formatOptionalPrice(c.Volume)}
+}
+
+// RenderCandles prints series in the output format, a table per series, or all of them in one machine-readable
+// output keyed by source and symbol
+func RenderCandles(out io.Writer, output string, series []CandleSeries) error {
+    switch strings.ToLower(output) {
+    case config.OutputTable:
+        for _, s := range series {
+            renderCandleTable(out, s)
+        }
+    case config.OutputCSV, config.OutputTSV:
+        w := csv.NewWriter(out)
+        if strings.EqualFold(output, config.OutputTSV) {
+            w.Comma = '\t'
+        }
+        w.Write(append([]string{"source", "symbol"}, fieldKeys(candleColumns)...))
+        for _, s := range series {
+            for _, c := range s.Candles {
+                w.Write(append([]string{s.Source, s.Symbol}, candleFields(c, c.Time.UTC().Format(time.RFC3339))...))
+            }
+        }
+        w.Flush()
+        return w.Error()
+    case config.OutputJSON, config.OutputNDJSON:
+        var buf bytes.Buffer
+        ndjson := strings.EqualFold(output, config.OutputNDJSON)
+        if !ndjson {
+            buf.WriteByte('[')
+        }
+        first := true
+        for _, s := range series {
+            for _, c := range s.Candles {
+                if !first && !ndjson {
+                    buf.WriteByte(',')
+                }
+                first = false
+                encodeCandle(&buf, s, c)
+                if ndjson {
+                    buf.WriteByte('\n')
+                }
+            }
+        }
+        if !ndjson {
+            buf.WriteString("]\n")
+        }
+        _, err := out.Write(buf.Bytes())
+        return err
+    default:
+        return fmt.Errorf("unknown output format %q, expecting one of %s", output, strings.Join(GetAllNames(), ", "))
+    }
+    return nil
+}
+
+func fieldKeys(columns []string) []string {
+    keys := make([]string, len(columns))
+    for i, column := range columns {
+        keys[i] = fieldKey(column)
+    }
+    return keys
+}
+
+// Numbers are written as they are formatted, so trailing zeros are kept as in tables
+func encodeCandle(buf *bytes.Buffer, s CandleSeries, c exchange.Candle) {
+    source, _ := json.Marshal(s.Source)
+    symbol, _ := json.Marshal(s.Symbol)
+    fmt.Fprintf(buf, `{"source":%s,"symbol":%s`, source, symbol)
+    for i, value := range candleFields(c, c.Time.UTC().Format(time.RFC3339)) {
+        key := fieldKey(candleColumns[i])
+        switch {
+        case i == 0:
+            fmt.Fprintf(buf, `,"%s":"%s"`, key, value)
+        case value == "":
+            fmt.Fprintf(buf, `,"%s":null`, key)
+        default:
+            fmt.Fprintf(buf, `,"%s":%s`, key, value)
+        }
+    }
+    buf.WriteByte('}')
+}
+
+// Rising candles close in green, falling ones in red
+func renderCandleTable(out io.Writer, s CandleSeries) {
+    if out == io.Writer(os.Stdout) {
+        out = colorable.NewColorableStdout() // For Windows
+    }
+    table := tablewriter.NewWriter(out)
+    table.SetAutoFormatHeaders(false)
+    table.SetAutoWrapText(false)
+    headers := make([]string, len(candleColumns))
+    for i, column := range candleColumns {
+        headers[i] = color.YellowString(column)
+    }
+    table.SetHeader(headers)
+    table.SetCenterSeparator(faint("-"))
+    table.SetColumnSeparator(faint("|"))
+    table.SetRowSeparator(faint("-"))
+    table.SetCaption(true, fmt.Sprintf("%s %s, %s candles", s.Source, s.Symbol, s.Interval))
+    for _, c := range s.Candles {
+        row := candleFields(c, c.Time.Local().Format("2006-01-02 15:04"))
+        if c.Close.LessThan(c.Open) {
+            row[4] = color.RedString(row[4])
+        } else if c.Close.GreaterThan(c.Open) {
+            row[4] = color.GreenString(row[4])
+        }
+        table.Append(row)
+    }
+    table.Render()
+}
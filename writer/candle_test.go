@@ -0,0 +1,59 @@
+package writer
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/shopspring/decimal"
+)
+
+var testCandleSeries = []CandleSeries{{
+    Source:   "Binance",
+    Symbol:   "BTCUSDT",
+    Interval: "1h",
+    Candles: []exchange.Candle{
+        {Time: time.Date(2021, 12, 15, 7, 0, 0, 0, time.UTC), Open: decimal.RequireFromString("48000.5"), High: decimal.NewFromInt(48200),
+            Low: decimal.NewFromInt(47900), Close: decimal.NewFromInt(48100), Volume: decimal.RequireFromString("12.5")},
+        {Time: time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC), Open: decimal.NewFromInt(48100), High: decimal.NewFromInt(48150),
+            Low: decimal.NewFromInt(48050), Close: decimal.NewFromInt(48120)},
+    },
+}}
+
+func TestRenderCandles(t *testing.T) {
+    for _, tc := range []struct {
+        output string
+        want   string
+    }{
+        {config.OutputCSV, "source,symbol,time,open,high,low,close,volume\n" +
+            "Binance,BTCUSDT,2021-12-15T07:00:00Z,48000.5,48200,47900,48100,12.5\n" +
+            "Binance,BTCUSDT,2021-12-15T08:00:00Z,48100,48150,48050,48120,\n"},
+        {config.OutputJSON, `[{"source":"Binance","symbol":"BTCUSDT","time":"2021-12-15T07:00:00Z","open":48000.5,"high":48200,` +
+            `"low":47900,"close":48100,"volume":12.5},{"source":"Binance","symbol":"BTCUSDT","time":"2021-12-15T08:00:00Z",` +
+            `"open":48100,"high":48150,"low":48050,"close":48120,"volume":null}]` + "\n"},
+    } {
+        var out bytes.Buffer
+        if err := RenderCandles(&out, tc.output, testCandleSeries); err != nil {
+            t.Fatalf("Unexpected error of %s: %v", tc.output, err)
+        }
+        if out.String() != tc.want {
+            t.Fatalf("Expecting %s, got %s", tc.want, out.String())
+        }
+    }
+
+    var out bytes.Buffer
+    if err := RenderCandles(&out, config.OutputNDJSON, testCandleSeries); err != nil || strings.Count(out.String(), "\n") != 2 {
+        t.Fatalf("Expecting a line per candle, got %s, error: %v", out.String(), err)
+    }
+    out.Reset()
+    RenderCandles(&out, config.OutputTable, testCandleSeries)
+    if !strings.Contains(out.String(), "48000.5") || !strings.Contains(out.String(), "Binance BTCUSDT, 1h candles") {
+        t.Fatalf("Expecting candles in a table, got %s", out.String())
+    }
+    if err := RenderCandles(&out, "xml", testCandleSeries); err == nil {
+        t.Fatalf("Expecting error of unknown output")
+    }
+}
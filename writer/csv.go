@@ -0,0 +1,74 @@
+package writer
+
+import (
+    "encoding/csv"
+    "io"
+    "os"
+    "strconv"
+    "strings"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+)
+
+// csvWriter prints comma or tab separated values, the header is printed only once,
+// so refreshed rows can be appended to the same file. Unknown values are left empty, only symbol and source
+// are filled for failed queries, which tell why in the error column following the configured ones, then the stale column.
+type csvWriter struct {
+    out           io.Writer
+    columnNames   []string
+    holdings      holdings
+    comma         rune
+    headerWritten bool
+}
+
+func NewCSVWriter(cfg *config.Config) Writer {
+    cw := &csvWriter{out: os.Stdout, columnNames: cfg.Columns, holdings: holdingsOf(cfg), comma: ','}
+    if strings.EqualFold(cfg.Output, config.OutputTSV) {
+        cw.comma = '\t'
+    }
+    return cw
+}
+
+func (cw *csvWriter) Start() error {
+    return nil
+}
+
+func (cw *csvWriter) Stop() error {
+    return nil
+}
+
+func (cw *csvWriter) Render(results []*exchange.SymbolResult) {
+    w := csv.NewWriter(cw.out)
+    w.Comma = cw.comma
+    if !cw.headerWritten {
+        header := make([]string, 0, len(cw.columnNames)+2)
+        for _, name := range cw.columnNames {
+            header = append(header, fieldKey(name))
+        }
+        w.Write(append(header, "error", "stale"))
+        cw.headerWritten = true
+    }
+    for _, result := range results {
+        fs := fields(result, cw.columnNames, cw.holdings)
+        record := make([]string, 0, len(fs)+2)
+        for _, f := range fs {
+            if f.unknown {
+                record = append(record, "")
+            } else {
+                record = append(record, f.value)
+            }
+        }
+        errMsg := ""
+        if result.Err != nil {
+            errMsg = result.Err.Error()
+        }
+        w.Write(append(record, errMsg, strconv.FormatBool(result.Stale)))
+    }
+    w.Flush()
+}
+
+func init() {
+    Register(config.OutputCSV, NewCSVWriter)
+    Register(config.OutputTSV, NewCSVWriter)
+}
@@ -0,0 +1,44 @@
+package writer
+
+import (
+    "bytes"
+    "testing"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+)
+
+func TestCSVWriter(t *testing.T) {
+    t.Run("csv", func(t *testing.T) {
+        var out bytes.Buffer
+        cw := NewCSVWriter(&config.Config{Columns: allColumns(), Output: config.OutputCSV}).(*csvWriter)
+        cw.out = &out
+        cw.Render(resultsOf(testSymbolPrices...))
+        cw.Render(resultsOf(testSymbolPrices[:1]...))
+
+        want := "symbol,price,percent_change_1h,percent_change_24h,source,updated,error,stale\n" +
+            "BTCUSDT,48123.450,0.12,,Binance,2021-12-15T08:00:00Z,,false\n" +
+            "\"ETH\"\"USDT\",0.00000401,-1.00,2.50,Huobi,2021-12-15T08:00:00Z,,false\n" +
+            "BTCUSDT,48123.450,0.12,,Binance,2021-12-15T08:00:00Z,,false\n"
+        if out.String() != want {
+            t.Fatalf("Expecting %s, got %s", want, out.String())
+        }
+    })
+
+    t.Run("tsv", func(t *testing.T) {
+        var out bytes.Buffer
+        cw := NewCSVWriter(&config.Config{Columns: []string{config.ColumnSymbol, config.ColumnChange24hPct}, Output: config.OutputTSV}).(*csvWriter)
+        cw.out = &out
+        stale := &exchange.SymbolResult{Symbol: "ETHBTC", Source: "Binance", Err: exchange.ErrStale, Stale: true}
+        cw.Render(append(resultsOf(testSymbolPrices...), testFailedResult, stale))
+
+        want := "symbol\tpercent_change_24h\terror\tstale\n" +
+            "BTCUSDT\t\t\tfalse\n" +
+            "\"ETH\"\"USDT\"\t2.50\t\tfalse\n" +
+            "ABC123\t\tInvalid symbol.\tfalse\n" +
+            "ETHBTC\t\tstale, no response in time\ttrue\n"
+        if out.String() != want {
+            t.Fatalf("Expecting %q, got %q", want, out.String())
+        }
+    })
+}
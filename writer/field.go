@@ -0,0 +1,139 @@
+package writer
+
+import (
+    "strings"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/shopspring/decimal"
+)
+
+// A column of a symbol price, formatted for every output, the table only styles it
+type field struct {
+    key     string // eg. percent_change_1h
+    value   string
+    numeric bool
+    unknown bool             // Null in JSON
+    reason  string           // Why a change is unknown, eg. not supported
+    change  *exchange.Change // Of change columns, for highlighting, nil if not held for P&L%
+}
+
+// Turn column names into snake_case keys, eg. "%Change(24h)" -> "percent_change_24h", "P&L%" -> "pnl_percent"
+func fieldKey(column string) string {
+    key := strings.ToLower(column)
+    if strings.HasSuffix(key, "%") {
+        key = strings.TrimSuffix(key, "%") + "_percent"
+    }
+    key = strings.NewReplacer("%", "percent_", "(", "_", ")", "", " ", "_", "&", "n").Replace(key)
+    return strings.Trim(key, "_")
+}
+
+// Zero means unknown for optional fields
+func formatOptionalPrice(price decimal.Decimal) string {
+    if price.IsZero() {
+        return ""
+    }
+    return formatPrice(price)
+}
+
+// Empty for unknown statistics, a known zero is kept, eg. no volume in 24 hours
+func formatNullPrice(price decimal.NullDecimal) string {
+    if !price.Valid {
+        return ""
+    }
+    return formatPrice(price.Decimal)
+}
+
+// Keep trailing zeros as the exchange sent them, they tell the precision of a price
+func formatPrice(price decimal.Decimal) string {
+    if price.Exponent() < 0 {
+        return price.StringFixed(-price.Exponent())
+    }
+    return price.String()
+}
+
+// Keep the first line of an error, long ones would stretch a table
+func shortError(err error) string {
+    msg := strings.SplitN(err.Error(), "\n", 2)[0]
+    if runes := []rune(msg); len(runes) > 40 {
+        msg = string(runes[:39]) + "…"
+    }
+    return msg
+}
+
+// Only symbol and source are known if the query failed
+func errorFields(result *exchange.SymbolResult, columnNames []string) []field {
+    fs := make([]field, 0, len(columnNames))
+    for _, name := range columnNames {
+        f := field{key: fieldKey(name), unknown: true}
+        switch strings.ToLower(name) {
+        case strings.ToLower(config.ColumnSymbol):
+            f.value, f.unknown = result.Symbol, false
+        case strings.ToLower(config.ColumnSource):
+            f.value, f.unknown = result.Source, false
+        }
+        fs = append(fs, f)
+    }
+    return fs
+}
+
+// Portfolio columns are unknown for symbols not held
+func fields(result *exchange.SymbolResult, columnNames []string, h holdings) []field {
+    if result.Err != nil {
+        return errorFields(result, columnNames)
+    }
+    sp := result.Price
+    token, holding := h.of(result)
+    p := positionOf(token, sp)
+    fs := make([]field, 0, len(columnNames))
+    for _, name := range columnNames {
+        f := field{key: fieldKey(name)}
+        switch strings.ToLower(name) {
+        case strings.ToLower(config.ColumnSymbol):
+            f.value = sp.Symbol
+        case strings.ToLower(config.ColumnPrice):
+            f.value, f.numeric = formatPrice(sp.Price), true
+        case strings.ToLower(config.ColumnQuote):
+            f.value = sp.QuoteCurrency
+        case strings.ToLower(config.ColumnBid):
+            f.value, f.numeric, f.unknown = formatNullPrice(sp.Bid), true, !sp.Bid.Valid
+        case strings.ToLower(config.ColumnAsk):
+            f.value, f.numeric, f.unknown = formatNullPrice(sp.Ask), true, !sp.Ask.Valid
+        case strings.ToLower(config.ColumnHigh24h):
+            f.value, f.numeric, f.unknown = formatNullPrice(sp.High24h), true, !sp.High24h.Valid
+        case strings.ToLower(config.ColumnLow24h):
+            f.value, f.numeric, f.unknown = formatNullPrice(sp.Low24h), true, !sp.Low24h.Valid
+        case strings.ToLower(config.ColumnVolume24h):
+            f.value, f.numeric, f.unknown = formatNullPrice(sp.Volume24h), true, !sp.Volume24h.Valid
+        case strings.ToLower(config.ColumnSource):
+            f.value = sp.Source
+        case strings.ToLower(config.ColumnUpdated):
+            f.value = sp.UpdateAt.Format(time.RFC3339)
+        case strings.ToLower(config.ColumnHolding):
+            f.value, f.numeric, f.unknown = p.amount.String(), true, !holding
+        case strings.ToLower(config.ColumnValue):
+            f.value, f.numeric, f.unknown = formatValue(p.value), true, !holding
+        case strings.ToLower(config.ColumnPnL):
+            f.value, f.numeric, f.unknown = formatValue(p.pnl), true, !holding || !p.pnlKnown
+        case strings.ToLower(config.ColumnPnLPct):
+            change := p.pnlChange()
+            f.value, f.numeric, f.unknown = change.String(), true, !holding || !change.Known
+            if holding {
+                f.change = &change
+                if !change.Known {
+                    f.reason = string(change.Reason)
+                }
+            }
+        default:
+            window, _ := config.ParseChangeColumn(name) // Validated in New
+            change := sp.PercentChange(window)
+            f.value, f.numeric, f.unknown, f.change = change.String(), true, !change.Known, &change
+            if !change.Known {
+                f.reason = string(change.Reason)
+            }
+        }
+        fs = append(fs, f)
+    }
+    return fs
+}
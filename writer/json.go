@@ -0,0 +1,122 @@
+package writer
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "os"
+    "strings"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+)
+
+// jsonWriter prints a JSON array of all symbol prices on every refresh,
+// or one JSON object per line if in ndjson mode, failed ones come with an extra "error" key,
+// stale ones with "stale": true, and ones having alerts fired with an "alerts" array of messages.
+// Changes which are null are explained in a "reasons" object keyed by column, eg. {"percent_change_1h":"not supported"}
+type jsonWriter struct {
+    out         io.Writer
+    columnNames []string
+    holdings    holdings
+    ndjson      bool
+}
+
+func NewJSONWriter(cfg *config.Config) Writer {
+    return &jsonWriter{out: os.Stdout, columnNames: cfg.Columns, holdings: holdingsOf(cfg), ndjson: strings.EqualFold(cfg.Output, config.OutputNDJSON)}
+}
+
+func (jw *jsonWriter) Start() error {
+    return nil
+}
+
+func (jw *jsonWriter) Stop() error {
+    return nil
+}
+
+// Keys are written in the order of columns, which a map cannot keep
+func (jw *jsonWriter) encode(buf *bytes.Buffer, result *exchange.SymbolResult) {
+    written := 0
+    writeKey := func(key string) {
+        if written > 0 {
+            buf.WriteByte(',')
+        }
+        written++
+        k, _ := json.Marshal(key)
+        buf.Write(k)
+        buf.WriteByte(':')
+    }
+
+    buf.WriteByte('{')
+    var reasons []field
+    for _, f := range fields(result, jw.columnNames, jw.holdings) {
+        writeKey(f.key)
+        switch {
+        case f.unknown:
+            buf.WriteString("null")
+        case f.numeric:
+            buf.WriteString(f.value)
+        default:
+            value, _ := json.Marshal(f.value)
+            buf.Write(value)
+        }
+        if f.reason != "" {
+            reasons = append(reasons, f)
+        }
+    }
+    if len(reasons) > 0 {
+        writeKey("reasons")
+        buf.WriteByte('{')
+        for i, f := range reasons {
+            if i > 0 {
+                buf.WriteByte(',')
+            }
+            key, _ := json.Marshal(f.key)
+            reason, _ := json.Marshal(f.reason)
+            buf.Write(key)
+            buf.WriteByte(':')
+            buf.Write(reason)
+        }
+        buf.WriteByte('}')
+    }
+    if result.Err != nil {
+        errMsg, _ := json.Marshal(result.Err.Error())
+        writeKey("error")
+        buf.Write(errMsg)
+    }
+    if result.Stale {
+        writeKey("stale")
+        buf.WriteString("true")
+    }
+    if len(result.Alerts) > 0 {
+        alerts, _ := json.Marshal(result.Alerts)
+        writeKey("alerts")
+        buf.Write(alerts)
+    }
+    buf.WriteByte('}')
+}
+
+func (jw *jsonWriter) Render(results []*exchange.SymbolResult) {
+    var buf bytes.Buffer
+    if jw.ndjson {
+        for _, result := range results {
+            jw.encode(&buf, result)
+            buf.WriteByte('\n')
+        }
+    } else {
+        buf.WriteByte('[')
+        for i, result := range results {
+            if i > 0 {
+                buf.WriteByte(',')
+            }
+            jw.encode(&buf, result)
+        }
+        buf.WriteString("]\n")
+    }
+    jw.out.Write(buf.Bytes())
+}
+
+func init() {
+    Register(config.OutputJSON, NewJSONWriter)
+    Register(config.OutputNDJSON, NewJSONWriter)
+}
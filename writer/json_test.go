@@ -0,0 +1,153 @@
+package writer
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/shopspring/decimal"
+)
+
+var testSymbolPrices = []*exchange.SymbolPrice{
+    {
+        Symbol:           "BTCUSDT",
+        Price:            decimal.RequireFromString("48123.450"),
+        Source:           "Binance",
+        UpdateAt:         time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC),
+        PercentChange1h:  exchange.KnownChange(0.123456),
+        PercentChange24h: exchange.UnknownChange(exchange.ReasonFetchFailed),
+        Bid:              decimal.NewNullDecimal(decimal.RequireFromString("48123.40")),
+        Volume24h:        decimal.NewNullDecimal(decimal.RequireFromString("1234.5678")),
+        QuoteCurrency:    "USDT",
+    },
+    {
+        Symbol:           "ETH\"USDT",
+        Price:            decimal.RequireFromString("0.00000401"),
+        Source:           "Huobi",
+        UpdateAt:         time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC),
+        PercentChange1h:  exchange.KnownChange(-1),
+        PercentChange24h: exchange.KnownChange(2.5),
+    },
+}
+
+var testFailedResult = &exchange.SymbolResult{Symbol: "ABC123", Source: "Binance", Err: errors.New("Invalid symbol.")}
+
+func resultsOf(sps ...*exchange.SymbolPrice) []*exchange.SymbolResult {
+    results := make([]*exchange.SymbolResult, len(sps))
+    for i, sp := range sps {
+        results[i] = &exchange.SymbolResult{Symbol: sp.Symbol, Source: sp.Source, Price: sp}
+    }
+    return results
+}
+
+func TestJSONWriter(t *testing.T) {
+    t.Run("json", func(t *testing.T) {
+        var out bytes.Buffer
+        jw := NewJSONWriter(&config.Config{Columns: allColumns(), Output: config.OutputJSON}).(*jsonWriter)
+        jw.out = &out
+        jw.Render(resultsOf(testSymbolPrices...))
+
+        want := `[{"symbol":"BTCUSDT","price":48123.450,"percent_change_1h":0.12,"percent_change_24h":null,"source":"Binance","updated":"2021-12-15T08:00:00Z","reasons":{"percent_change_24h":"fetch failed"}},` +
+            `{"symbol":"ETH\"USDT","price":0.00000401,"percent_change_1h":-1.00,"percent_change_24h":2.50,"source":"Huobi","updated":"2021-12-15T08:00:00Z"}]` + "\n"
+        if out.String() != want {
+            t.Fatalf("Expecting %s, got %s", want, out.String())
+        }
+    })
+
+    t.Run("statistics", func(t *testing.T) {
+        var out bytes.Buffer
+        jw := NewJSONWriter(&config.Config{Columns: []string{config.ColumnQuote, config.ColumnBid, config.ColumnAsk, config.ColumnVolume24h}, Output: config.OutputJSON}).(*jsonWriter)
+        jw.out = &out
+        jw.Render(resultsOf(testSymbolPrices[:1]...))
+
+        want := `[{"quote":"USDT","bid":48123.40,"ask":null,"volume_24h":1234.5678}]` + "\n"
+        if out.String() != want {
+            t.Fatalf("Expecting %s, got %s", want, out.String())
+        }
+    })
+
+    t.Run("change windows", func(t *testing.T) {
+        var out bytes.Buffer
+        jw := NewJSONWriter(&config.Config{Columns: []string{config.ColumnSymbol, "%Change(60m)", "%change(7d)", "%Change(15m)"}, Output: config.OutputNDJSON}).(*jsonWriter)
+        jw.out = &out
+        sp := *testSymbolPrices[0]
+        sp.PercentChanges = map[time.Duration]exchange.Change{7 * 24 * time.Hour: exchange.KnownChange(-12.345)}
+        jw.Render(resultsOf(&sp))
+
+        want := `{"symbol":"BTCUSDT","percent_change_60m":0.12,"percent_change_7d":-12.35,"percent_change_15m":null,"reasons":{"percent_change_15m":"not supported"}}` + "\n"
+        if out.String() != want {
+            t.Fatalf("Expecting %s, got %s", want, out.String())
+        }
+    })
+
+    t.Run("failed queries", func(t *testing.T) {
+        var out bytes.Buffer
+        jw := NewJSONWriter(&config.Config{Columns: []string{config.ColumnSymbol, config.ColumnPrice, config.ColumnSource}, Output: config.OutputNDJSON}).(*jsonWriter)
+        jw.out = &out
+        jw.Render(append(resultsOf(testSymbolPrices[0]), testFailedResult))
+
+        want := `{"symbol":"BTCUSDT","price":48123.450,"source":"Binance"}` + "\n" +
+            `{"symbol":"ABC123","price":null,"source":"Binance","error":"Invalid symbol."}` + "\n"
+        if out.String() != want {
+            t.Fatalf("Expecting %s, got %s", want, out.String())
+        }
+    })
+
+    t.Run("stale", func(t *testing.T) {
+        var out bytes.Buffer
+        jw := NewJSONWriter(&config.Config{Columns: []string{config.ColumnSymbol, config.ColumnPrice}, Output: config.OutputNDJSON}).(*jsonWriter)
+        jw.out = &out
+        stale := resultsOf(testSymbolPrices[0])
+        stale[0].Stale = true
+        jw.Render(stale)
+
+        want := `{"symbol":"BTCUSDT","price":48123.450,"stale":true}` + "\n"
+        if out.String() != want {
+            t.Fatalf("Expecting %s, got %s", want, out.String())
+        }
+
+        out.Reset()
+        jw.columnNames = nil
+        jw.Render([]*exchange.SymbolResult{{Symbol: "BTCUSDT", Err: exchange.ErrStale, Stale: true}})
+        want = `{"error":"stale, no response in time","stale":true}` + "\n"
+        if out.String() != want {
+            t.Fatalf("Expecting %s, got %s", want, out.String())
+        }
+    })
+
+    t.Run("alerts", func(t *testing.T) {
+        var out bytes.Buffer
+        jw := NewJSONWriter(&config.Config{Columns: []string{config.ColumnSymbol}, Output: config.OutputNDJSON}).(*jsonWriter)
+        jw.out = &out
+        alerted := resultsOf(testSymbolPrices[0])
+        alerted[0].Alerts = []string{"BTCUSDT rose above 48000, at 48123.450"}
+        jw.Render(alerted)
+
+        want := `{"symbol":"BTCUSDT","alerts":["BTCUSDT rose above 48000, at 48123.450"]}` + "\n"
+        if out.String() != want {
+            t.Fatalf("Expecting %s, got %s", want, out.String())
+        }
+    })
+
+    t.Run("ndjson", func(t *testing.T) {
+        var out bytes.Buffer
+        jw := NewJSONWriter(&config.Config{Columns: []string{"symbol", "Price"}, Output: config.OutputNDJSON}).(*jsonWriter)
+        jw.out = &out
+        jw.Render(resultsOf(testSymbolPrices...))
+        jw.Render(resultsOf(testSymbolPrices[:1]...))
+
+        want := `{"symbol":"BTCUSDT","price":48123.450}` + "\n" +
+            `{"symbol":"ETH\"USDT","price":0.00000401}` + "\n" +
+            `{"symbol":"BTCUSDT","price":48123.450}` + "\n"
+        if out.String() != want {
+            t.Fatalf("Expecting %s, got %s", want, out.String())
+        }
+    })
+}
+
+func allColumns() []string {
+    return []string{config.ColumnSymbol, config.ColumnPrice, config.ColumnChange1hPct, config.ColumnChange24hPct, config.ColumnSource, config.ColumnUpdated}
+}
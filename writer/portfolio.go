@@ -0,0 +1,147 @@
+package writer
+
+import (
+    "sort"
+    "strings"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/shopspring/decimal"
+)
+
+// holdings are tokens having an amount, keyed by exchange and symbol, eg. "BINANCE.BTCUSDT"
+type holdings map[string]config.Token
+
+func holdingKey(exchangeName, symbol string) string {
+    return strings.ToUpper(exchangeName) + "." + strings.ToUpper(symbol)
+}
+
+func holdingsOf(cfg *config.Config) holdings {
+    h := make(holdings)
+    for _, query := range cfg.Queries {
+        for _, token := range query.Tokens {
+            if token.IsHolding() {
+                h[holdingKey(query.Name, token.Symbol)] = token
+            }
+        }
+    }
+    return h
+}
+
+// Holding of the queried symbol, false if none is held
+func (h holdings) of(result *exchange.SymbolResult) (config.Token, bool) {
+    token, ok := h[holdingKey(result.Source, result.Symbol)]
+    return token, ok
+}
+
+// position is a holding valued at the current price, P&L is known only if the cost basis is given
+type position struct {
+    amount   decimal.Decimal
+    value    decimal.Decimal
+    cost     decimal.Decimal
+    pnl      decimal.Decimal
+    pnlKnown bool
+}
+
+func positionOf(token config.Token, sp *exchange.SymbolPrice) position {
+    p := position{amount: token.Amount, value: token.Amount.Mul(sp.Price), cost: token.CostBasis}
+    if !token.CostBasis.IsZero() {
+        p.pnl, p.pnlKnown = p.value.Sub(token.CostBasis), true
+    }
+    return p
+}
+
+// P&L in percentage of the cost basis
+func (p position) pnlChange() exchange.Change {
+    if !p.pnlKnown {
+        return exchange.UnknownChange(exchange.ReasonNotSupported)
+    }
+    return pnlChange(p.pnl, p.cost)
+}
+
+func pnlChange(pnl, cost decimal.Decimal) exchange.Change {
+    if cost.IsZero() {
+        return exchange.UnknownChange(exchange.ReasonNotSupported)
+    }
+    pct, _ := pnl.Div(cost).Shift(2).Float64()
+    return exchange.KnownChange(pct)
+}
+
+// Values are amounts of the quote currency, cents are enough unless it's a tiny one
+func formatValue(value decimal.Decimal) string {
+    if value.Abs().GreaterThanOrEqual(decimal.NewFromInt(1)) {
+        return value.StringFixed(2)
+    }
+    return value.Round(8).String()
+}
+
+// Price of a symbol 24 hours ago, derived from the change if the exchange only tells that
+func price24hAgo(sp *exchange.SymbolPrice) (decimal.Decimal, bool) {
+    if !sp.Price24hAgo.IsZero() {
+        return sp.Price24hAgo, true
+    }
+    if !sp.PercentChange24h.Known || sp.PercentChange24h.Percent <= -100 {
+        return decimal.Zero, false
+    }
+    return sp.Price.Div(decimal.NewFromFloat(1 + sp.PercentChange24h.Percent/100)), true
+}
+
+// portfolioTotal sums up holdings quoted in the same currency, values in different currencies don't add up
+type portfolioTotal struct {
+    quote    string
+    value    decimal.Decimal
+    value24h decimal.Decimal // Value of the same amounts 24 hours ago
+    known24h bool            // False if any holding doesn't tell its price 24 hours ago
+    cost     decimal.Decimal // Of holdings with a cost basis only
+    pnl      decimal.Decimal
+    hasPnL   bool
+}
+
+func (t *portfolioTotal) change24h() exchange.Change {
+    if !t.known24h || t.value24h.IsZero() {
+        return exchange.UnknownChange(exchange.ReasonFetchFailed)
+    }
+    pct, _ := t.value.Sub(t.value24h).Div(t.value24h).Shift(2).Float64()
+    return exchange.KnownChange(pct)
+}
+
+func (t *portfolioTotal) pnlChange() exchange.Change {
+    if !t.hasPnL {
+        return exchange.UnknownChange(exchange.ReasonNotSupported)
+    }
+    return pnlChange(t.pnl, t.cost)
+}
+
+// Total holdings of results by quote currency, sorted by it. Failed queries are left out, as their prices are unknown,
+// stale ones are valued at the last known price.
+func portfolioTotals(h holdings, results []*exchange.SymbolResult) []*portfolioTotal {
+    byQuote := make(map[string]*portfolioTotal)
+    for _, result := range results {
+        token, ok := h.of(result)
+        if !ok || result.Err != nil {
+            continue
+        }
+        sp := result.Price
+        total := byQuote[sp.QuoteCurrency]
+        if total == nil {
+            total = &portfolioTotal{quote: sp.QuoteCurrency, known24h: true}
+            byQuote[sp.QuoteCurrency] = total
+        }
+        p := positionOf(token, sp)
+        total.value = total.value.Add(p.value)
+        if ago, ok := price24hAgo(sp); ok {
+            total.value24h = total.value24h.Add(token.Amount.Mul(ago))
+        } else {
+            total.known24h = false
+        }
+        if p.pnlKnown {
+            total.cost, total.pnl, total.hasPnL = total.cost.Add(p.cost), total.pnl.Add(p.pnl), true
+        }
+    }
+    totals := make([]*portfolioTotal, 0, len(byQuote))
+    for _, total := range byQuote {
+        totals = append(totals, total)
+    }
+    sort.Slice(totals, func(i, j int) bool { return totals[i].quote < totals[j].quote })
+    return totals
+}
@@ -0,0 +1,87 @@
+package writer
+
+import (
+    "bytes"
+    "testing"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+    "github.com/shopspring/decimal"
+)
+
+func testPortfolioConfig(columns ...string) *config.Config {
+    return &config.Config{Columns: columns, Queries: []*config.PriceQuery{
+        {Name: "binance", Tokens: []config.Token{
+            {Symbol: "btcusdt", Amount: decimal.RequireFromString("0.5"), CostBasis: decimal.RequireFromString("20000")},
+        }},
+        {Name: "Huobi", Tokens: []config.Token{
+            {Symbol: "ETH\"USDT", Amount: decimal.RequireFromString("1000")},
+            {Symbol: "Unheld"},
+        }},
+    }}
+}
+
+func TestPortfolioFields(t *testing.T) {
+    var out bytes.Buffer
+    cfg := testPortfolioConfig(config.ColumnSymbol, config.ColumnHolding, config.ColumnValue, config.ColumnPnL, config.ColumnPnLPct)
+    cfg.Output = config.OutputNDJSON
+    jw := NewJSONWriter(cfg).(*jsonWriter)
+    jw.out = &out
+    unheld := *testSymbolPrices[1]
+    unheld.Symbol = "Unheld"
+    jw.Render(resultsOf(testSymbolPrices[0], testSymbolPrices[1], &unheld))
+
+    want := `{"symbol":"BTCUSDT","holding":0.5,"value":24061.73,"pnl":4061.73,"pnl_percent":20.31}` + "\n" +
+        `{"symbol":"ETH\"USDT","holding":1000,"value":0.00401,"pnl":null,"pnl_percent":null,"reasons":{"pnl_percent":"not supported"}}` + "\n" +
+        `{"symbol":"Unheld","holding":null,"value":null,"pnl":null,"pnl_percent":null}` + "\n"
+    if out.String() != want {
+        t.Fatalf("Expecting %s, got %s", want, out.String())
+    }
+}
+
+func TestPortfolioTotals(t *testing.T) {
+    h := holdingsOf(testPortfolioConfig())
+    btc, eth := *testSymbolPrices[0], *testSymbolPrices[1]
+    btc.Price24hAgo = decimal.RequireFromString("40000")
+    eth.QuoteCurrency = "USDT"
+    eth2 := eth
+    eth2.Source = "Binance"
+    eth2.Symbol = "BTCUSDT"
+    eth2.QuoteCurrency = "BTC"
+    eth2.PercentChange24h = exchange.UnknownChange(exchange.ReasonFetchFailed)
+
+    totals := portfolioTotals(h, append(resultsOf(&btc, &eth), testFailedResult))
+    if len(totals) != 1 {
+        t.Fatalf("Expecting totals of USDT only, got %d", len(totals))
+    }
+    total := totals[0]
+    if total.quote != "USDT" || total.value.String() != "24061.72901" {
+        t.Fatalf("Unexpected total %s %s", total.value, total.quote)
+    }
+    // 0.5 * 40000 + 1000 * 0.00000401 / 1.025
+    if change := total.change24h(); !change.Known || change.String() != "20.31" {
+        t.Fatalf("Unexpected 24h change %+v", change)
+    }
+    if !total.hasPnL || total.pnl.String() != "4061.725" || total.pnlChange().String() != "20.31" {
+        t.Fatalf("Expecting P&L of BTCUSDT only, got %s", total.pnl)
+    }
+
+    totals = portfolioTotals(h, resultsOf(&btc, &eth2))
+    if len(totals) != 2 || totals[0].quote != "BTC" || totals[0].change24h().Known {
+        t.Fatalf("Expecting totals by quote currency, got %+v", totals)
+    }
+}
+
+func TestTableWriter_footer(t *testing.T) {
+    columns := []string{config.ColumnSymbol, config.ColumnPrice, config.ColumnValue, config.ColumnPnL, config.ColumnSource}
+    tw := NewTableWriter(testPortfolioConfig(columns...)).(*tableWriter)
+    btc, eth := *testSymbolPrices[0], *testSymbolPrices[1]
+    footer := tw.footer(portfolioTotals(tw.holdings, resultsOf(&btc, &eth)))
+
+    want := []string{"Total\nTotal USDT", "", "0.00401\n24061.73", "\n4061.73", ""}
+    for i := range want {
+        if footer[i] != want[i] {
+            t.Fatalf("Expecting footer %q, got %q", want, footer)
+        }
+    }
+}
@@ -1,10 +1,6 @@
 package writer
 
 import (
-    "fmt"
-    "math"
-    "os"
-    "strconv"
     "strings"
 
     "github.com/fatih/color"
@@ -13,19 +9,25 @@ import (
     "github.com/olekukonko/tablewriter"
     "github.com/polyrabbit/my-token/config"
     "github.com/polyrabbit/my-token/exchange"
+    "github.com/sirupsen/logrus"
 )
 
-var faint = color.New(color.Faint).SprintFunc()
+var (
+    faint = color.New(color.Faint).SprintFunc()
+    // Rows of symbols having alerts fired
+    alerted = color.New(color.ReverseVideo).SprintFunc()
+)
 
 type tableWriter struct {
     *uilive.Writer
     table       *tablewriter.Table
     columnNames []string
+    holdings    holdings
 }
 
 // Set up ascii table writer
-func NewTableWriter(cfg *config.Config) *tableWriter {
-    tw := &tableWriter{Writer: uilive.New(), columnNames: cfg.Columns}
+func NewTableWriter(cfg *config.Config) Writer {
+    tw := &tableWriter{Writer: uilive.New(), columnNames: cfg.Columns, holdings: holdingsOf(cfg)}
     tw.Writer.Out = colorable.NewColorableStdout() // For Windows
     tw.table = tablewriter.NewWriter(tw.Writer)
     tw.table.SetAutoFormatHeaders(false)
@@ -42,14 +44,29 @@ func NewTableWriter(cfg *config.Config) *tableWriter {
     return tw
 }
 
-func (tw *tableWriter) highlightChange(changePct float64) string {
-    if changePct == math.MaxFloat64 {
-        return ""
-    }
-    changeText := strconv.FormatFloat(changePct, 'f', 2, 64)
-    if changePct == 0 {
+// Logs are printed above the table, so they won't be overwritten by the next refresh
+func (tw *tableWriter) Start() error {
+    logrus.SetOutput(tw)
+    return nil
+}
+
+func (tw *tableWriter) Stop() error {
+    logrus.SetOutput(colorable.NewColorableStderr())
+    return nil
+}
+
+// The table is redrawn in place, so it's fine to render on every arrival
+func (tw *tableWriter) InPlace() bool {
+    return true
+}
+
+func (tw *tableWriter) highlightChange(change exchange.Change) string {
+    changeText := change.String()
+    if !change.Known {
+        changeText = faint(changeText)
+    } else if change.Percent == 0 {
         changeText = faint("0")
-    } else if changePct > 0 {
+    } else if change.Percent > 0 {
         changeText = color.GreenString(changeText)
     } else {
         changeText = color.RedString(changeText)
@@ -57,34 +74,131 @@ func (tw *tableWriter) highlightChange(changePct float64) string {
     return changeText
 }
 
-func (tw *tableWriter) Render(symbolPriceList []*exchange.SymbolPrice) {
+// Show the reason in red in place of the first column other than symbol and source
+func (tw *tableWriter) errorRow(result *exchange.SymbolResult) []string {
+    columns := make([]string, len(tw.columnNames))
+    reasonShown := false
+    for i, name := range tw.columnNames {
+        switch strings.ToLower(name) {
+        case strings.ToLower(config.ColumnSymbol):
+            columns[i] = result.Symbol
+        case strings.ToLower(config.ColumnSource):
+            columns[i] = result.Source
+        default:
+            if !reasonShown {
+                columns[i] = color.RedString(shortError(result.Err))
+                reasonShown = true
+            }
+        }
+    }
+    return columns
+}
+
+// Portfolio totals are left empty without P&L, eg. no cost of any holding
+func (tw *tableWriter) holdingCell(held bool, text string) string {
+    if !held {
+        return ""
+    }
+    return text
+}
+
+// Portfolio totals under value, P&L and their changes, one line per quote currency,
+// labeled in the first column left empty
+func (tw *tableWriter) footer(totals []*portfolioTotal) []string {
+    footer := make([]string, len(tw.columnNames))
+    if len(totals) == 0 {
+        return footer
+    }
+    lines := func(text func(total *portfolioTotal) string) string {
+        texts := make([]string, len(totals))
+        for i, total := range totals {
+            texts[i] = text(total)
+        }
+        return strings.Join(texts, "\n")
+    }
+    labelAt := -1
+    for i, name := range tw.columnNames {
+        switch strings.ToLower(name) {
+        case strings.ToLower(config.ColumnValue):
+            footer[i] = lines(func(total *portfolioTotal) string { return formatValue(total.value) })
+        case strings.ToLower(config.ColumnChange24hPct):
+            footer[i] = lines(func(total *portfolioTotal) string { return tw.highlightChange(total.change24h()) })
+        case strings.ToLower(config.ColumnPnL):
+            footer[i] = lines(func(total *portfolioTotal) string {
+                return tw.holdingCell(total.hasPnL, formatValue(total.pnl))
+            })
+        case strings.ToLower(config.ColumnPnLPct):
+            footer[i] = lines(func(total *portfolioTotal) string {
+                return tw.holdingCell(total.hasPnL, tw.highlightChange(total.pnlChange()))
+            })
+        default:
+            if labelAt == -1 {
+                labelAt = i
+            }
+        }
+    }
+    if labelAt != -1 {
+        footer[labelAt] = lines(func(total *portfolioTotal) string {
+            return strings.TrimSpace("Total " + total.quote)
+        })
+    }
+    return footer
+}
+
+// Style a field for the terminal, unknown ones are left empty except changes
+func (tw *tableWriter) cell(result *exchange.SymbolResult, name string, f field) string {
+    switch strings.ToLower(name) {
+    case strings.ToLower(config.ColumnPrice):
+        if result.Stale {
+            return faint(f.value)
+        }
+    case strings.ToLower(config.ColumnUpdated):
+        updated := result.Price.UpdateAt.Local().Format("15:04:05")
+        if result.Stale {
+            updated += color.YellowString(" (stale)")
+        }
+        return updated
+    }
+    if f.change != nil {
+        return tw.highlightChange(*f.change)
+    }
+    if f.unknown {
+        return ""
+    }
+    return f.value
+}
+
+func (tw *tableWriter) Render(results []*exchange.SymbolResult) {
     tw.table.ClearRows()
     // Fill in data
-    for _, sp := range symbolPriceList {
-        var columns []string
-        for _, name := range tw.columnNames {
-            switch strings.ToLower(name) {
-            case strings.ToLower(config.ColumnSymbol):
-                columns = append(columns, sp.Symbol)
-            case strings.ToLower(config.ColumnPrice):
-                columns = append(columns, sp.Price)
-            case strings.ToLower(config.ColumnChange1hPct):
-                columns = append(columns, tw.highlightChange(sp.PercentChange1h))
-            case strings.ToLower(config.ColumnChange24hPct):
-                columns = append(columns, tw.highlightChange(sp.PercentChange24h))
-            case strings.ToLower(config.ColumnSource):
-                columns = append(columns, sp.Source)
-            case strings.ToLower(config.ColumnUpdated):
-                columns = append(columns, sp.UpdateAt.Local().Format("15:04:05"))
-            default:
-                fmt.Fprintf(os.Stderr, "Unknown column: %q\n", name)
-                os.Exit(1)
+    for _, result := range results {
+        if result.Err != nil {
+            tw.table.Append(tw.errorRow(result))
+            continue
+        }
+        fs := fields(result, tw.columnNames, tw.holdings)
+        columns := make([]string, len(fs))
+        for i, f := range fs {
+            columns[i] = tw.cell(result, tw.columnNames[i], f)
+        }
+        if len(result.Alerts) > 0 {
+            for i, column := range columns {
+                if column != "" {
+                    columns[i] = alerted(column)
+                }
             }
-
         }
         tw.table.Append(columns)
     }
+    tw.table.ClearFooter()
+    if len(tw.holdings) > 0 {
+        tw.table.SetFooter(tw.footer(portfolioTotals(tw.holdings, results)))
+    }
 
     tw.table.Render()
     tw.Flush()
 }
+
+func init() {
+    Register(config.OutputTable, NewTableWriter)
+}
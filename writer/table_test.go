@@ -0,0 +1,54 @@
+package writer
+
+import (
+    "errors"
+    "strings"
+    "testing"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+)
+
+func TestTableWriter_errorRow(t *testing.T) {
+    t.Run("reason in place of price", func(t *testing.T) {
+        tw := NewTableWriter(&config.Config{Columns: allColumns()}).(*tableWriter)
+        row := tw.errorRow(testFailedResult)
+        if row[0] != "ABC123" || row[1] != "Invalid symbol." || row[4] != "Binance" {
+            t.Fatalf("Unexpected error row %q", row)
+        }
+        for _, i := range []int{2, 3, 5} {
+            if row[i] != "" {
+                t.Fatalf("Expecting other columns empty, got %q", row)
+            }
+        }
+    })
+
+    t.Run("long reason", func(t *testing.T) {
+        tw := NewTableWriter(&config.Config{Columns: []string{config.ColumnSymbol, config.ColumnUpdated}}).(*tableWriter)
+        result := *testFailedResult
+        result.Err = errors.New("Get \"https://api.binance.com/api/v1/ticker/24hr?symbol=ABC123\": dial tcp: i/o timeout\nmore")
+        row := tw.errorRow(&result)
+        if row[1] != "Get \"https://api.binance.com/api/v1/tic…" {
+            t.Fatalf("Expecting a truncated reason, got %q", row[1])
+        }
+    })
+}
+
+func TestTableWriter_cell(t *testing.T) {
+    columns := []string{config.ColumnSymbol, config.ColumnPrice, config.ColumnBid, config.ColumnAsk, config.ColumnChange1hPct, config.ColumnChange24hPct, config.ColumnUpdated}
+    tw := NewTableWriter(&config.Config{Columns: columns}).(*tableWriter)
+    result := &exchange.SymbolResult{Symbol: "BTCUSDT", Source: "Binance", Price: testSymbolPrices[0], Stale: true}
+    var row []string
+    for i, f := range fields(result, columns, tw.holdings) {
+        row = append(row, tw.cell(result, columns[i], f))
+    }
+    want := []string{"BTCUSDT", "48123.450", "48123.40", "", "0.12", "n/a"}
+    for i, cell := range want {
+        if row[i] != cell {
+            t.Fatalf("Expecting %q in %s, got %q", cell, columns[i], row)
+        }
+    }
+    if !strings.HasSuffix(row[6], " (stale)") {
+        t.Fatalf("Expecting stale marked, got %q", row[6])
+    }
+}
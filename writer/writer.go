@@ -0,0 +1,67 @@
+package writer
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+
+    "github.com/polyrabbit/my-token/config"
+    "github.com/polyrabbit/my-token/exchange"
+)
+
+// Writer renders results of symbol queries, Render is called on every refresh between Start and Stop
+type Writer interface {
+    // Start is called once before the first Render
+    Start() error
+    // Results come in the order of queries, failed ones should be rendered too, so users know what's missing
+    Render([]*exchange.SymbolResult)
+    // Stop is called once after the last Render, it should undo whatever Start has done
+    Stop() error
+}
+
+// InPlaceWriter is implemented by writers redrawing the screen instead of appending to the output,
+// they can afford rendering every time a result arrives
+type InPlaceWriter interface {
+    InPlace() bool
+}
+
+// IsInPlace tells if w redraws in place
+func IsInPlace(w Writer) bool {
+    ipw, ok := w.(InPlaceWriter)
+    return ok && ipw.InPlace()
+}
+
+type WriterProvider func(cfg *config.Config) Writer
+
+var providers = make(map[string]WriterProvider)
+
+// Register a writer for the output format, the name is case-insensitive
+func Register(name string, p WriterProvider) {
+    name = strings.ToLower(name)
+    if _, exist := providers[name]; exist {
+        panic(fmt.Errorf("%q already exists in writer registry", name))
+    }
+    providers[name] = p
+}
+
+func GetAllNames() []string {
+    names := make([]string, 0, len(providers))
+    for name := range providers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// New creates the writer of output format in config
+func New(cfg *config.Config) (Writer, error) {
+    p, ok := providers[strings.ToLower(cfg.Output)]
+    if !ok {
+        return nil, fmt.Errorf("unknown output format %q, expecting one of %s", cfg.Output, strings.Join(GetAllNames(), ", "))
+    }
+    // Writers take any other column as a change window
+    if err := config.ValidateColumns(cfg.Columns); err != nil {
+        return nil, err
+    }
+    return p(cfg), nil
+}
@@ -0,0 +1,45 @@
+package writer
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/polyrabbit/my-token/config"
+)
+
+func TestNew(t *testing.T) {
+    t.Run("builtin", func(t *testing.T) {
+        for _, output := range []string{"table", "JSON", "ndjson", "csv", "TSV"} {
+            w, err := New(&config.Config{Output: output})
+            if err != nil {
+                t.Fatalf("Unexpected error: %v", err)
+            }
+            if w == nil {
+                t.Fatalf("Expecting a writer for %s", output)
+            }
+        }
+        w, _ := New(&config.Config{Output: "NDJSON"})
+        if !w.(*jsonWriter).ndjson {
+            t.Fatalf("Expecting ndjson mode")
+        }
+    })
+
+    t.Run("unknown", func(t *testing.T) {
+        _, err := New(&config.Config{Output: "xml"})
+        if err == nil {
+            t.Fatalf("Should throw on unknown output format")
+        }
+        if !strings.Contains(err.Error(), "csv, json, ndjson, table, tsv") {
+            t.Fatalf("Expecting supported formats in error, got %v", err)
+        }
+    })
+
+    t.Run("unknown column", func(t *testing.T) {
+        if _, err := New(&config.Config{Output: "table", Columns: []string{"Price", "Colour"}}); err == nil {
+            t.Fatalf("Should throw on unknown columns")
+        }
+        if _, err := New(&config.Config{Output: "csv", Columns: []string{"Price", "%Change(15m)"}}); err != nil {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+    })
+}